@@ -0,0 +1,67 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lineCapture is what was captured for one variable on one line.
+type lineCapture struct {
+	Name  string
+	Last  any
+	Count int
+}
+
+func (s *RunState) recordLine(line int, name string, value any) {
+	if s.LineCaptures == nil {
+		s.LineCaptures = make(map[int][]*lineCapture)
+	}
+	for _, c := range s.LineCaptures[line] {
+		if c.Name == name {
+			c.Last = value
+			c.Count++
+			return
+		}
+	}
+	s.LineCaptures[line] = append(s.LineCaptures[line], &lineCapture{Name: name, Last: value, Count: 1})
+}
+
+// writeAnnotatedSource writes the original script with the last value
+// captured on each line as a trailing comment, e.g. `let x = f() // x = 42`.
+func writeAnnotatedSource(path string, state *RunState, opts *Config) {
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", path, err)
+		return
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for i, line := range state.Source {
+		captures := state.LineCaptures[i+1]
+		if len(captures) == 0 {
+			fmt.Fprintln(writer, line)
+			continue
+		}
+
+		fmt.Fprintf(writer, "%s // %s\n", strings.TrimRight(line, " \t"), strings.Join(captureNotes(captures, opts.MaxValueLen), ", "))
+	}
+	writer.Flush()
+}
+
+// captureNotes renders what a line captured, `x = 42` or `i = 3 (last of
+// 4)` per variable, each on one line.
+func captureNotes(captures []*lineCapture, limit int) []string {
+	notes := make([]string, len(captures))
+	for i, c := range captures {
+		value := strings.ReplaceAll(renderValue(c.Last, limit), "\n", `\n`)
+		if c.Count > 1 {
+			notes[i] = fmt.Sprintf("%s = %s (last of %d)", c.Name, value, c.Count)
+		} else {
+			notes[i] = fmt.Sprintf("%s = %s", c.Name, value)
+		}
+	}
+	return notes
+}
@@ -0,0 +1,188 @@
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+// AsyncTask is the timers one line sets, with setTimeout, setInterval or
+// setImmediate, or an await: how often they were set and their callback
+// ran, or how often the await was reached and resumed.
+type AsyncTask struct {
+	Kind  string
+	Line  int
+	Func  string
+	Delay time.Duration
+
+	Set, Ran int
+}
+
+func (t *AsyncTask) String() string {
+	where := fmt.Sprintf("%s at line %d", t.Kind, t.Line)
+	if t.Func != "" {
+		where += " in " + t.Func
+	}
+	if t.Kind == "await" {
+		return fmt.Sprintf("%s: reached %d, resumed %d", where, t.Set, t.Ran)
+	}
+	return fmt.Sprintf("%s (%v): set %d, ran %d", where, t.Delay, t.Set, t.Ran)
+}
+
+// asyncTask is the task of kind on line, added on first use with the
+// function it is in.
+func (s *RunState) asyncTask(kind string, line int, fn string) *AsyncTask {
+	for _, t := range s.Async {
+		if t.Kind == kind && t.Line == line {
+			return t
+		}
+	}
+	if fn == "<anonymous>" {
+		fn = ""
+	}
+	t := &AsyncTask{Kind: kind, Line: line, Func: fn}
+	s.Async = append(s.Async, t)
+	return t
+}
+
+// await counts the suspensions and resumptions of `await x`, as
+// `__resumed(await __awaiting(x, line), line)`. They leave and re-enter
+// the call for the stack depth, since between them the async function
+// isn't on the stack. The returned func ends the hooks, as assignment's
+// does.
+func (in *instrumenter) await(e *ast.AwaitExpression) func() {
+	start := in.offset(e.Await)
+	line := in.line(start)
+	in.open(start, "__resumed(")
+	arg := in.start(e.Argument)
+	if isSpace(in.src[arg-1]) {
+		in.open(arg, "__awaiting(")
+	} else {
+		// `await(x)`
+		in.open(arg, " __awaiting(")
+	}
+	return func() { in.insert(in.balancedEnd(e.Argument), fmt.Sprintf(", %d), %d)", line, line)) }
+}
+
+// configAsyncFunctions sets up the await hooks and wraps setTimeout,
+// setInterval and setImmediate to count their callbacks. An error a
+// callback throws, which the event loop would drop, stops the loop and
+// fails the run as one the script threw does.
+func configAsyncFunctions(vm *goja.Runtime, loop *eventloop.EventLoop, state *RunState, opts *Config) {
+	setHook(vm, "__awaiting", 0, func(call goja.FunctionCall) goja.Value {
+		fn := ""
+		if stack := callStack(vm, state, opts); len(stack) > 0 {
+			fn = stack[0].Func
+		}
+		state.asyncTask("await", int(call.Argument(1).ToInteger()), fn).Set++
+		state.exit()
+		return call.Argument(0)
+	})
+	setHook(vm, "__resumed", 0, func(call goja.FunctionCall) goja.Value {
+		state.enter()
+		state.asyncTask("await", int(call.Argument(1).ToInteger()), "").Ran++
+		return call.Argument(0)
+	})
+
+	for _, kind := range []string{"setTimeout", "setInterval", "setImmediate"} {
+		set, ok := goja.AssertFunction(vm.Get(kind))
+		if !ok {
+			continue
+		}
+		vm.Set(kind, func(call goja.FunctionCall) goja.Value {
+			callback, ok := goja.AssertFunction(call.Argument(0))
+			if !ok {
+				return goja.Undefined()
+			}
+			line, fn := 0, ""
+			if stack := callStack(vm, state, opts); len(stack) > 0 {
+				line, fn = stack[0].Line, stack[0].Func
+			}
+			task := state.asyncTask(kind, line, fn)
+			task.Set++
+			if kind != "setImmediate" {
+				task.Delay = time.Duration(call.Argument(1).ToInteger()) * time.Millisecond
+			}
+
+			args := slices.Clone(call.Arguments)
+			args[0] = vm.ToValue(func(c goja.FunctionCall) goja.Value {
+				if state.halted {
+					return goja.Undefined()
+				}
+				task.Ran++
+				if _, err := callback(goja.Undefined(), c.Arguments...); err != nil {
+					state.asyncErr, state.halted = err, true
+					loop.StopNoWait()
+				}
+				return goja.Undefined()
+			})
+			timer, err := set(goja.Undefined(), args...)
+			if err != nil {
+				panic(err)
+			}
+			return timer
+		})
+	}
+}
+
+// loopGuard is the -timeout on the event loop, which doesn't run while
+// the script is paused at a prompt.
+type loopGuard struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	left    time.Duration
+	armed   time.Time
+	stopped bool
+}
+
+// newLoopGuard calls expire once timeout ran out, unless stopped first.
+func newLoopGuard(timeout time.Duration, expire func()) *loopGuard {
+	g := &loopGuard{left: timeout, armed: time.Now()}
+	g.timer = time.AfterFunc(timeout, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if !g.stopped {
+			expire()
+		}
+	})
+	return g
+}
+
+// hold stops the clock until the returned func is called. A nil guard
+// holds nothing.
+func (g *loopGuard) hold() func() {
+	if g == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.timer.Stop() {
+		return func() {}
+	}
+	g.left -= time.Since(g.armed)
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if !g.stopped {
+			g.armed = time.Now()
+			g.timer.Reset(max(g.left, 0))
+		}
+	}
+}
+
+// stop disarms the guard; once it returns, expire isn't running and
+// won't be called.
+func (g *loopGuard) stop() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopped = true
+	g.timer.Stop()
+}
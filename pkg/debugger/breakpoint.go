@@ -0,0 +1,377 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+)
+
+var (
+	pathSegmentRegex = regexp.MustCompile(`^(?:\.([A-Za-z_$][\w$]*)|\[(\d+)\]|\["([^"]*)"\]|\['([^']*)'\])`)
+	identifierRegex  = regexp.MustCompile(`^[A-Za-z_$][\w$]*`)
+	setCommandRegex  = regexp.MustCompile(`^set\s+([A-Za-z_$][\w$]*)(?:\s*=\s*|\s+)(.+)$`)
+)
+
+// scopeEvaluator is handed to __breakpoint as `this` so commands typed at
+// the prompt can see the locals of the paused code, not just globals.
+// Inside a function whose parameters have defaults, a rest or patterns,
+// where goja gets a direct eval in the try of __enter wrong, globalEvaluator
+// stands in for it and only sees globals.
+const (
+	scopeEvaluator  = "(__e) => eval(__e)"
+	globalEvaluator = "(__e) => (0, eval)(__e)"
+)
+
+// debuggerHook is put in front of a `debugger;` statement, which goja
+// ignores, to pause there like __breakpoint() does.
+func (in *instrumenter) debuggerHook() string {
+	return fmt.Sprintf("__breakpoint.call(%s, %d)", in.evaluator, in.scope)
+}
+
+// breakpoint rewrites a `__breakpoint(args)` call into
+// `__breakpoint.call(<scopeEvaluator>, <scope>, args)`.
+func (in *instrumenter) breakpoint(call *ast.CallExpression) {
+	if callee, ok := call.Callee.(*ast.Identifier); !ok || callee.Name != "__breakpoint" {
+		return
+	}
+	paren := in.offset(call.LeftParenthesis)
+	evaluator := fmt.Sprintf("%s, %d", in.evaluator, in.scope)
+	if len(call.ArgumentList) > 0 {
+		evaluator += ", "
+	}
+	in.insert(paren, ".call")
+	in.insert(paren+1, evaluator)
+}
+
+// pause puts the hooks that pause before stmt in front of it: the -logpoint
+// and -break of its line when it is the first statement there, the
+// -coverage count, and a step under -step, or -inspect where the client
+// sets the breakpoints. A statement outside a statement list, such as a
+// braceless if body, gets a block of its own for them.
+func (in *instrumenter) pause(stmt ast.Statement, listed bool) {
+	start := in.start(stmt)
+	line := in.line(start)
+
+	var hooks []string
+	if !in.paused[line] {
+		message, logs := in.opts.Logpoints[line]
+		condition, breaks := in.opts.Breaks[line]
+		in.paused[line] = logs || breaks
+		if logs {
+			hooks = append(hooks, fmt.Sprintf("__logpoint.call(%s, %d, %s)", in.evaluator, line, strconv.Quote(message)))
+		}
+		if breaks {
+			test := "undefined"
+			if condition != "" {
+				test = strconv.Quote(condition)
+			}
+			hooks = append(hooks, fmt.Sprintf("__breakpoint.call(%s, %d, %s, %s, %d)", in.evaluator, in.scope, test, strconv.Quote(fmt.Sprintf("%s:%d", in.opts.Script, line)), line))
+		}
+	}
+	if steppable(stmt) && !in.stepped[start] {
+		in.stepped[start] = true
+		if in.opts.Coverage {
+			hooks = append(hooks, in.cover(line))
+		}
+		if in.opts.Step || in.opts.Inspect != "" {
+			hooks = append(hooks, fmt.Sprintf("__step.call(%s, %d, %d)", in.evaluator, line, in.scope))
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	hook := strings.Join(hooks, "; ")
+	if listed {
+		in.open(start, hook+"; ")
+		return
+	}
+	in.open(start, "{ "+hook+"; ")
+	in.close(in.statementEnd(stmt), " }")
+}
+
+// steppable reports whether -step stops before stmt. Blocks are stepped
+// into instead, and function declarations don't run where they stand.
+func steppable(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.BlockStatement, *ast.EmptyStatement, *ast.FunctionDeclaration:
+		return false
+	}
+	return true
+}
+
+// printSourceContext shows the lines around a -break breakpoint, marking
+// the one it paused on.
+func printSourceContext(source []string, line int, opts *Config) {
+	last := min(line+2, len(source))
+	width := len(strconv.Itoa(last))
+	for n := max(line-2, 1); n <= last; n++ {
+		marker := "  "
+		if n == line {
+			marker = "> "
+		}
+		fmt.Fprintf(opts.Stdout, "%s%s%*d | %s\n", opts.Indent, marker, width, n, source[n-1])
+	}
+}
+
+// evaluatorFor returns a function that evaluates JS in the paused scope
+// when the call site was instrumented, and in the global scope otherwise.
+func evaluatorFor(vm *goja.Runtime, this goja.Value) func(string) (goja.Value, error) {
+	if fn, ok := goja.AssertFunction(this); ok {
+		return func(expr string) (goja.Value, error) {
+			return fn(goja.Undefined(), vm.ToValue(expr))
+		}
+	}
+	return vm.RunString
+}
+
+// interpolate fills in the {expr} parts of a logpoint message with their
+// values, evaluated with evaluate. Braces nest, so `{ {a: 1}.a }` works.
+func interpolate(vm *goja.Runtime, message string, evaluate func(string) (goja.Value, error), limit int) string {
+	var out strings.Builder
+	for {
+		open := strings.IndexByte(message, '{')
+		if open < 0 {
+			break
+		}
+		end, depth := -1, 0
+		for i := open; i < len(message) && end < 0; i++ {
+			switch message[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		out.WriteString(message[:open])
+		if value, err := evaluate(message[open+1 : end]); err != nil {
+			var exception *goja.Exception
+			if errors.As(err, &exception) {
+				err = errors.New(exception.Value().String())
+			}
+			fmt.Fprintf(&out, "<error: %v>", err)
+		} else if _, isObject := value.(*goja.Object); isObject {
+			out.WriteString(renderValue(captureValue(vm, value), limit))
+		} else {
+			out.WriteString(renderValue(value.String(), limit))
+		}
+		message = message[end+1:]
+	}
+	out.WriteString(message)
+	return out.String()
+}
+
+// Watch is an expression registered with -watch, __watch or the watch
+// command, and its value at the latest pause. History holds the values it
+// had over the pauses, a new entry only when the value changed.
+type Watch struct {
+	Expr    string
+	Value   string
+	History []string
+
+	// changed tells whether the latest pause changed Value.
+	changed bool
+}
+
+// addWatch registers expr unless it is watched already, and reports
+// whether it was new.
+func (s *RunState) addWatch(expr string) bool {
+	for _, w := range s.Watches {
+		if w.Expr == expr {
+			return false
+		}
+	}
+	s.Watches = append(s.Watches, Watch{Expr: expr})
+	return true
+}
+
+// printWatches lists the watches as of the latest pause, marking the ones
+// that changed since the one before with their previous value.
+func (s *RunState) printWatches(opts *Config) {
+	for _, w := range s.Watches {
+		if n := len(w.History); w.changed && n > 1 {
+			fmt.Fprintf(opts.Stdout, "%swatch: %s = %s (was %s)\n", opts.Indent, w.Expr, w.Value, w.History[n-2])
+			continue
+		}
+		fmt.Fprintf(opts.Stdout, "%swatch: %s = %s\n", opts.Indent, w.Expr, w.Value)
+	}
+}
+
+// evaluateWatches re-evaluates every watch in the paused scope. A watch
+// that throws, say on a variable that isn't declared yet, reads <error>.
+func (s *RunState) evaluateWatches(vm *goja.Runtime, evaluate func(string) (goja.Value, error), limit int) {
+	for i := range s.Watches {
+		s.Watches[i].evaluate(vm, evaluate, limit)
+	}
+}
+
+func (w *Watch) evaluate(vm *goja.Runtime, evaluate func(string) (goja.Value, error), limit int) {
+	w.Value = "<error>"
+	if value, err := evaluate(w.Expr); err == nil {
+		w.Value = renderValue(captureValue(vm, value), limit)
+	}
+	w.changed = len(w.History) == 0 || w.History[len(w.History)-1] != w.Value
+	if w.changed {
+		w.History = append(w.History, w.Value)
+	}
+}
+
+// BreakpointHit is one time a breakpoint was reached with its condition
+// holding, paused at or not. Line is 0 for `debugger;` and __breakpoint().
+type BreakpointHit struct {
+	Label string
+	Line  int
+	Time  time.Time
+	Stack []Frame
+}
+
+// breakpointPrompt reads commands until the user resumes execution, and
+// returns the command that did: "" (ENTER), "c", "n" or "q". resume tells
+// the user which of those mean what here.
+func breakpointPrompt(vm *goja.Runtime, stdin *bufio.Reader, resume string, evaluate func(string) (goja.Value, error), debugInfo map[string]any, state *RunState, opts *Config) string {
+	defer state.hold()()
+	fmt.Fprintf(opts.Stdout, "\n|>  %s (or: <js expression>, print <path>, set <name> <expr>, vars, help)... ", resume)
+
+	for {
+		input, err := stdin.ReadString('\n')
+		command := strings.TrimSpace(input)
+		verb, arg, _ := strings.Cut(command, " ")
+
+		switch {
+		case command == "" || command == "c" || command == "n" || command == "q":
+			return command
+		case command == "continue" || command == "next" || command == "quit":
+			return command[:1]
+		case (verb == "get" || verb == "print" || verb == "p") && strings.TrimSpace(arg) != "":
+			getPath(vm, strings.TrimSpace(arg), evaluate, state, opts)
+		case command == "vars":
+			printVars(debugInfo, opts)
+		case command == "diff":
+			printSnapshotDiff(vm, evaluate, debugInfo, state, opts)
+		case verb == "watch" && strings.TrimSpace(arg) != "":
+			expr := strings.TrimSpace(arg)
+			if !state.addWatch(expr) {
+				fmt.Fprintf(opts.Stdout, "  %s is watched already\n", expr)
+				break
+			}
+			w := &state.Watches[len(state.Watches)-1]
+			w.evaluate(vm, evaluate, opts.MaxValueLen)
+			fmt.Fprintf(opts.Stdout, "  watching %s = %s\n", w.Expr, w.Value)
+		case verb == "history" && strings.TrimSpace(arg) != "":
+			printHistory(strings.TrimSpace(arg), state, opts)
+		case command == "watches":
+			state.printWatches(opts)
+		case setCommandRegex.MatchString(command):
+			m := setCommandRegex.FindStringSubmatch(command)
+			setVariable(vm, m[1], m[2], evaluate, debugInfo, state, opts)
+		case command == "help" || command == "?":
+			fmt.Fprintf(opts.Stdout, "  %s; or <js expression> (e.g. arr.length), print <path> (e.g. print obj.a.b), set <name> <expr>, vars, diff (what changed since the previous pause), history <name>, watch <expr>, watches\n", resume)
+		default:
+			evaluateCommand(vm, command, evaluate, opts)
+		}
+
+		if err != nil {
+			return ""
+		}
+		fmt.Fprint(opts.Stdout, "|> ")
+	}
+}
+
+// evaluateCommand prints the value of a JS expression typed at the prompt,
+// evaluated in the paused scope. Side effects (`arr.push(1)`) stick.
+func evaluateCommand(vm *goja.Runtime, expr string, evaluate func(string) (goja.Value, error), opts *Config) {
+	value, err := evaluate(expr)
+	if err != nil {
+		fmt.Fprintf(opts.Stdout, "  error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(opts.Stdout, "  %s\n", inspectValue(captureValue(vm, value), "  ", opts))
+}
+
+// printVars lists every captured variable with its current value.
+func printVars(debugInfo map[string]any, opts *Config) {
+	if len(debugInfo) == 0 {
+		fmt.Fprintln(opts.Stdout, "  no variables captured yet")
+		return
+	}
+	for _, k := range sortedKeys(debugInfo) {
+		fmt.Fprintf(opts.Stdout, "%s%s = %s\n", opts.Indent, k, inspectValue(debugInfo[k], opts.Indent, opts))
+	}
+}
+
+// getPath prints the value at a property path such as `obj.a[0].b`,
+// stopping at the first missing segment instead of throwing.
+func getPath(vm *goja.Runtime, path string, evaluate func(string) (goja.Value, error), state *RunState, opts *Config) {
+	root := identifierRegex.FindString(path)
+	if root == "" {
+		fmt.Fprintf(opts.Stdout, "  %q doesn't start with a variable name\n", path)
+		return
+	}
+
+	current, err := evaluate(root)
+	if err != nil {
+		live, captured := state.live[root]
+		if !captured {
+			fmt.Fprintf(opts.Stdout, "  %s is not defined\n", root)
+			return
+		}
+		current = live
+	}
+
+	walked := root
+	rest := path[len(root):]
+	for rest != "" {
+		m := pathSegmentRegex.FindStringSubmatch(rest)
+		if m == nil {
+			fmt.Fprintf(opts.Stdout, "  can't parse %q in %s\n", rest, path)
+			return
+		}
+		if goja.IsUndefined(current) || goja.IsNull(current) {
+			fmt.Fprintf(opts.Stdout, "  %s: undefined (%s is %v)\n", path, walked, current)
+			return
+		}
+
+		current = current.ToObject(vm).Get(m[1] + m[2] + m[3] + m[4])
+		if current == nil {
+			current = goja.Undefined()
+		}
+		walked += m[0]
+		rest = rest[len(m[0]):]
+	}
+
+	fmt.Fprintf(opts.Stdout, "  %s = %s\n", path, inspectValue(captureValue(vm, current), "  ", opts))
+}
+
+// setVariable assigns the result of expr to an existing variable in the
+// paused scope, so execution resumes with the new value.
+func setVariable(vm *goja.Runtime, name, expr string, evaluate func(string) (goja.Value, error), debugInfo map[string]any, state *RunState, opts *Config) {
+	if _, err := evaluate(name); err != nil {
+		fmt.Fprintf(opts.Stdout, "  %s is not defined here, set only changes existing variables\n", name)
+		return
+	}
+
+	value, err := evaluate(fmt.Sprintf("%s = (%s)", name, expr))
+	if err != nil {
+		fmt.Fprintf(opts.Stdout, "  could not set %s: %v\n", name, err)
+		return
+	}
+
+	if _, tracked := debugInfo[name]; tracked {
+		debugInfo[name] = captureValue(vm, value)
+		// to the scope it was last captured in, where it most likely is
+		state.capture(name, state.latestKey(name).Scope, value, debugInfo[name])
+	}
+	fmt.Fprintf(opts.Stdout, "  %s = %s\n", name, inspectValue(captureValue(vm, value), "  ", opts))
+}
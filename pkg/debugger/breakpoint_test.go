@@ -0,0 +1,100 @@
+package debugger
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDebuggerStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		pauses   int
+		variable string // captured at the first pause, when there is one
+		want     string
+	}{
+		{name: "statement", script: "let a = 1;\ndebugger;\na = 2;", pauses: 1, variable: "a", want: "1"},
+		{name: "in a string", script: `const s = "debugger";`, variable: "s", want: "debugger"},
+		{name: "in a template", script: "const s = `debugger;`;", variable: "s", want: "debugger;"},
+		{name: "in an identifier", script: "let mydebugger = 1;\nmydebugger++;", variable: "mydebugger", want: "2"},
+		{name: "in a comment", script: "let a = 1; // debugger;\n/* debugger; */", variable: "a", want: "1"},
+		{name: "in a loop", script: "for (let i = 0; i < 3; i++) {\n  if (i > 0) debugger;\n}", pauses: 2, variable: "i", want: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paused []map[string]any
+			r := runScript(t, tt.script, func(c *Config) {
+				c.Hooks.Breakpoint = func(label string, line int, variables map[string]any) bool {
+					paused = append(paused, variables)
+					return true
+				}
+			})
+			if len(paused) != tt.pauses {
+				t.Fatalf("paused %d times, want %d", len(paused), tt.pauses)
+			}
+			variables := r.Variables
+			if tt.pauses > 0 {
+				variables = paused[0]
+			}
+			if got := fmt.Sprint(variables[tt.variable]); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.variable, got, tt.want)
+			}
+		})
+	}
+}
+
+// goja gets a direct eval wrong in a function whose parameters aren't
+// simple, so pauses there evaluate globally; they have to pause and leave
+// the parameters alone all the same.
+func TestPauseWithNonSimpleParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		breaks map[int]string
+	}{
+		{name: "debugger statement", script: "function f({ a }, ...rest) {\n  debugger;\n  return a + rest.length;\n}\nconst r = f({ a: 1 }, 2, 3);"},
+		{name: "__breakpoint", script: "function f(a, ...rest) {\n  __breakpoint();\n  return a + rest.length;\n}\nconst r = f(1, 2, 3);"},
+		{name: "-break", script: "function f(a, ...rest) {\n  const n = rest.length;\n  return a + n;\n}\nconst r = f(1, 2, 3);", breaks: map[int]string{2: ""}},
+		{name: "default", script: "function f(a, b = 2) {\n  debugger;\n  return a + b;\n}\nconst r = f(1);"},
+		{name: "nested in one", script: "function f([a], b) {\n  const g = (x) => {\n    debugger;\n    return x + a;\n  };\n  return g(b);\n}\nconst r = f([1], 2);"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pauses := 0
+			r := runScript(t, tt.script, func(c *Config) {
+				c.Breaks = tt.breaks
+				c.Hooks.Breakpoint = func(string, int, map[string]any) bool {
+					pauses++
+					return true
+				}
+			})
+			if pauses != 1 {
+				t.Errorf("paused %d times, want 1", pauses)
+			}
+			if got := captured(r, "r"); got != "[3]" {
+				t.Errorf("r captured %s, want [3]", got)
+			}
+		})
+	}
+}
+
+func TestStepWithNonSimpleParameters(t *testing.T) {
+	script := "function f(a, b = 2, ...r) {\n  const s = a.length + b;\n  return s + r.length;\n}\nconst x = f([1], 3, 4);"
+	steps := 0
+	r := runScript(t, script, func(c *Config) {
+		c.Step = true
+		c.Hooks.Breakpoint = func(label string, line int, variables map[string]any) bool {
+			steps++
+			return true
+		}
+	})
+	if steps == 0 {
+		t.Error("never stepped")
+	}
+	if got := describeCall("f", r.State.LastArgs["f"], 0); got != "f(a = [ 1 ], b = 3, r = [ 4 ])" {
+		t.Errorf("last call %s, want f(a = [ 1 ], b = 3, r = [ 4 ])", got)
+	}
+	if got := captured(r, "x"); got != "[5]" {
+		t.Errorf("x captured %s, want [5]", got)
+	}
+}
@@ -0,0 +1,179 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CoveredStatement is a statement -coverage counts the runs of, in the
+// script or, with File set, in one of its modules.
+type CoveredStatement struct {
+	File string
+	Line int
+	Hits int
+}
+
+// cover returns the hook counting the runs of the statement starting on
+// line.
+func (in *instrumenter) cover(line int) string {
+	in.coverage = append(in.coverage, CoveredStatement{Line: line})
+	return fmt.Sprintf("__covered(%d)", len(in.coverage)-1)
+}
+
+// fileCoverage is the coverage of the script or one module. Lines holds,
+// for each line a statement starts on, the most times one of them ran.
+type fileCoverage struct {
+	Name   string
+	Path   string
+	Source []string
+	Lines  map[int]int
+
+	Statements, Covered int
+}
+
+// coverageByFile groups the statements by the file they are in, the
+// script first.
+func (s *RunState) coverageByFile(opts *Config) []*fileCoverage {
+	path, err := filepath.Abs(opts.Script)
+	if err != nil {
+		path = opts.Script
+	}
+	files := []*fileCoverage{{Name: opts.Script, Path: path, Source: s.Source, Lines: make(map[int]int)}}
+	byName := map[string]*fileCoverage{"": files[0]}
+	for _, m := range s.Modules {
+		if m.Name != "" {
+			c := &fileCoverage{Name: m.Name, Path: m.Path, Source: m.Source, Lines: make(map[int]int)}
+			files, byName[m.Name] = append(files, c), c
+		}
+	}
+	for _, st := range s.Coverage {
+		c := byName[st.File]
+		if c == nil {
+			continue
+		}
+		c.Statements++
+		if st.Hits > 0 {
+			c.Covered++
+		}
+		c.Lines[st.Line] = max(c.Lines[st.Line], st.Hits)
+	}
+	return files
+}
+
+// linesHit counts the lines that ran, of those a statement starts on.
+func (c *fileCoverage) linesHit() int {
+	hit := 0
+	for _, hits := range c.Lines {
+		if hits > 0 {
+			hit++
+		}
+	}
+	return hit
+}
+
+// summary is the coverage of c on one line, with the lines that never
+// ran as ranges, e.g. `app.js: 9/12 statements, 8/10 lines (80.0%), never
+// ran: 4, 7-8`.
+func (c *fileCoverage) summary() string {
+	percent := 100.0
+	if len(c.Lines) > 0 {
+		percent = 100 * float64(c.linesHit()) / float64(len(c.Lines))
+	}
+	text := fmt.Sprintf("%s: %d/%d statements, %d/%d lines (%.1f%%)", c.Name, c.Covered, c.Statements, c.linesHit(), len(c.Lines), percent)
+
+	// missed lines with only lines without a statement between them are
+	// one range
+	var missed []string
+	first, last := 0, 0
+	flush := func() {
+		switch {
+		case first == 0:
+		case first == last:
+			missed = append(missed, strconv.Itoa(first))
+		default:
+			missed = append(missed, fmt.Sprintf("%d-%d", first, last))
+		}
+		first = 0
+	}
+	for _, line := range sortedKeys(c.Lines) {
+		if c.Lines[line] > 0 {
+			flush()
+			continue
+		}
+		if first == 0 {
+			first = line
+		}
+		last = line
+	}
+	flush()
+	if len(missed) > 0 {
+		text += ", never ran: " + strings.Join(missed, ", ")
+	}
+	return text
+}
+
+// writeCoverageListing writes c in the gcov style: each line with the
+// times it ran, ##### if it never did, or - when no statement starts on
+// it.
+func writeCoverageListing(w io.Writer, c *fileCoverage) {
+	fmt.Fprintf(w, "=== COVERAGE: %s ===\n", c.Name)
+	fmt.Fprintln(w, c.summary())
+	fmt.Fprintln(w)
+	for i, text := range c.Source {
+		count := "-"
+		if hits, ok := c.Lines[i+1]; ok {
+			count = strconv.Itoa(hits)
+			if hits == 0 {
+				count = "#####"
+			}
+		}
+		fmt.Fprintf(w, "%9s:%5d:%s\n", count, i+1, text)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeCoverageFiles writes the listing to coverage.txt in -out, unless
+// the report is a single file that has it, and -lcov's tracefile.
+func writeCoverageFiles(state *RunState, opts *Config) {
+	if !opts.Coverage {
+		return
+	}
+	files := state.coverageByFile(opts)
+	if !opts.singleReport() {
+		path := filepath.Join(opts.Out, "coverage.txt")
+		err := writeReport(path, opts, func(w io.Writer) {
+			for _, c := range files {
+				writeCoverageListing(w, c)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", path, err)
+		}
+	}
+	if opts.LCOV != "" {
+		if err := writeLCOV(opts.LCOV, files); err != nil {
+			fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", opts.LCOV, err)
+		}
+	}
+}
+
+// writeLCOV writes the line coverage of files as an LCOV tracefile, which
+// genhtml and editor coverage plugins read.
+func writeLCOV(path string, files []*fileCoverage) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	for _, c := range files {
+		fmt.Fprintf(file, "TN:\nSF:%s\n", c.Path)
+		for _, line := range sortedKeys(c.Lines) {
+			fmt.Fprintf(file, "DA:%d,%d\n", line, c.Lines[line])
+		}
+		fmt.Fprintf(file, "LF:%d\nLH:%d\nend_of_record\n", len(c.Lines), c.linesHit())
+	}
+	return file.Close()
+}
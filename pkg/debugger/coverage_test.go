@@ -0,0 +1,18 @@
+package debugger
+
+import "testing"
+
+// A directive is no statement to count, and counting it in front of it
+// would make it a plain string.
+func TestCoverageSkipsDirectives(t *testing.T) {
+	script := "\"use strict\";\nfunction f() {\n  'use strict';\n  return this;\n}\nconst unbound = f();"
+	r := runScript(t, script, func(c *Config) { c.Coverage = true })
+	for _, st := range r.State.Coverage {
+		if st.Line == 1 || st.Line == 3 {
+			t.Errorf("directive on line %d counted", st.Line)
+		}
+	}
+	if got := captured(r, "unbound"); got != "[undefined]" {
+		t.Errorf("unbound captured %s, want [undefined]", got)
+	}
+}
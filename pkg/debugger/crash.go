@@ -0,0 +1,122 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// Frame is one call in the JS call stack of an uncaught error or of a
+// breakpoint hit, in original positions.
+type Frame struct {
+	Func         string
+	File         string
+	Line, Column int
+}
+
+func (f Frame) String() string {
+	name := f.Func
+	if name == "" {
+		name = "<top level>"
+	}
+	if f.File == "" {
+		return name + " (native)"
+	}
+	return fmt.Sprintf("%s (%s:%d:%d)", name, f.File, f.Line, f.Column)
+}
+
+// crashReport is an uncaught error and the stack it unwound, innermost
+// frame first.
+type crashReport struct {
+	Message string
+	Frames  []Frame
+
+	// Script is the file name the instrumented code was compiled under.
+	// Instrumentation never adds lines, so positions in it are the original
+	// line numbers; columns are mapped back through a sourceMap.
+	Script string
+}
+
+// newCrashReport maps every frame of err in script, or in a module it
+// instrumented, back to the original. Syntax errors carry no stack; their
+// message already names the line.
+func newCrashReport(err error, script string, state *RunState) *crashReport {
+	report := &crashReport{Message: err.Error(), Script: script}
+
+	var exception *goja.Exception
+	if !errors.As(err, &exception) {
+		return report
+	}
+
+	report.Message = exception.Value().String()
+	report.Frames = scriptFrames(exception.Stack(), script, state)
+	return report
+}
+
+// scriptFrames maps the columns of the frames in script and its
+// instrumented modules back to the original; their lines already are.
+func scriptFrames(stack []goja.StackFrame, script string, state *RunState) []Frame {
+	var frames []Frame
+	for _, frame := range stack {
+		pos := frame.Position()
+		f := Frame{Func: frame.FuncName(), File: pos.Filename, Line: pos.Line, Column: pos.Column}
+		if columns, ok := state.columnsFor(f.File, script); ok {
+			f.Column = columns.column(f.Line, f.Column)
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// callStack is the JS call stack where a hook was called from, innermost
+// first, without the native frames of the hook itself.
+func callStack(vm *goja.Runtime, state *RunState, opts *Config) []Frame {
+	frames := scriptFrames(vm.CaptureCallStack(0, nil), opts.Script, state)
+	for len(frames) > 0 && frames[0].File == "" {
+		frames = frames[1:]
+	}
+	return frames
+}
+
+// Error is the message prefixed with where the script failed, in
+// original positions: "script.js:12:5: TypeError: ...".
+func (r *crashReport) Error() string {
+	if site, ok := r.site(); ok {
+		return fmt.Sprintf("%s:%d:%d: %s", site.File, site.Line, site.Column, r.Message)
+	}
+	return r.Message
+}
+
+// site is the innermost frame in the script, if any.
+func (r *crashReport) site() (Frame, bool) {
+	for _, f := range r.Frames {
+		if f.File == r.Script {
+			return f, true
+		}
+	}
+	return Frame{}, false
+}
+
+// printFirstError prints only where the script failed and what the
+// captured variables held at that point (-first-error-only).
+func printFirstError(crash *crashReport, source []string, debugInfo map[string]any, state *RunState, opts *Config) {
+	if site, ok := crash.site(); !ok {
+		fmt.Fprintf(opts.Stdout, "\n |> First error: %s\n", crash.Message)
+	} else {
+		fmt.Fprintf(opts.Stdout, "\n |> First error at %s:%d:%d: %s\n", site.File, site.Line, site.Column, crash.Message)
+		if site.Line <= len(source) {
+			fmt.Fprintf(opts.Stdout, "%s%d | %s\n", opts.Indent, site.Line, strings.TrimSpace(source[site.Line-1]))
+		}
+	}
+
+	if len(debugInfo) == 0 {
+		fmt.Fprintln(opts.Stdout, "\n |> No variables captured before the error")
+		return
+	}
+	fmt.Fprintln(opts.Stdout, "\n |> Variables at failure: ")
+	for _, k := range sortedKeys(debugInfo) {
+		fmt.Fprintf(opts.Stdout, "%s%s: %s \n", opts.Indent, k, state.describe(state.latestKey(k), debugInfo[k], opts))
+	}
+}
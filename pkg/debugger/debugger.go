@@ -0,0 +1,1298 @@
+package debugger
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja_nodejs/console"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+type LoopInfo struct {
+	Type string
+
+	// Line and EndLine are the script lines the loop statement spans.
+	Line, EndLine int
+
+	Variables  []string
+	Iterations int
+
+	// Duration is the wall-clock time spent in the loop, over all the times
+	// it ran, not counting pauses at a prompt.
+	Duration time.Duration
+
+	// Outer is the number (1-based) of the loop this one is nested in,
+	// 0 for a loop that isn't inside another.
+	Outer int
+
+	// Sizes holds, per iteration, the length/size of each array, Map
+	// and Set in scope as that iteration started.
+	Sizes []map[string]int
+
+	// Values holds every value captured inside the loop body, per variable,
+	// in the order they were captured.
+	Values map[string][]any
+
+	// PerIteration holds, per iteration, the last value each variable
+	// was captured with during it.
+	PerIteration []map[string]any
+
+	// Tracked are the loop variables its Timeline follows: the ones its
+	// header declares and the outer ones its body assigns to.
+	Tracked  []string
+	Timeline []LoopStep
+
+	// Dropped counts the steps left out of Timeline by -max-iterations.
+	Dropped int
+
+	// File is the module the loop is in, "" for the script; variables
+	// are then the module's captures.
+	File      string
+	variables map[string]any
+
+	// captures are the capture sites whose values belong to this loop,
+	// the ones of its nested loops excluded.
+	captures map[loopCapture]bool
+
+	// scopes are the scopes Variables are captured in, one each.
+	scopes []int
+
+	// announced is the iteration the latest passing condition check
+	// recorded, so the tick of a do-while records only those that weren't.
+	announced int
+}
+
+// where is the loop's line, with the module in front for one in a module.
+func (l LoopInfo) where() string {
+	if l.File != "" {
+		return fmt.Sprintf("%s line %d", l.File, l.Line)
+	}
+	return fmt.Sprintf("line %d", l.Line)
+}
+
+// loopCapture is a capture site: a variable captured on a script line.
+// Nested loops can share a line, so the line alone isn't enough.
+type loopCapture struct {
+	line int
+	name string
+}
+
+// Utility: writes current state to output.txt (output.md with -format=markdown,
+// output.json with -format=json, output.html with -format=html, both output.txt
+// and output.json with -format=both)
+func writeDebugInfoToFile(debugInfo map[string]any, label string, state *RunState, opts *Config) {
+	if opts.writesJSON() {
+		path := filepath.Join(opts.Out, "output.json")
+		data, err := debugInfoJSON(debugInfo, state, opts)
+		if err == nil {
+			err = writeReport(path, opts, func(w io.Writer) { w.Write(data) })
+		}
+		if err != nil {
+			fmt.Fprintf(opts.Stderr, "Could not write %s: %v\n", path, err)
+		}
+	}
+	if opts.Format == "json" {
+		return
+	}
+
+	path := opts.reportFile("output")
+	err := writeReport(path, opts, func(w io.Writer) {
+		switch opts.Format {
+		case "markdown":
+			writeDebugInfoMarkdown(w, debugInfo, label, state, opts)
+		case "html":
+			writeDebugInfoHTML(w, debugInfo, label, state, opts)
+		default:
+			writeDebugInfo(w, debugInfo, label, state, opts)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", path, err)
+	}
+}
+
+// writeReport runs write on the report file at path, or on opts.Report
+// when the embedding program collects the reports itself.
+func writeReport(path string, opts *Config, write func(io.Writer)) error {
+	if opts.Report != nil {
+		write(opts.Report)
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	write(writer)
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeDebugInfo(writer io.Writer, debugInfo map[string]any, label string, state *RunState, opts *Config) {
+	fmt.Fprintf(writer, "=== %s ===\n", label)
+	for _, k := range sortedKeys(debugInfo) {
+		fmt.Fprintf(writer, "%s: %s\n", k, fitValue(state.history(state.latestKey(k), debugInfo[k], opts), debugInfo[k], "", opts))
+	}
+	fmt.Fprintf(writer, "\nMax stack depth: %d\n", state.MaxDepth)
+
+	for _, section := range reportSections(state, opts) {
+		fmt.Fprintf(writer, "\n=== %s ===\n", section.Title)
+		for _, line := range section.Lines {
+			if line.Nested {
+				fmt.Fprint(writer, opts.Indent)
+			}
+			fmt.Fprintf(writer, "%s\n", line.Text)
+		}
+	}
+}
+
+// Function to write loop information to loops.txt (loops.md with -format=markdown;
+// with -format=json or html the loops are part of output.json or output.html)
+func writeLoopInfoToFile(loopInfos []LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	if opts.singleReport() {
+		return
+	}
+	path := opts.reportFile("loops")
+	err := writeReport(path, opts, func(w io.Writer) {
+		if opts.Format == "markdown" {
+			writeLoopInfoMarkdown(w, loopInfos, allVariables, state, opts)
+		} else {
+			writeLoopInfo(w, loopInfos, allVariables, state, opts)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", path, err)
+	}
+}
+
+func writeLoopInfo(writer io.Writer, loopInfos []LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	if opts.GroupLoopsBy == "type" {
+		writeLoopsByType(writer, loopInfos, allVariables, state, opts)
+	} else {
+		fmt.Fprintf(writer, "=== LOOP ANALYSIS ===\n\n")
+		for i, loop := range loopInfos {
+			writeLoop(writer, i+1, loop, allVariables, state, opts)
+		}
+	}
+
+	if d := opts.LoopDiff; d != nil && d.Loop <= len(loopInfos) {
+		fmt.Fprintf(writer, "=== LOOP %d: ITERATION %d vs %d ===\n", d.Loop, d.From, d.To)
+		for _, line := range iterationDiff(loopInfos[d.Loop-1], d.From, d.To, opts.MaxValueLen) {
+			fmt.Fprintf(writer, "%s\n", line)
+		}
+	}
+}
+
+// writeLoopsByType lists loops grouped by kind, each group headed by its
+// loop count and total iterations. Loops keep their sequential numbers.
+func writeLoopsByType(writer io.Writer, loopInfos []LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	fmt.Fprintf(writer, "=== LOOP ANALYSIS (by type) ===\n\n")
+
+	for _, kind := range []string{"for", "while", "do-while"} {
+		var numbers []int
+		iterations := 0
+		for i, loop := range loopInfos {
+			if loop.Type == kind {
+				numbers = append(numbers, i+1)
+				iterations += loop.Iterations
+			}
+		}
+		if len(numbers) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "--- %s: %d loop(s), %d iteration(s) total ---\n\n", kind, len(numbers), iterations)
+		for _, n := range numbers {
+			writeLoop(writer, n, loopInfos[n-1], allVariables, state, opts)
+		}
+	}
+}
+
+func writeLoop(writer io.Writer, number int, loop LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	indent := opts.Indent
+	fmt.Fprintf(writer, "Loop %d:\n", number)
+	fmt.Fprintf(writer, "Type: %s\n", loop.Type)
+	if loop.File != "" {
+		fmt.Fprintf(writer, "File: %s\n", loop.File)
+	}
+	if loop.Outer > 0 {
+		fmt.Fprintf(writer, "Nested in: Loop %d\n", loop.Outer)
+	}
+	fmt.Fprintf(writer, "Variables in scope: {\n")
+
+	// Write only variables that are inside this loop block
+	for _, v := range state.loopValues(loop, allVariables) {
+		fmt.Fprintf(writer, "%s[%s, %s],\n", indent, v.Name, inspectLine(v.Value, opts))
+	}
+
+	fmt.Fprintf(writer, "}\n")
+	fmt.Fprintf(writer, "Iterations: %d\n", loop.Iterations)
+	fmt.Fprintf(writer, "Time: %v\n", loop.Duration.Round(time.Microsecond))
+	writeTimeline(writer, loop, indent, opts)
+	writeCollectionSizes(writer, loop.Sizes, indent)
+	if opts.LoopStats {
+		writeLoopStats(writer, loop.Values, indent)
+	}
+	fmt.Fprintf(writer, "\n")
+}
+
+// writeLoopStats summarises each variable whose captured values in the
+// loop were all numbers; other series are skipped.
+func writeLoopStats(writer io.Writer, values map[string][]any, indent string) {
+	header := false
+	for _, name := range sortedKeys(values) {
+		stats, ok := numericStats(values[name])
+		if !ok {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(writer, "Stats (over captured values):\n")
+			header = true
+		}
+		fmt.Fprintf(writer, "%s%s: %s\n", indent, name, stats)
+	}
+}
+
+func writeCollectionSizes(writer io.Writer, sizes []map[string]int, indent string) {
+	series := make(map[string]bool)
+	for _, iteration := range sizes {
+		for name := range iteration {
+			series[name] = true
+		}
+	}
+	if len(series) == 0 {
+		return
+	}
+
+	fmt.Fprintf(writer, "Collection sizes (at start of each iteration):\n")
+	for _, name := range sortedKeys(series) {
+		values := make([]string, len(sizes))
+		for i, iteration := range sizes {
+			values[i] = "-"
+			if size, ok := iteration[name]; ok {
+				values[i] = strconv.Itoa(size)
+			}
+		}
+		fmt.Fprintf(writer, "%s%s: [%s]\n", indent, name, strings.Join(values, ", "))
+	}
+}
+
+func setupJsRuntime(vm *goja.Runtime, state *RunState, opts *Config) {
+	modules := &moduleRecorder{state: state, opts: opts}
+	registry := require.NewRegistry(
+		require.WithGlobalFolders("."),
+		require.WithPathResolver(modules.resolve),
+		require.WithLoader(modules.load),
+	)
+	if opts.Record != "" {
+		registry.RegisterNativeModule(console.ModuleName, console.RequireWithPrinter(newTapePrinter(vm, state, opts)))
+	}
+	registry.Enable(vm)
+	console.Enable(vm)
+
+	// Node-style argv: [runtime, script, ...args]
+	argv := append([]any{"debugger-js", opts.Script}, toAnySlice(opts.ScriptArgs)...)
+	process := vm.NewObject()
+	process.Set("argv", argv)
+	process.Set("env", vm.NewDynamicObject(newEnvObject(vm, opts.Env, state)))
+	vm.Set("process", process)
+	vm.Set("scriptArgs", toAnySlice(opts.ScriptArgs))
+
+	if opts.TraceJSON {
+		traceJSON(vm, state)
+	}
+	if opts.BreakOnException {
+		trackRejections(vm, state)
+	}
+}
+
+func toAnySlice(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+func configDebugFunctions(vm *goja.Runtime, debugInfo map[string]any, state *RunState, opts *Config) {
+	imprecise := make(map[string]bool)
+
+	// pauseAt pauses as a breakpoint labelled label, for a Hooks func or
+	// -plugin hook that returned it; line is the script line, 0 in a
+	// module. An empty label doesn't pause.
+	var pauseAt func(label string, line int)
+
+	// record keeps a capture and returns it. One in a required module
+	// goes to that module's variables and its loops, not the script's.
+	record := func(name string, raw goja.Value, line, scope int) any {
+		if opts.MaxStringCapture > 0 && goja.IsString(raw) {
+			raw = vm.ToValue(truncateCapture(raw.String(), opts.MaxStringCapture))
+		}
+		value := captureValue(vm, raw)
+		state.captureScope(scope, name, value)
+		file, qualified := "", name
+		if m := state.module(scope); m != nil {
+			file, qualified = m.Name, m.Name+":"+name
+			m.Variables[name] = value
+		} else {
+			debugInfo[name] = value
+			state.capture(name, scope, raw, value)
+			state.declare(name, line, scope, raw, value)
+			state.recordLine(line, name, value)
+		}
+		state.recordEvent(name, value, line, file)
+		if state.Recording != nil {
+			recorded := recordedValue(value)
+			state.tape(RecordedEvent{Kind: "assign", Line: line, File: file, Name: name, Value: &recorded})
+		}
+		if opts.Hooks.Capture != nil {
+			opts.Hooks.Capture(qualified, value, line)
+		}
+
+		for id := range state.Loops {
+			if loop := &state.Loops[id]; loop.File == file && loop.captures[loopCapture{line, name}] {
+				if loop.Values == nil {
+					loop.Values = make(map[string][]any)
+				}
+				loop.Values[name] = append(loop.Values[name], value)
+				for len(loop.PerIteration) < loop.Iterations {
+					loop.PerIteration = append(loop.PerIteration, make(map[string]any))
+				}
+				if loop.Iterations > 0 {
+					loop.PerIteration[loop.Iterations-1][name] = value
+				}
+			}
+		}
+
+		if w, ok := precisionWarning(name, line, raw); ok && !imprecise[file+w.Message] {
+			imprecise[file+w.Message] = true
+			w.File = file
+			state.Warnings = append(state.Warnings, w)
+			fmt.Fprintf(opts.Stdout, "|!| %s\n", w)
+		}
+
+		label := ""
+		if opts.Hooks.Check != nil {
+			label = opts.Hooks.Check(qualified, value, line)
+		}
+		if state.hasPluginHook("onVariableAssigned") {
+			label = cmp.Or(label, state.runPlugins(vm, "onVariableAssigned", opts, qualified, raw, line))
+		}
+		if file != "" {
+			line = 0
+		}
+		pauseAt(label, line)
+		return value
+	}
+
+	// printStepped shows a capture made while stepping
+	printStepped := func(name string, value any, line, scope int) {
+		fmt.Fprintf(opts.Stdout, "|~| %s: %s = %s\n", state.location(scope, line), name, renderValue(value, opts.MaxValueLen))
+	}
+
+	stdin := bufio.NewReader(opts.Stdin)
+	setHook(vm, "debug", -1, func(call goja.FunctionCall) goja.Value {
+		name, line, scope := call.Argument(0).String(), int(call.Argument(2).ToInteger()), argScope(call.Argument(3))
+		value := record(name, call.Argument(1), line, scope)
+		if opts.Step {
+			printStepped(name, value, line, scope)
+		}
+		return goja.Undefined()
+	})
+
+	// __assigned(name, result, read, line, scope) records the value an
+	// assignment or ++/-- left in name, which read returns as [value] (or
+	// undefined when -capture-when says no), and hands back result.
+	setHook(vm, "__assigned", 1, func(call goja.FunctionCall) goja.Value {
+		read, ok := goja.AssertFunction(call.Argument(2))
+		if !ok {
+			return call.Argument(1)
+		}
+		wrapped, err := read(goja.Undefined())
+		if obj, isArray := wrapped.(*goja.Object); err == nil && isArray {
+			name, line, scope := call.Argument(0).String(), int(call.Argument(3).ToInteger()), argScope(call.Argument(4))
+			value := record(name, obj.Get("0"), line, scope)
+			if opts.Step {
+				printStepped(name, value, line, scope)
+			}
+		}
+		return call.Argument(1)
+	})
+
+	// -step pauses before every statement until `c` runs on to the next
+	// breakpoint, where `n` starts stepping again. `q` stops the script.
+	stepping := opts.Step
+	quit := func() {
+		state.Quit = true
+		vm.Interrupt("quit")
+	}
+	setHook(vm, "__step", -1, func(call goja.FunctionCall) goja.Value {
+		line, scope := int(call.Argument(0).ToInteger()), argScope(call.Argument(1))
+		module := state.module(scope)
+		if state.inspector != nil {
+			// the inspector only has the script's source to show
+			if module == nil {
+				state.inspector.statement(line, scope, evaluatorFor(vm, call.This))
+			}
+			return goja.Undefined()
+		}
+		if !stepping {
+			return goja.Undefined()
+		}
+		evaluate := evaluatorFor(vm, call.This)
+		defer func() { state.lastPause = pauseSnapshot(vm, evaluate, debugInfo) }()
+		fmt.Fprintf(opts.Stdout, "\n|~| Step, %s: %s\n", state.location(scope, line), state.sourceLine(scope, line))
+		state.evaluateWatches(vm, evaluate, opts.MaxValueLen)
+		state.printWatches(opts)
+		if opts.Hooks.Breakpoint != nil {
+			release := state.hold()
+			proceed := opts.Hooks.Breakpoint("step", line, maps.Clone(debugInfo))
+			release()
+			if !proceed {
+				quit()
+			}
+			return goja.Undefined()
+		}
+		switch breakpointPrompt(vm, stdin, "n / ENTER next, c continue, q quit", evaluate, debugInfo, state, opts) {
+		case "c":
+			stepping = false
+		case "q":
+			quit()
+		}
+		return goja.Undefined()
+	})
+
+	// -final-only: declarations register a getter in the innermost
+	// function's scope, read once as that function (or the script) ends
+	scopes := []*finalScope{{}}
+	flush := func(scope *finalScope) {
+		for _, name := range scope.names {
+			getter := scope.getters[name]
+			if value, err := getter.read(goja.Undefined()); err == nil {
+				record(name, value, getter.line, getter.scope)
+			}
+		}
+	}
+	state.finishFinals = func() { flush(scopes[0]) }
+	setHook(vm, "__final", -1, func(call goja.FunctionCall) goja.Value {
+		if read, ok := goja.AssertFunction(call.Argument(1)); ok {
+			scopes[len(scopes)-1].add(call.Argument(0).String(), finalGetter{read: read, line: int(call.Argument(2).ToInteger()), scope: argScope(call.Argument(3))})
+		}
+		return goja.Undefined()
+	})
+
+	// capturePayload(channel, value) notes a message crossing an
+	// event/channel boundary and hands the value back unchanged.
+	vm.Set("capturePayload", func(call goja.FunctionCall) goja.Value {
+		state.Messages = append(state.Messages, Message{
+			Seq:     len(state.Messages) + 1,
+			Channel: call.Argument(0).String(),
+			Value:   captureValue(vm, call.Argument(1)),
+		})
+		return call.Argument(1)
+	})
+
+	vm.Set("expect", func(call goja.FunctionCall) goja.Value {
+		label := fmt.Sprintf("expectation %d", len(state.Expectations)+1)
+		if !goja.IsUndefined(call.Argument(2)) {
+			label = call.Argument(2).String()
+		}
+		pass := expectationPasses(vm, call.Argument(0), call.Argument(1))
+		state.Expectations = append(state.Expectations, Expectation{
+			Label:    label,
+			Actual:   captureValue(vm, call.Argument(0)),
+			Expected: captureValue(vm, call.Argument(1)),
+			Pass:     pass,
+		})
+		return vm.ToValue(pass)
+	})
+
+	setHook(vm, "__covered", -1, func(call goja.FunctionCall) goja.Value {
+		if id := int(call.Argument(0).ToInteger()); id >= 0 && id < len(state.Coverage) {
+			state.Coverage[id].Hits++
+		}
+		return goja.Undefined()
+	})
+
+	setHook(vm, "__element", -1, func(call goja.FunctionCall) goja.Value {
+		state.ElementWrites = append(state.ElementWrites, ElementWrite{
+			Seq:   len(state.ElementWrites) + 1,
+			Line:  int(call.Argument(3).ToInteger()),
+			Array: call.Argument(0).String(),
+			Index: call.Argument(1).String(),
+			Value: captureValue(vm, call.Argument(2)),
+		})
+		return goja.Undefined()
+	})
+
+	setHook(vm, "__loop_tick", -1, func(call goja.FunctionCall) goja.Value {
+		id := int(call.Argument(0).ToInteger())
+		if id < 0 || id >= len(state.Loops) {
+			return goja.Undefined()
+		}
+
+		loop := &state.Loops[id]
+		loop.Iterations++
+		if opts.MaxLoopIterations > 0 && loop.Iterations > opts.MaxLoopIterations && state.Limit == "" {
+			state.Limit = fmt.Sprintf("loop %d (%s at %s) ran past -max-loop-iterations %d", id+1, loop.Type, loop.where(), opts.MaxLoopIterations)
+			vm.Interrupt("loop limit")
+			return goja.Undefined()
+		}
+		state.tape(RecordedEvent{Kind: "loop", Line: loop.Line, File: loop.File, Loop: id + 1, Iteration: loop.Iterations})
+		if getters := call.Argument(1); !goja.IsUndefined(getters) && loop.announced != loop.Iterations {
+			recordStep(vm, loop, LoopStep{Iteration: loop.Iterations}, getters, opts)
+		}
+
+		sizes := make(map[string]int)
+		for name, v := range state.live {
+			if label, size, ok := collectionSize(vm, v); ok {
+				sizes[name+"."+label] = size
+			}
+		}
+		loop.Sizes = append(loop.Sizes, sizes)
+
+		label, line := "", loop.Line
+		if opts.Hooks.LoopIteration != nil {
+			label = opts.Hooks.LoopIteration(*loop, loop.Iterations)
+		}
+		if state.hasPluginHook("onLoopIteration") {
+			info := map[string]any{"number": id + 1, "type": loop.Type, "line": loop.Line, "file": loop.File}
+			label = cmp.Or(label, state.runPlugins(vm, "onLoopIteration", opts, info, loop.Iterations))
+		}
+		if loop.File != "" {
+			line = 0
+		}
+		pauseAt(label, line)
+		return goja.Undefined()
+	})
+
+	// __loop_test(id, condition, getters) records a timeline step each time
+	// a loop checks its condition: the start of the next iteration, or the
+	// exit when condition is falsy.
+	setHook(vm, "__loop_test", 1, func(call goja.FunctionCall) goja.Value {
+		id, condition := int(call.Argument(0).ToInteger()), call.Argument(1)
+		if id >= 0 && id < len(state.Loops) {
+			loop := &state.Loops[id]
+			step := LoopStep{Condition: captureValue(vm, condition), Tested: true}
+			if condition.ToBoolean() {
+				step.Iteration = loop.Iterations + 1
+				loop.announced = step.Iteration
+			}
+			recordStep(vm, loop, step, call.Argument(2), opts)
+		}
+		return condition
+	})
+
+	// when each running loop was entered, and how many times it is running:
+	// a loop in a recursive function is entered again before it finishes,
+	// and only the outermost run counts towards its time
+	entered := make(map[int]time.Duration)
+	running := make(map[int]int)
+	setHook(vm, "__loop_start", -1, func(call goja.FunctionCall) goja.Value {
+		id := int(call.Argument(0).ToInteger())
+		if running[id] == 0 {
+			entered[id] = state.clock()
+		}
+		running[id]++
+		return goja.Undefined()
+	})
+	setHook(vm, "__loop_end", -1, func(call goja.FunctionCall) goja.Value {
+		id := int(call.Argument(0).ToInteger())
+		if running[id]--; running[id] == 0 && id >= 0 && id < len(state.Loops) {
+			state.Loops[id].Duration += state.clock() - entered[id]
+		}
+		return goja.Undefined()
+	})
+
+	setHook(vm, "__enter", -1, func(call goja.FunctionCall) goja.Value {
+		state.enter()
+		if state.Mutations != nil {
+			state.Mutations.enter(call.Argument(0).String(), sortedKeys(debugInfo), call.Argument(1))
+		}
+		if opts.FinalOnly {
+			scopes = append(scopes, &finalScope{})
+		}
+		var args []namedValue
+		if params, ok := call.Argument(1).(*goja.Object); ok {
+			args = make([]namedValue, 0, len(params.Keys()))
+			for _, k := range params.Keys() {
+				args = append(args, namedValue{Name: k, Value: captureValue(vm, params.Get(k))})
+			}
+			state.recordArgs(call.Argument(0).String(), args)
+		}
+		if opts.TraceCalls {
+			state.traceEnter(call.Argument(0).String(), args, opts)
+		}
+		if state.Profile != nil {
+			state.Profile.enter(call.Argument(0).String())
+		}
+
+		if opts.Hooks.FunctionCall != nil || state.hasPluginHook("onFunctionCall") {
+			frame := Frame{Func: call.Argument(0).String()}
+			if stack := callStack(vm, state, opts); len(stack) > 0 {
+				frame.File, frame.Line, frame.Column = stack[0].File, stack[0].Line, stack[0].Column
+			}
+			label := ""
+			if opts.Hooks.FunctionCall != nil {
+				values := make(map[string]any, len(args))
+				for _, arg := range args {
+					values[arg.Name] = arg.Value
+				}
+				label = opts.Hooks.FunctionCall(frame, values)
+			}
+			if state.hasPluginHook("onFunctionCall") {
+				params := call.Argument(1)
+				if goja.IsUndefined(params) {
+					params = vm.NewObject()
+				}
+				info := map[string]any{"name": frame.Func, "file": frame.File, "line": frame.Line, "column": frame.Column, "args": params}
+				label = cmp.Or(label, state.runPlugins(vm, "onFunctionCall", opts, info))
+			}
+			line := frame.Line
+			if frame.File != opts.Script {
+				line = 0
+			}
+			pauseAt(label, line)
+		}
+		return goja.Undefined()
+	})
+
+	setHook(vm, "__exit", -1, func(call goja.FunctionCall) goja.Value {
+		state.exit()
+		if opts.TraceCalls {
+			state.traceExit(opts)
+		}
+		if state.Mutations != nil {
+			state.Mutations.exit()
+		}
+		if n := len(scopes); opts.FinalOnly && n > 1 {
+			flush(scopes[n-1])
+			scopes = scopes[:n-1]
+		}
+		if state.Profile != nil {
+			state.Profile.exit()
+		}
+		return goja.Undefined()
+	})
+
+	// __return(value) and __threw(): how a call ends, for -trace-calls
+	setHook(vm, "__return", 0, func(call goja.FunctionCall) goja.Value {
+		state.traceReturn(captureValue(vm, call.Argument(0)))
+		return call.Argument(0)
+	})
+	setHook(vm, "__threw", -1, func(call goja.FunctionCall) goja.Value {
+		state.traceThrow()
+		return goja.Undefined()
+	})
+
+	hits := 0
+
+	// __watch(expr) adds expr to the expressions shown at every pause.
+	for _, expr := range opts.Watches {
+		state.addWatch(expr)
+	}
+	setHook(vm, "__watch", -1, func(call goja.FunctionCall) goja.Value {
+		state.addWatch(strings.TrimSpace(call.Argument(0).String()))
+		return goja.Undefined()
+	})
+
+	// __logpoint(line, message): -logpoint prints message, filled in by
+	// interpolate in the scope of the line, and carries on.
+	setHook(vm, "__logpoint", -1, func(call goja.FunctionCall) goja.Value {
+		line := int(call.Argument(0).ToInteger())
+		message := interpolate(vm, call.Argument(1).String(), evaluatorFor(vm, call.This), opts.MaxValueLen)
+		fmt.Fprintf(opts.Stdout, "|~| log line %d: %s\n", line, message)
+		return goja.Undefined()
+	})
+
+	// conditions that failed to evaluate, reported once each
+	failed := make(map[string]bool)
+
+	// the stack __exception pauses with, the one of the error rather than
+	// of the catch it was seen in
+	var exceptionStack []Frame
+
+	// __breakpoint(condition, label): both optional; the breakpoint only
+	// fires when condition is truthy in the paused scope. The instrumenter
+	// puts the scope of the call in front of them, and -break adds the
+	// script line as a third argument.
+	breakpoint := func(call goja.FunctionCall) goja.Value {
+		evaluate := evaluatorFor(vm, call.This)
+		scope := -1
+		if _, instrumented := goja.AssertFunction(call.This); instrumented {
+			scope = argScope(call.Argument(0))
+			call.Arguments = call.Arguments[min(1, len(call.Arguments)):]
+		}
+		condition := ""
+		if !goja.IsUndefined(call.Argument(0)) {
+			condition = strings.TrimSpace(call.Argument(0).String())
+		}
+		if condition != "" {
+			result, err := evaluate(condition)
+			if err != nil {
+				if !failed[condition] {
+					fmt.Fprintf(opts.Stdout, "\n|!| Breakpoint condition %q failed, not pausing: %v\n", condition, err)
+					failed[condition] = true
+				}
+				return goja.Undefined()
+			}
+			if !result.ToBoolean() {
+				return goja.Undefined()
+			}
+		}
+
+		hits++
+		label := fmt.Sprintf("#%d", hits)
+		if !goja.IsUndefined(call.Argument(1)) {
+			label = call.Argument(1).String()
+		} else if condition != "" {
+			label = condition
+		}
+		line := 0
+		if arg := call.Argument(2); !goja.IsUndefined(arg) {
+			line = int(arg.ToInteger())
+		}
+		stack := exceptionStack
+		if stack == nil {
+			stack = callStack(vm, state, opts)
+		}
+		state.Hits = append(state.Hits, BreakpointHit{Label: label, Line: line, Time: time.Now(), Stack: stack})
+		if state.Recording != nil {
+			hit := RecordedEvent{Kind: "breakpoint", Line: line, Label: label, Stack: stack, Variables: make(map[string]RecordedValue)}
+			if line == 0 && len(stack) > 0 && stack[0].File == opts.Script {
+				hit.Line = stack[0].Line
+			}
+			for name, value := range pauseSnapshot(vm, evaluate, debugInfo) {
+				hit.Variables[name] = recordedValue(value)
+			}
+			state.tape(hit)
+		}
+		state.evaluateWatches(vm, evaluate, opts.MaxValueLen)
+
+		if opts.QuietBreakpoints {
+			state.recordCheckpoint(label, debugInfo)
+			return goja.Undefined()
+		}
+
+		if opts.MaxBreakpoints > 0 && hits > opts.MaxBreakpoints {
+			if state.SkippedBreakpoints == 0 {
+				fmt.Fprintf(opts.Stdout, "\n|_| Breakpoint limit (%d) reached, continuing without pausing\n", opts.MaxBreakpoints)
+			}
+			state.SkippedBreakpoints++
+			return goja.Undefined()
+		}
+
+		where, source := "", state.Source
+		if m := state.module(scope); m != nil {
+			where, source = " in "+m.Name, m.Source
+		}
+		current := make(map[string]string, len(debugInfo))
+		for k, v := range debugInfo {
+			current[k] = inspectLine(v, opts)
+		}
+
+		if opts.ChangedOnly && state.lastPause != nil {
+			fmt.Fprintf(opts.Stdout, "\n|_| Breakpoint %s hit%s! Changes since the previous pause:\n", label, where)
+			printSnapshotDiff(vm, evaluate, debugInfo, state, opts)
+		} else if lines := state.scopeLines(scope, opts.MaxValueLen); len(lines) > 0 {
+			fmt.Fprintf(opts.Stdout, "\n|_| Breakpoint %s hit%s! Variables in scope:\n", label, where)
+			for _, line := range lines {
+				fmt.Fprintf(opts.Stdout, "%s%s\n", opts.Indent, line)
+			}
+		} else {
+			fmt.Fprintf(opts.Stdout, "\n|_| Breakpoint %s hit%s! Current variables:\n", label, where)
+			for _, k := range sortedKeys(debugInfo) {
+				fmt.Fprintf(opts.Stdout, "%s%s: %s\n", opts.Indent, k, fitValue(current[k], debugInfo[k], opts.Indent, opts))
+			}
+		}
+		state.printWatches(opts)
+		if len(stack) > 0 {
+			fmt.Fprintln(opts.Stdout, "Call stack:")
+			for _, f := range stack {
+				fmt.Fprintf(opts.Stdout, "%sat %s\n", opts.Indent, f)
+			}
+		}
+		if line > 0 {
+			fmt.Fprintln(opts.Stdout)
+			printSourceContext(source, line, opts)
+		}
+		// what the next pause is compared with, once this one is done
+		defer func() { state.lastPause = pauseSnapshot(vm, evaluate, debugInfo) }()
+		if !opts.NoFiles {
+			state.Stack = stack
+			writeDebugInfoToFile(debugInfo, "BREAKPOINT SNAPSHOT: "+label, state, opts)
+			state.Stack = nil
+		}
+
+		if state.inspector != nil {
+			if where != "" {
+				return goja.Undefined()
+			}
+			reason := "other"
+			if label == "exception" {
+				reason = "exception"
+			}
+			state.inspector.pause(reason, nil, stack, scope, evaluate)
+			return goja.Undefined()
+		}
+		if opts.Hooks.Breakpoint != nil {
+			release := state.hold()
+			proceed := opts.Hooks.Breakpoint(label, line, maps.Clone(debugInfo))
+			release()
+			if !proceed {
+				quit()
+			}
+			return goja.Undefined()
+		}
+
+		resume := "Press ENTER to continue"
+		if opts.Step {
+			resume = "ENTER / c continue, n step, q quit"
+		}
+		switch breakpointPrompt(vm, stdin, resume, evaluate, debugInfo, state, opts) {
+		case "n":
+			stepping = opts.Step
+		case "q":
+			quit()
+		}
+		return goja.Undefined()
+	}
+	setHook(vm, "__breakpoint", -1, breakpoint)
+	pauseAt = func(label string, line int) {
+		if label == "" {
+			return
+		}
+		args := []goja.Value{goja.Undefined(), vm.ToValue(label), goja.Undefined()}
+		if line > 0 {
+			args[2] = vm.ToValue(line)
+		}
+		breakpoint(goja.FunctionCall{This: goja.Undefined(), Arguments: args})
+	}
+
+	// __exception(scope, value, line, rethrown) pauses as a breakpoint
+	// labelled "exception" and returns value to be thrown. A rethrown
+	// value, one passing through a function under -break-on-exception,
+	// only pauses if it didn't already.
+	var thrown goja.Value
+	setHook(vm, "__exception", 1, func(call goja.FunctionCall) goja.Value {
+		value := call.Argument(1)
+		if call.Argument(3).ToBoolean() && thrown != nil && thrown.SameAs(value) {
+			return value
+		}
+		thrown = value
+		line, stack := int(call.Argument(2).ToInteger()), callStack(vm, state, opts)
+		if line == 0 {
+			// caught on the way out of a function: the innermost frame is
+			// where the error came from, not the catch
+			var column int
+			if line, column = thrownAt(value, opts.Script); line > 0 && len(stack) > 0 {
+				stack[0].Line, stack[0].Column = line, sourceMaps{state.Columns, state.Transpiled}.column(line, column)
+			}
+		}
+		scope := argScope(call.Argument(0))
+		state.Thrown = &Thrown{Message: describeThrown(value), Line: line, Scope: state.scopeLines(scope, opts.MaxValueLen), Stack: stack}
+		if line > 0 {
+			fmt.Fprintf(opts.Stdout, "\n|!| Exception thrown at %s:%d: %s\n", opts.Script, line, state.Thrown.Message)
+		} else {
+			fmt.Fprintf(opts.Stdout, "\n|!| Exception thrown: %s\n", state.Thrown.Message)
+		}
+
+		args := []goja.Value{goja.Undefined(), vm.ToValue("exception"), goja.Undefined()}
+		if line > 0 {
+			args[2] = vm.ToValue(line)
+		}
+		if _, instrumented := goja.AssertFunction(call.This); instrumented {
+			args = append([]goja.Value{call.Argument(0)}, args...)
+		}
+		exceptionStack = stack
+		breakpoint(goja.FunctionCall{This: call.This, Arguments: args})
+		exceptionStack = nil
+		return value
+	})
+}
+
+// setHook sets one of the functions the instrumenter calls. While a
+// getter runs for a capture, the hooks in it do nothing but hand back the
+// argument at pass, or undefined when pass is negative, which is what the
+// hooked expression evaluates to.
+func setHook(vm *goja.Runtime, name string, pass int, hook func(goja.FunctionCall) goja.Value) {
+	vm.Set(name, func(call goja.FunctionCall) goja.Value {
+		if !inGetter(vm) {
+			return hook(call)
+		}
+		if pass < 0 {
+			return goja.Undefined()
+		}
+		return call.Argument(pass)
+	})
+}
+
+// argScope reads the scope number a hook was passed, -1 when it wasn't.
+func argScope(v goja.Value) int {
+	if goja.IsUndefined(v) {
+		return -1
+	}
+	return int(v.ToInteger())
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// captureStatement is the code appended after a declaration of name.
+// With -capture-when the capture is guarded by the predicate, and a
+// predicate that throws simply skips it.
+func captureStatement(name string, line, scope int, opts *Config) string {
+	var capture strings.Builder
+	if opts.FinalOnly {
+		fmt.Fprintf(&capture, "; __final(%s, () => %s, %d, %d)", strconv.Quote(name), name, line, scope)
+	} else {
+		opts.capture().Execute(&capture, captureSite{Name: strconv.Quote(name), Expr: name, Line: line, Scope: scope})
+	}
+	if opts.CaptureWhen == "" {
+		return capture.String()
+	}
+	return fmt.Sprintf("; try { if (%s) { %s } } catch (__e) {}", opts.CaptureWhen, strings.TrimPrefix(capture.String(), "; "))
+}
+
+func instrumentCode(script string, opts *Config) (string, []LoopInfo, []Warning, []Scope, sourceMap, []CoveredStatement) {
+	columns := make(sourceMap)
+
+	// A script that doesn't parse is run as is, so the syntax error is
+	// reported like any other.
+	instrumented := script
+	var detectedLoops []LoopInfo
+	var warnings []Warning
+	var scopes []Scope
+	var coverage []CoveredStatement
+	if program, err := parser.ParseFile(nil, opts.Script, script, 0, parser.WithDisableSourceMaps); err == nil {
+		in := newInstrumenter(program, script, opts)
+		in.body(program.Body, -1)
+		in.finishLoops()
+		instrumented, detectedLoops, warnings, scopes, coverage = in.apply(columns), in.loops, in.warnings, in.scopes, in.coverage
+		for _, line := range sortedKeys(opts.Breaks) {
+			if !in.paused[line] {
+				fmt.Fprintf(opts.Stdout, "|!| -break %d: no statement starts on line %d, ignoring it\n", line, line)
+			}
+		}
+		for _, line := range sortedKeys(opts.Logpoints) {
+			if !in.paused[line] {
+				fmt.Fprintf(opts.Stdout, "|!| -logpoint %d: no statement starts on line %d, ignoring it\n", line, line)
+			}
+		}
+	}
+
+	for _, w := range warnings {
+		fmt.Fprintf(opts.Stdout, "|!| %s\n", w)
+	}
+
+	if !opts.NoInstrumentedDump {
+		fmt.Fprintln(opts.progress(), "\n|||> Instrumented JS code:")
+		fmt.Fprintln(opts.progress(), instrumented)
+	}
+
+	return instrumented, detectedLoops, warnings, scopes, columns, coverage
+}
+
+// execute runs the top level of the instrumented script on loop, which
+// then runs the timers and promise jobs it left. The returned func is
+// called once the loop stopped and gives the error the script, or one of
+// its callbacks, failed with.
+func execute(vm *goja.Runtime, loop *eventloop.EventLoop, instrumentCode string, state *RunState, opts *Config) func() error {
+	started := time.Now()
+	if opts.Timeout > 0 {
+		// TimedOut is only read once the guard is stopped
+		state.guard = newLoopGuard(opts.Timeout, func() {
+			state.TimedOut = true
+			vm.Interrupt("timeout")
+			loop.StopNoWait()
+		})
+	}
+	if opts.MaxMemory > 0 {
+		state.heap = watchHeap(opts.MaxMemory, func() {
+			vm.Interrupt("memory")
+			loop.StopNoWait()
+		})
+	}
+	if state.Profile != nil {
+		state.Profile.started = state.clock()
+	}
+	_, err := vm.RunScript(opts.Script, instrumentCode)
+	if err != nil || state.Quit {
+		state.halted = true
+		loop.StopNoWait()
+	}
+
+	return func() error {
+		state.guard.stop()
+		if size := state.heap.close(); size > 0 {
+			state.Limit = fmt.Sprintf("the heap grew to %d MiB, past -max-memory %d MiB", size>>20, opts.MaxMemory>>20)
+		}
+		if state.Pending = loop.Stop(); state.Pending > 0 {
+			loop.Terminate()
+		}
+		vm.ClearInterrupt()
+		if err == nil {
+			err = state.asyncErr
+		}
+		if state.finishFinals != nil {
+			state.finishFinals()
+		}
+		if state.Profile != nil {
+			state.Profile.elapsed = state.clock() - state.Profile.started
+		}
+		state.Timings.Execute = time.Since(started)
+		return err
+	}
+}
+
+// requireCaptures fails a run that captured nothing under
+// -require-captures, however it finished; a run that crashed still
+// returns its crash, with this said too.
+func requireCaptures(debugInfo map[string]any, opts *Config) error {
+	if opts.RequireCaptures && len(debugInfo) == 0 {
+		fmt.Fprintln(opts.Stderr, "No variables were captured: no let/const/var declarations were instrumented (-require-captures)")
+		return errors.New("no variables were captured")
+	}
+	return nil
+}
+
+// executeAndAnalyze reports on a run that ended with err. A JS error is
+// reported and returned as a *crashReport, in original positions.
+func executeAndAnalyze(vm *goja.Runtime, err error, debugInfo map[string]any, detectedLoops []LoopInfo, state *RunState, opts *Config) error {
+	var interrupted *goja.InterruptedError
+	if state.Quit && errors.As(err, &interrupted) {
+		fmt.Fprintln(opts.Stdout, "\n|~| Quit, reporting what ran so far")
+		err = nil
+	}
+	if reason := state.stopReason(opts); reason != "" && (err == nil || errors.As(err, &interrupted)) {
+		where := ""
+		if interrupted != nil {
+			if state.Stack = scriptFrames(interrupted.Stack(), opts.Script, state); len(state.Stack) > 0 {
+				where = " in " + state.Stack[0].String()
+			}
+		}
+		fmt.Fprintf(opts.Stdout, "\n|~| Stopped%s: %s, reporting what ran so far\n", where, reason)
+		err = nil
+	}
+	if err != nil {
+		state.Crash = newCrashReport(err, opts.Script, state)
+	}
+	if state.Recording != nil {
+		if err != nil {
+			site, _ := state.Crash.site()
+			state.tape(RecordedEvent{Kind: "crash", Line: site.Line, Text: state.Crash.Message, Stack: state.Crash.Frames})
+		}
+		writeRecording(opts.Record, state, opts)
+	}
+	if opts.BreakOnException {
+		postMortem(vm, err, state, opts)
+	}
+	if opts.FirstErrorOnly {
+		if err != nil {
+			printFirstError(state.Crash, state.Source, debugInfo, state, opts)
+			requireCaptures(debugInfo, opts)
+			return state.Crash
+		}
+		fmt.Fprintln(opts.Stdout, "\n |> No error: script ran to completion")
+		return requireCaptures(debugInfo, opts)
+	}
+	if err != nil {
+		if _, ok := state.Crash.site(); ok {
+			fmt.Fprintf(opts.Stderr, "JS Execution Error at %v\n", state.Crash)
+		} else {
+			fmt.Fprintf(opts.Stderr, "JS Execution Error: %v\n", state.Crash)
+		}
+		if len(state.Crash.Frames) > 0 {
+			fmt.Fprintln(opts.Stdout, "\n |> Crash Trace: ")
+			for _, f := range state.Crash.Frames {
+				fmt.Fprintf(opts.Stdout, "%sat %s\n", opts.Indent, f)
+			}
+		}
+		if !opts.NoFiles {
+			writeDebugInfoToFile(debugInfo, "ERROR SNAPSHOT", state, opts)
+			writeCallTraceFile(state, opts)
+			writeCoverageFiles(state, opts)
+			fmt.Fprintf(opts.Stdout, "Partial snapshot and crash trace saved to %s\n", opts.reportFile("output"))
+		}
+		requireCaptures(debugInfo, opts)
+		return state.Crash
+	}
+
+	started := time.Now()
+	if !opts.NoFiles {
+		writeDebugInfoToFile(debugInfo, "FINAL SNAPSHOT", state, opts)
+		if opts.WarningsOut != "" {
+			if err := writeWarningsJSON(opts.WarningsOut, state.Warnings, opts.Indent); err != nil {
+				fmt.Fprintf(opts.Stderr, "Could not write %s: %v\n", opts.WarningsOut, err)
+			}
+		}
+		if state.Profile != nil {
+			if err := state.Profile.writeFolded(opts.foldedPath()); err != nil {
+				fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", opts.foldedPath(), err)
+			}
+		}
+		if opts.Annotate != "" {
+			writeAnnotatedSource(opts.Annotate, state, opts)
+		}
+		writeCallTraceFile(state, opts)
+		writeCoverageFiles(state, opts)
+		if len(detectedLoops) > 0 {
+			writeLoopInfoToFile(detectedLoops, debugInfo, state, opts)
+		}
+	}
+	state.Timings.Write = time.Since(started)
+
+	out := opts.progress()
+	if len(detectedLoops) > 0 {
+		if opts.NoFiles {
+			fmt.Fprintf(out, "\n Detected %d Loop. \n", len(detectedLoops))
+		} else {
+			fmt.Fprintf(out, "\n Detected %d Loop. Loop analysis saved to %s \n", len(detectedLoops), opts.reportFile("loops"))
+		}
+	}
+
+	if d := opts.LoopDiff; d != nil {
+		fmt.Fprintf(out, "\n |> Loop %d, iteration %d vs %d: \n", d.Loop, d.From, d.To)
+		if d.Loop > len(detectedLoops) {
+			fmt.Fprintf(out, "%sthere is no loop %d (%d detected)\n", opts.Indent, d.Loop, len(detectedLoops))
+		} else {
+			for _, line := range iterationDiff(state.Loops[d.Loop-1], d.From, d.To, opts.MaxValueLen) {
+				fmt.Fprintf(out, "%s%s\n", opts.Indent, line)
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "\n |> Final Snapshot: ")
+	printFinalSnapshot(out, debugInfo, state, opts)
+	fmt.Fprintf(out, "\n Max stack depth: %d\n", state.MaxDepth)
+
+	if len(state.Checkpoints) > 0 {
+		fmt.Fprintf(out, "\n Recorded %d quiet breakpoint snapshot(s)\n", len(state.Checkpoints))
+	}
+	if state.SkippedBreakpoints > 0 {
+		fmt.Fprintf(out, " Breakpoint limit %d reached: %d later hits auto-continued\n", opts.MaxBreakpoints, state.SkippedBreakpoints)
+	}
+
+	if len(state.Async) > 0 {
+		fmt.Fprintln(out, "\n |> Async: ")
+		for _, t := range state.Async {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, t)
+		}
+	}
+
+	if len(state.Modules) > 0 {
+		fmt.Fprintln(out, "\n |> Modules: ")
+		for _, m := range state.Modules {
+			fmt.Fprintf(out, "%s%s -> %s\n", opts.Indent, m.Specifier, m.Path)
+			for _, k := range sortedKeys(m.Variables) {
+				fmt.Fprintf(out, "%s%s%s: %s\n", opts.Indent, opts.Indent, k, inspectLine(m.Variables[k], opts))
+			}
+		}
+	}
+
+	if opts.Profile {
+		fmt.Fprintln(out, "\n |> Hot spots: ")
+		for _, line := range profileTable(state, 10) {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, line)
+		}
+		if !opts.NoFiles {
+			fmt.Fprintf(out, " Folded stacks saved to %s\n", opts.foldedPath())
+		}
+	}
+
+	if opts.Coverage {
+		fmt.Fprintln(out, "\n |> Coverage: ")
+		for _, c := range state.coverageByFile(opts) {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, c.summary())
+		}
+		if !opts.NoFiles && !opts.singleReport() {
+			fmt.Fprintf(out, " Source with line counts saved to %s\n", filepath.Join(opts.Out, "coverage.txt"))
+		}
+	}
+
+	if len(state.Messages) > 0 {
+		fmt.Fprintln(out, "\n |> Messages: ")
+		for _, m := range state.Messages {
+			fmt.Fprintf(out, "%s#%d %s: %s\n", opts.Indent, m.Seq, m.Channel, renderValue(m.Value, opts.MaxValueLen))
+		}
+	}
+
+	if len(state.LastArgs) > 0 {
+		fmt.Fprintln(out, "\n |> Last call arguments: ")
+		for _, fn := range sortedKeys(state.LastArgs) {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, describeCall(fn, state.LastArgs[fn], opts.MaxValueLen))
+		}
+	}
+
+	if state.Mutations != nil && len(state.Mutations.Functions) > 0 {
+		fmt.Fprintln(out, "\n |> Mutations: ")
+		for _, fn := range state.Mutations.Functions {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, state.Mutations.describe(fn))
+		}
+	}
+
+	if len(state.ElementWrites) > 0 {
+		fmt.Fprintln(out, "\n |> Element writes: ")
+		for _, w := range state.ElementWrites {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, w.render(opts.MaxValueLen))
+		}
+	}
+
+	if len(state.JSONCalls) > 0 {
+		fmt.Fprintln(out, "\n |> JSON: ")
+		for _, c := range state.JSONCalls {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, c.render(opts.MaxValueLen))
+		}
+	}
+
+	if len(state.EnvReads) > 0 {
+		fmt.Fprintln(out, "\n |> Env reads: ")
+		for _, r := range state.EnvReads {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, r)
+		}
+	}
+
+	if len(state.Expectations) > 0 {
+		fmt.Fprintf(out, "\n |> Expectations: %d/%d passed\n", passedExpectations(state.Expectations), len(state.Expectations))
+		for _, e := range state.Expectations {
+			fmt.Fprintf(out, "%s%s\n", opts.Indent, describeExpectation(e, opts.MaxValueLen))
+		}
+	}
+
+	if opts.Timings {
+		fmt.Fprintf(out, "\n Timings: %s\n", state.Timings)
+	}
+
+	if err := requireCaptures(debugInfo, opts); err != nil {
+		return err
+	}
+
+	if opts.NoFiles {
+		fmt.Fprintln(out, "Finished execution...")
+		return nil
+	}
+	fmt.Fprintf(out, "Finished execution... see %s file...\n", opts.reportFile("output"))
+	return nil
+}
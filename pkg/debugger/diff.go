@@ -0,0 +1,111 @@
+package debugger
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// snapshotDiff lists what changed from one snapshot of the captured
+// variables to the next: `+ name: value` for a variable captured since,
+// `~ name: old → new` for a changed value. Objects are compared by
+// property, so a changed, added or deleted key is named by its path
+// (`~ user.name`, `- user.tmp`). unchanged counts the variables that are
+// the same in both.
+func snapshotDiff(before, after map[string]any, opts *Config) (lines []string, unchanged int) {
+	for _, name := range sortedKeys(after) {
+		old, seen := before[name]
+		if !seen {
+			lines = append(lines, fmt.Sprintf("+ %s: %s", name, inspectLine(after[name], opts)))
+			continue
+		}
+		changes := valueDiff(name, old, after[name], opts)
+		if len(changes) == 0 {
+			unchanged++
+		}
+		lines = append(lines, changes...)
+	}
+	return lines, unchanged
+}
+
+// valueDiff is snapshotDiff for one value at path.
+func valueDiff(path string, before, after any, opts *Config) []string {
+	if renderValue(before, 0) == renderValue(after, 0) {
+		return nil
+	}
+	old, now := inspectLine(before, opts), inspectLine(after, opts)
+	oldFields, wasObject := objectFields(before)
+	fields, isObject := objectFields(after)
+	if !wasObject || !isObject {
+		return []string{fmt.Sprintf("~ %s: %s → %s", path, old, now)}
+	}
+
+	keys := maps.Clone(fields)
+	for k, v := range oldFields {
+		if _, kept := fields[k]; !kept {
+			keys[k] = v
+		}
+	}
+	var lines []string
+	for _, k := range sortedKeys(keys) {
+		at := propertyPath(path, k)
+		value, is := fields[k]
+		oldValue, was := oldFields[k]
+		switch {
+		case !was:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", at, inspectLine(value, opts)))
+		case !is:
+			lines = append(lines, fmt.Sprintf("- %s: %s", at, inspectLine(oldValue, opts)))
+		default:
+			lines = append(lines, valueDiff(at, oldValue, value, opts)...)
+		}
+	}
+	if len(lines) == 0 {
+		// the same properties, rendered apart by their class
+		lines = []string{fmt.Sprintf("~ %s: %s → %s", path, old, now)}
+	}
+	return lines
+}
+
+// propertyPath is key of the object at path, `path.key` or `path["a b"]`.
+func propertyPath(path, key string) string {
+	if plainKeyRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+// pauseSnapshot is the captured variables as they are at a pause. Each is
+// read again where it resolves, so an object changed in place since its
+// assignment was captured shows up; the others keep their captured value.
+func pauseSnapshot(vm *goja.Runtime, evaluate func(string) (goja.Value, error), debugInfo map[string]any) map[string]any {
+	snapshot := maps.Clone(debugInfo)
+	for name := range snapshot {
+		if value, err := evaluate(name); err == nil {
+			snapshot[name] = captureValue(vm, value)
+		}
+	}
+	return snapshot
+}
+
+// printSnapshotDiff prints what changed since the previous pause, for
+// -diff-only and the `diff` command.
+func printSnapshotDiff(vm *goja.Runtime, evaluate func(string) (goja.Value, error), debugInfo map[string]any, state *RunState, opts *Config) {
+	if state.lastPause == nil {
+		fmt.Fprintf(opts.Stdout, "%sno earlier pause to compare with\n", opts.Indent)
+		return
+	}
+	lines, unchanged := snapshotDiff(state.lastPause, pauseSnapshot(vm, evaluate, debugInfo), opts)
+	if len(lines) == 0 {
+		fmt.Fprintf(opts.Stdout, "%snothing changed since the previous pause\n", opts.Indent)
+		return
+	}
+	for _, line := range lines {
+		fmt.Fprintf(opts.Stdout, "%s%s\n", opts.Indent, line)
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(opts.Stdout, "%s(%d unchanged variables omitted)\n", opts.Indent, unchanged)
+	}
+}
@@ -0,0 +1,87 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/token"
+)
+
+// ElementWrite is one assignment to an array element (-track-elements).
+type ElementWrite struct {
+	Seq   int
+	Line  int
+	Array string
+	Index string
+	Value any
+}
+
+func (w ElementWrite) render(limit int) string {
+	return fmt.Sprintf("#%d line %d: %s[%s] = %s", w.Seq, w.Line, w.Array, w.Index, renderValue(w.Value, limit))
+}
+
+type elementTarget struct {
+	array, index string
+}
+
+// hook is the __element report appended after the statement writing t.
+func (t elementTarget) hook(line int) string {
+	return fmt.Sprintf("; __element(%s, %s, %s[%s], %d)", strconv.Quote(t.array), t.index, t.array, t.index, line)
+}
+
+// elementTargets finds the `arr[i] = v`, `arr[i] += v` and
+// `[arr[i], arr[j]] = ...` writes in the expression of a statement. Writes
+// whose index has side effects (`arr[i++]`, `arr[next()]`) can't be re-read
+// safely and are skipped.
+func (in *instrumenter) elementTargets(e ast.Expression) []elementTarget {
+	var targets []elementTarget
+	add := func(target ast.Expression) {
+		el, ok := target.(*ast.BracketExpression)
+		if !ok {
+			return
+		}
+		array, ok := el.Left.(*ast.Identifier)
+		if ok && pure(el.Member) {
+			index := strings.TrimSpace(in.src[in.start(el.Member):in.offset(el.RightBracket)])
+			targets = append(targets, elementTarget{array: array.Name.String(), index: index})
+		}
+	}
+
+	switch e := e.(type) {
+	case *ast.AssignExpression:
+		switch left := e.Left.(type) {
+		case *ast.ArrayPattern:
+			for _, el := range left.Elements {
+				add(el)
+			}
+		case *ast.ArrayLiteral:
+			for _, el := range left.Value {
+				add(el)
+			}
+		default:
+			add(left)
+		}
+	case *ast.SequenceExpression:
+		for _, part := range e.Sequence {
+			targets = append(targets, in.elementTargets(part)...)
+		}
+	}
+	return targets
+}
+
+// pure reports whether evaluating e again can't change anything.
+func pure(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.Identifier, *ast.NumberLiteral, *ast.StringLiteral:
+		return true
+	case *ast.DotExpression:
+		return pure(e.Left)
+	case *ast.BinaryExpression:
+		return pure(e.Left) && pure(e.Right)
+	case *ast.UnaryExpression:
+		return e.Operator != token.INCREMENT && e.Operator != token.DECREMENT && e.Operator != token.DELETE && pure(e.Operand)
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package debugger
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// EnvRead is an environment variable the script looked up on process.env.
+type EnvRead struct {
+	Name string
+	Set  bool
+
+	// Hidden means the host has the variable but -env didn't expose it.
+	Hidden bool
+}
+
+func (r EnvRead) String() string {
+	switch {
+	case r.Set:
+		return r.Name + " (set)"
+	case r.Hidden:
+		return r.Name + " (not exposed, add it to -env)"
+	default:
+		return r.Name + " (not set)"
+	}
+}
+
+// envObject backs process.env, recording each variable the script reads.
+// Reads are recorded without their values, which may be secrets.
+type envObject struct {
+	vm    *goja.Runtime
+	vars  map[string]string
+	state *RunState
+	seen  map[string]bool
+}
+
+// newEnvObject exposes the host variables named in list ("*" for all).
+func newEnvObject(vm *goja.Runtime, list []string, state *RunState) *envObject {
+	env := &envObject{vm: vm, vars: make(map[string]string), state: state, seen: make(map[string]bool)}
+	for _, name := range list {
+		if name == "*" {
+			for _, kv := range os.Environ() {
+				if k, v, ok := strings.Cut(kv, "="); ok {
+					env.vars[k] = v
+				}
+			}
+			continue
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			env.vars[name] = v
+		}
+	}
+	return env
+}
+
+func (e *envObject) record(name string) {
+	if e.seen[name] {
+		return
+	}
+	e.seen[name] = true
+	_, set := e.vars[name]
+	_, onHost := os.LookupEnv(name)
+	e.state.EnvReads = append(e.state.EnvReads, EnvRead{Name: name, Set: set, Hidden: !set && onHost})
+}
+
+func (e *envObject) Get(key string) goja.Value {
+	e.record(key)
+	if v, ok := e.vars[key]; ok {
+		return e.vm.ToValue(v)
+	}
+	return nil
+}
+
+func (e *envObject) Set(key string, val goja.Value) bool {
+	e.vars[key] = val.String()
+	return true
+}
+
+func (e *envObject) Has(key string) bool {
+	_, ok := e.vars[key]
+	return ok
+}
+
+func (e *envObject) Delete(key string) bool {
+	delete(e.vars, key)
+	return true
+}
+
+func (e *envObject) Keys() []string {
+	keys := make([]string, 0, len(e.vars))
+	for k := range e.vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+)
+
+// throw makes a `throw x` pause under -break-on-exception, with x, in
+// the scope of the throw: `throw __exception.call(<scopeEvaluator>,
+// <scope>, x, <line>)`. __exception hands x back to be thrown.
+func (in *instrumenter) throw(s *ast.ThrowStatement) {
+	if !in.opts.BreakOnException {
+		return
+	}
+	in.open(in.start(s.Argument), fmt.Sprintf("__exception.call(%s, %d, ", in.evaluator, in.scope))
+	in.close(in.end(s.Argument), fmt.Sprintf(", %d)", in.line(in.start(s))))
+}
+
+// rethrow is the catch clause wrapBody adds under -break-on-exception, so
+// errors the runtime raises (`null.x`) pause too, in the scope of the
+// innermost function they pass through. An error that already paused,
+// at its throw or in a function further in, doesn't pause again.
+// -trace-calls uses it to tell a call that threw from one that returned.
+func (in *instrumenter) rethrow() string {
+	if !in.opts.BreakOnException && !in.opts.TraceCalls {
+		return ""
+	}
+	trace := ""
+	if in.opts.TraceCalls {
+		trace = "__threw(); "
+	}
+	if !in.opts.BreakOnException {
+		return "} catch (__error) { " + trace + "throw __error; "
+	}
+	return fmt.Sprintf("} catch (__error) { %sthrow __exception.call(%s, %d, __error, 0, true); ", trace, in.evaluator, in.scope)
+}
+
+// Thrown is an exception -break-on-exception paused at: what was thrown,
+// where, the variables in scope there and the call stack.
+type Thrown struct {
+	Message string
+	Line    int
+	Scope   []string
+	Stack   []Frame
+}
+
+// describeThrown renders a thrown value as the crash trace does, e.g.
+// "TypeError: Cannot read property 'x' of null".
+func describeThrown(v goja.Value) string {
+	if v == nil {
+		return "undefined"
+	}
+	return v.String()
+}
+
+// thrownAt is the script line and column an Error object was created at,
+// 0, 0 when v isn't one. Its stack names them in the instrumented code,
+// whose lines are the original ones.
+func thrownAt(v goja.Value, script string) (line, column int) {
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		return 0, 0
+	}
+	stack := obj.Get("stack")
+	if stack == nil || goja.IsUndefined(stack) {
+		return 0, 0
+	}
+	m := regexp.MustCompile(`[( ]` + regexp.QuoteMeta(script) + `:(\d+):(\d+)`).FindStringSubmatch(stack.String())
+	if m == nil {
+		return 0, 0
+	}
+	line, _ = strconv.Atoi(m[1])
+	column, _ = strconv.Atoi(m[2])
+	return line, column
+}
+
+// postMortem pauses on an uncaught error that didn't pause where it was
+// thrown, one raised outside any function, in the global scope that is
+// left, and reports the promise rejections nothing handled.
+func postMortem(vm *goja.Runtime, err error, state *RunState, opts *Config) {
+	var exception *goja.Exception
+	if errors.As(err, &exception) {
+		site, _ := state.Crash.site()
+		if pause, ok := goja.AssertFunction(vm.Get("__exception")); ok {
+			pause(goja.Undefined(), vm.ToValue(-1), exception.Value(), vm.ToValue(site.Line), vm.ToValue(true))
+		}
+		if state.Thrown != nil && len(state.Thrown.Stack) == 0 {
+			state.Thrown.Stack = state.Crash.Frames
+		}
+	}
+
+	state.Rejections = state.unhandled()
+	for _, reason := range state.Rejections {
+		fmt.Fprintf(opts.Stdout, "\n|!| Unhandled promise rejection: %s\n", reason)
+	}
+}
+
+// trackRejections records, under -break-on-exception, the promises that
+// get rejected without a handler, dropping them once one is attached.
+// What is left when the script ends was never handled.
+func trackRejections(vm *goja.Runtime, state *RunState) {
+	pending := make(map[*goja.Promise]bool)
+	var order []*goja.Promise
+	vm.SetPromiseRejectionTracker(func(p *goja.Promise, op goja.PromiseRejectionOperation) {
+		switch op {
+		case goja.PromiseRejectionReject:
+			pending[p] = true
+			order = append(order, p)
+		case goja.PromiseRejectionHandle:
+			delete(pending, p)
+		}
+	})
+	state.unhandled = func() []string {
+		var reasons []string
+		for _, p := range order {
+			if pending[p] {
+				reasons = append(reasons, describeThrown(p.Result()))
+				delete(pending, p)
+			}
+		}
+		return reasons
+	}
+}
@@ -0,0 +1,59 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+)
+
+// -break-on-exception wraps every function in a catch that pauses, which
+// has to run in functions with default or rest parameters too.
+func TestBreakOnExceptionParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		pauses int
+		thrown string // what the pause was for, when there is one
+		want   string // caught's captures
+	}{
+		{
+			name:   "never throws",
+			script: "function dflt(a, b, ...r) {\n  return a + b;\n}\nconst caught = dflt(1, 2);",
+			want:   "[3]",
+		},
+		{
+			name:   "default and rest",
+			script: "function f(a = 1, ...r) {\n  throw new Error(\"bad \" + a);\n}\nlet caught;\ntry {\n  f();\n} catch (e) {\n  caught = e.message;\n}",
+			pauses: 1,
+			thrown: "bad 1",
+			want:   "[undefined bad 1]",
+		},
+		{
+			name:   "runtime error in a pattern",
+			script: "function f({ a }) {\n  return a.b.c;\n}\nlet caught;\ntry {\n  f({ a: 1 });\n} catch (e) {\n  caught = e.name;\n}",
+			pauses: 1,
+			thrown: "TypeError",
+			want:   "[undefined TypeError]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pauses := 0
+			r := runScript(t, tt.script, func(c *Config) {
+				c.BreakOnException = true
+				c.Hooks.Breakpoint = func(string, int, map[string]any) bool {
+					pauses++
+					return true
+				}
+			})
+			if pauses != tt.pauses {
+				t.Errorf("paused %d times, want %d", pauses, tt.pauses)
+			}
+			if tt.pauses > 0 && (r.State.Thrown == nil || !strings.Contains(r.State.Thrown.Message, tt.thrown)) {
+				t.Errorf("paused for %+v, want %q", r.State.Thrown, tt.thrown)
+			}
+			if got := captured(r, "caught"); got != tt.want {
+				t.Errorf("caught captured %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
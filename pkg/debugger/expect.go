@@ -0,0 +1,57 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// Expectation is one expect(actual, expected, label) check. Unlike an
+// assertion it never stops the script; it's only reported.
+type Expectation struct {
+	Label    string
+	Actual   any
+	Expected any
+	Pass     bool
+}
+
+// expectationPasses compares primitives with SameValue semantics (so NaN
+// matches NaN but 1 doesn't match "1") and objects structurally, by their
+// captured form.
+func expectationPasses(vm *goja.Runtime, actual, expected goja.Value) bool {
+	_, actualObj := actual.(*goja.Object)
+	_, expectedObj := expected.(*goja.Object)
+	if !actualObj && !expectedObj {
+		return actual.SameAs(expected)
+	}
+	if actualObj != expectedObj {
+		return false
+	}
+	return renderValue(captureValue(vm, actual), 0) == renderValue(captureValue(vm, expected), 0)
+}
+
+// passedExpectations counts the checks that held.
+func passedExpectations(expectations []Expectation) int {
+	passed := 0
+	for _, e := range expectations {
+		if e.Pass {
+			passed++
+		}
+	}
+	return passed
+}
+
+func describeExpectation(e Expectation, limit int) string {
+	// quote strings so that 1 vs "1" reads as a real difference
+	operand := func(v any) string {
+		if s, isString := v.(string); isString {
+			return renderValue(strconv.Quote(s), limit)
+		}
+		return renderValue(v, limit)
+	}
+	if e.Pass {
+		return fmt.Sprintf("PASS %s: %s", e.Label, operand(e.Actual))
+	}
+	return fmt.Sprintf("FAIL %s: got %s, expected %s", e.Label, operand(e.Actual), operand(e.Expected))
+}
@@ -0,0 +1,115 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+)
+
+// function wraps the body of fn in
+// `__enter(name); try { ... } finally { __exit(name); }` so the runtime
+// can follow the call stack, including early returns and throws.
+func (in *instrumenter) function(fn *ast.FunctionLiteral, name string, loop int) {
+	if fn.Name != nil {
+		name = fn.Name.Name.String()
+	}
+	defer in.enterScope(name, in.line(in.offset(fn.Idx0())), true)()
+	defer in.parameterEvaluator(fn.ParameterList)()
+	in.declare(in.scope, paramNames(fn.ParameterList)...)
+	in.parameters(fn.ParameterList, loop)
+	in.wrapBody(fn.Body, name, paramNames(fn.ParameterList))
+	in.body(fn.Body.List, loop)
+}
+
+// arrow is function for arrow functions. An expression body has nowhere
+// to put the hooks and is only walked.
+func (in *instrumenter) arrow(fn *ast.ArrowFunctionLiteral, name string, loop int) {
+	defer in.enterScope(name, in.line(in.start(fn)), true)()
+	defer in.parameterEvaluator(fn.ParameterList)()
+	in.declare(in.scope, paramNames(fn.ParameterList)...)
+	in.parameters(fn.ParameterList, loop)
+	switch body := fn.Body.(type) {
+	case *ast.BlockStatement:
+		in.wrapBody(body, name, paramNames(fn.ParameterList))
+		in.body(body.List, loop)
+	case *ast.ExpressionBody:
+		in.expression(body.Expression, loop)
+	}
+}
+
+// parameterEvaluator switches to globalEvaluator for a function whose
+// parameter list isn't simple, and returns the function that switches
+// back.
+func (in *instrumenter) parameterEvaluator(params *ast.ParameterList) func() {
+	evaluator := in.evaluator
+	simple := params.Rest == nil
+	for _, p := range params.List {
+		if _, named := p.Target.(*ast.Identifier); !named || p.Initializer != nil {
+			simple = false
+		}
+	}
+	if !simple {
+		in.evaluator = globalEvaluator
+	}
+	return func() { in.evaluator = evaluator }
+}
+
+func (in *instrumenter) parameters(params *ast.ParameterList, loop int) {
+	for _, p := range params.List {
+		if p.Initializer != nil {
+			in.expression(p.Initializer, loop)
+		}
+	}
+}
+
+// paramNames returns the names a parameter list such as
+// `a, b = 2, { c, d: e }, ...rest` binds, whose values are passed to
+// __enter as received, i.e. after spread expansion.
+func paramNames(params *ast.ParameterList) []string {
+	names := bindingNames(params.List)
+	return append(names, patternNames(params.Rest)...)
+}
+
+// body is statements for a script or function body, whose directive
+// prologue gets no hooks of its own: in front of it, they would make the
+// directives plain strings.
+func (in *instrumenter) body(list []ast.Statement, loop int) {
+	in.statements(list[len(directives(list)):], loop)
+}
+
+// directives returns the directive prologue list starts with, the
+// `"use strict";` statements.
+func directives(list []ast.Statement) []ast.Statement {
+	for i, stmt := range list {
+		s, ok := stmt.(*ast.ExpressionStatement)
+		if !ok {
+			return list[:i]
+		}
+		if _, ok := s.Expression.(*ast.StringLiteral); !ok {
+			return list[:i]
+		}
+	}
+	return list
+}
+
+// wrapBody adds the enter and exit hooks to a function body. The enter
+// hook goes after any directive prologue ("use strict"; ...), since a
+// directive stops being one once other code comes before it.
+func (in *instrumenter) wrapBody(body *ast.BlockStatement, name string, params []string) {
+	hook := fmt.Sprintf("__enter(\"%s\"); try {", name)
+	if len(params) > 0 {
+		hook = fmt.Sprintf("__enter(\"%s\", { %s }); try {", name, strings.Join(params, ", "))
+	}
+
+	pos, sep := in.offset(body.LeftBrace)+1, " "
+	for _, stmt := range directives(body.List) {
+		pos, sep = in.end(stmt), "; "
+		if end := in.statementEnd(stmt); end != pos {
+			pos, sep = end, " "
+		}
+	}
+
+	in.insert(pos, sep+hook)
+	in.close(in.offset(body.RightBrace), in.rethrow()+fmt.Sprintf("} finally { __exit(\"%s\"); } ", name))
+}
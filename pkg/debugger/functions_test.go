@@ -0,0 +1,31 @@
+package debugger
+
+import "testing"
+
+func TestSpreadCallArguments(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		fn     string
+		want   string // its latest call
+	}{
+		{name: "spread array", script: "function f(a, b, c) { return a + b + c; }\nconst args = [1, 2, 3];\nf(...args);", fn: "f", want: "f(a = 1, b = 2, c = 3)"},
+		{name: "spread after an argument", script: "function f(a, b, c) {}\nf(0, ...[\"x\", \"y\"]);", fn: "f", want: "f(a = 0, b = x, c = y)"},
+		{name: "fewer than the parameters", script: "function f(a, b) {}\nf(...[1]);", fn: "f", want: "f(a = 1, b = undefined)"},
+		{name: "into rest", script: "function g(first, ...rest) {}\ng(1, ...[2, 3], 4);", fn: "g", want: "g(first = 1, rest = [ 2, 3, 4 ])"},
+		{name: "spread string", script: "function f(a, b) {}\nf(...\"hi\");", fn: "f", want: "f(a = h, b = i)"},
+		{name: "method", script: "const o = { m(x, y) { return x * y; } };\no.m(...[3, 4]);", fn: "m", want: "m(x = 3, y = 4)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			args, called := r.State.LastArgs[tt.fn]
+			if !called {
+				t.Fatalf("no call of %s recorded, got %v", tt.fn, r.State.LastArgs)
+			}
+			if got := describeCall(tt.fn, args, 0); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
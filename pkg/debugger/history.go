@@ -0,0 +1,127 @@
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/token"
+)
+
+// ValueEvent is one capture of a variable, by its declaration or by an
+// assignment, in run order: the value timeline. Seq counts from 1.
+type ValueEvent struct {
+	Seq   int
+	Name  string
+	Value any
+	Line  int
+	File  string // the module it is in, "" for the script
+	Time  time.Time
+}
+
+// render is the event as listed by `history` and the report, with its
+// time since the run started.
+func (e ValueEvent) render(started time.Time, limit int) string {
+	where := fmt.Sprintf("line %d", e.Line)
+	if e.File != "" {
+		where = e.File + " " + where
+	}
+	return fmt.Sprintf("#%d +%s %s: %s = %s", e.Seq, e.Time.Sub(started).Round(time.Microsecond), where, e.Name, renderValue(e.Value, limit))
+}
+
+func (s *RunState) recordEvent(name string, value any, line int, file string) {
+	s.Events = append(s.Events, ValueEvent{Seq: len(s.Events) + 1, Name: name, Value: value, Line: line, File: file, Time: time.Now()})
+}
+
+// printHistory lists every value name was captured with, for the
+// `history` command.
+func printHistory(name string, state *RunState, opts *Config) {
+	found := false
+	for _, e := range state.Events {
+		if e.Name == name && e.File == "" {
+			fmt.Fprintf(opts.Stdout, "%s%s\n", opts.Indent, e.render(state.Started, opts.MaxValueLen))
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintf(opts.Stdout, "  no captures of %s yet\n", name)
+	}
+}
+
+// declare notes that name is declared in scope, for resolve.
+func (in *instrumenter) declare(scope int, names ...string) {
+	if in.declared[scope] == nil {
+		in.declared[scope] = make(map[string]bool)
+	}
+	for _, name := range names {
+		in.declared[scope][name] = true
+	}
+}
+
+// resolve is the scope an assignment to name in the current scope writes
+// to: the nearest one seen declaring it, -1 when none did (yet).
+func (in *instrumenter) resolve(name string) int {
+	for scope := in.scope; scope >= 0; scope = in.scopes[scope].Parent {
+		if in.declared[scope][name] {
+			return scope
+		}
+	}
+	return -1
+}
+
+// assignment captures the variable an assignment or ++/-- writes, e.g.
+// `total += x` as `__assigned("total", total += x, () => [total], line,
+// scope)`: the hook reads the new value and hands back the expression's
+// own, which for `i++` is the old one. Properties and destructuring are
+// left out, as is everything under -final-only.
+//
+// The returned func ends the hook and must run once e's operands are
+// walked: the end goes in with the other insertions, ahead of the
+// capture of a declaration ending at the same offset (`let a = b = 1`),
+// and after the ends of assignments nested in e.
+func (in *instrumenter) assignment(e ast.Expression) func() {
+	if in.opts.FinalOnly {
+		return func() {}
+	}
+	// the hook wraps e itself, not the brackets around it, which may be
+	// the ones of an if or a call
+	var target ast.Expression
+	var end int
+	switch t := e.(type) {
+	case *ast.AssignExpression:
+		target, end = t.Left, in.balancedEnd(t.Right)
+	case *ast.UnaryExpression:
+		if t.Operator != token.INCREMENT && t.Operator != token.DECREMENT {
+			return func() {}
+		}
+		target, end = t.Operand, in.balancedEnd(t.Operand)
+		if t.Postfix {
+			// `x++`; a bracketed `(x)++` is left out
+			end = in.offset(t.Idx1())
+			if strings.TrimSpace(in.src[in.offset(t.Operand.Idx1()):end]) != t.Operator.String() {
+				return func() {}
+			}
+		}
+	}
+	id, ok := target.(*ast.Identifier)
+	if !ok {
+		return func() {}
+	}
+
+	name := id.Name.String()
+	if slices.Contains(in.ticked, name) {
+		// `i++` in `for (let i = 0; ...; i++)`
+		return func() {}
+	}
+	read := "[" + name + "]"
+	if in.opts.CaptureWhen != "" {
+		read = fmt.Sprintf("(%s) ? [%s] : undefined", in.opts.CaptureWhen, name)
+	}
+	start := in.offset(e.Idx0())
+	in.open(start, fmt.Sprintf("__assigned(%s, ", strconv.Quote(name)))
+	hook := fmt.Sprintf(", () => %s, %d, %d)", read, in.line(start), in.resolve(name))
+	return func() { in.insert(end, hook) }
+}
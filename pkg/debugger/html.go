@@ -0,0 +1,284 @@
+package debugger
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// htmlReport is what output.html is rendered from with -format=html: the
+// script with its instrumented lines marked, and the report around it.
+type htmlReport struct {
+	Title    string
+	Script   string
+	Status   string
+	Failed   bool
+	Coverage bool
+	Error    *crashReport
+	Source   []htmlLine
+	Hits     []htmlHit
+	Vars     []htmlVariable
+	Loops    []htmlLoop
+	Calls    []string
+	Sections []reportSection
+}
+
+// htmlLine is one line of the script. Marks are the CSS classes for what
+// happened on it, Notes what it captured and Hits how often it ran, under
+// -coverage.
+type htmlLine struct {
+	Number int
+	Text   string
+	Marks  string
+	Notes  string
+	Hits   string
+}
+
+type htmlHit struct {
+	Label string
+	Line  int
+	At    time.Duration
+	Stack []Frame
+}
+
+type htmlVariable struct {
+	Name     string
+	Type     string
+	Line     int
+	Value    string
+	Timeline []htmlEvent
+}
+
+type htmlEvent struct {
+	Seq   int
+	At    time.Duration
+	Line  int
+	Value string
+}
+
+// htmlLoop is a loop with its timeline as a table, a column per tracked
+// variable.
+type htmlLoop struct {
+	Number     int
+	Info       LoopInfo
+	Duration   time.Duration
+	Columns    []string
+	Rows       []htmlStep
+	Conditions bool
+}
+
+type htmlStep struct {
+	Label     string
+	Cells     []string
+	Condition string
+}
+
+// writeDebugInfoHTML renders the report as one page with nothing to load,
+// to hand around instead of output.txt and loops.txt.
+func writeDebugInfoHTML(writer io.Writer, debugInfo map[string]any, label string, state *RunState, opts *Config) {
+	report := htmlReport{Title: label, Script: filepath.Base(opts.Script), Status: "finished", Coverage: opts.Coverage, Error: state.Crash}
+	switch {
+	case state.Crash != nil:
+		report.Status, report.Failed = "failed", true
+	case state.Quit:
+		report.Status = "quit at a prompt"
+	case state.TimedOut || state.Limit != "":
+		report.Status = "stopped: " + state.stopReason(opts)
+	}
+
+	marks := make(map[int][]string)
+	for line := range state.LineCaptures {
+		marks[line] = append(marks[line], "captured")
+	}
+	for _, loop := range state.Loops {
+		if loop.File == "" {
+			marks[loop.Line] = append(marks[loop.Line], "loop")
+		}
+	}
+	for _, hit := range state.Hits {
+		entry := htmlHit{Label: hit.Label, Line: hit.Line, At: hit.Time.Sub(state.Started).Round(time.Microsecond), Stack: hit.Stack}
+		if entry.Line == 0 && len(hit.Stack) > 0 {
+			entry.Line = hit.Stack[0].Line
+		}
+		marks[entry.Line] = append(marks[entry.Line], "hit")
+		report.Hits = append(report.Hits, entry)
+	}
+	if state.Crash != nil {
+		if site, ok := state.Crash.site(); ok {
+			marks[site.Line] = append(marks[site.Line], "error")
+		}
+	}
+	var hits map[int]int
+	if opts.Coverage {
+		hits = state.coverageByFile(opts)[0].Lines
+		for line, n := range hits {
+			if n == 0 {
+				marks[line] = append(marks[line], "uncovered")
+			}
+		}
+	}
+	for i, text := range state.Source {
+		line := htmlLine{Number: i + 1, Text: text, Marks: strings.Join(slices.Compact(marks[i+1]), " ")}
+		line.Notes = strings.Join(captureNotes(state.LineCaptures[i+1], opts.MaxValueLen), ", ")
+		if n, ok := hits[i+1]; ok {
+			line.Hits = fmt.Sprintf("%d×", n)
+		}
+		report.Source = append(report.Source, line)
+	}
+
+	for _, k := range sortedKeys(debugInfo) {
+		v := htmlVariable{Name: k, Type: valueType(debugInfo[k]), Value: inspectValue(debugInfo[k], "", opts)}
+		if d := state.Declarations[k]; d != nil {
+			v.Type, v.Line = d.Type, d.Line
+		}
+		for _, e := range state.Events {
+			if e.Name == k && e.File == "" {
+				v.Timeline = append(v.Timeline, htmlEvent{Seq: e.Seq, At: e.Time.Sub(state.Started).Round(time.Microsecond), Line: e.Line, Value: inspectLine(e.Value, opts)})
+			}
+		}
+		report.Vars = append(report.Vars, v)
+	}
+
+	for i, loop := range state.Loops {
+		entry := htmlLoop{Number: i + 1, Info: loop, Duration: loop.Duration.Round(time.Microsecond), Columns: loop.Tracked}
+		for _, step := range loop.Timeline {
+			row := htmlStep{Label: fmt.Sprintf("#%d", step.Iteration)}
+			if step.Iteration == 0 {
+				row.Label = "exit"
+			}
+			for _, name := range loop.Tracked {
+				cell := ""
+				if value, ok := step.Values[name]; ok {
+					cell = inspectLine(value, opts)
+				}
+				row.Cells = append(row.Cells, cell)
+			}
+			if step.Tested {
+				row.Condition, entry.Conditions = inspectLine(step.Condition, opts), true
+			}
+			entry.Rows = append(entry.Rows, row)
+		}
+		report.Loops = append(report.Loops, entry)
+	}
+
+	for _, e := range state.Calls {
+		report.Calls = append(report.Calls, e.render(opts.Indent, opts.MaxValueLen))
+	}
+
+	// the timeline and the crash trace have their own place on the page
+	for _, section := range reportSections(state, opts) {
+		if section.Title != "VALUE TIMELINE" && section.Title != "CRASH TRACE" {
+			report.Sections = append(report.Sections, section)
+		}
+	}
+
+	if err := htmlReportTemplate.Execute(writer, report); err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not render the HTML report: %v\n", err)
+	}
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Script}}: {{.Title}}</title>
+<style>
+body { font: 14px/1.45 system-ui, sans-serif; margin: 2em auto; max-width: 70em; padding: 0 1em; color: #222; }
+h1 { font-size: 1.5em; margin-bottom: 0; }
+h2 { font-size: 1.15em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+pre, code, td.value, .source { font: 13px/1.4 ui-monospace, Menlo, Consolas, monospace; }
+pre { margin: 0; white-space: pre-wrap; }
+.status { color: #555; }
+.status.failed { color: #b00020; font-weight: bold; }
+.error { background: #fdecee; border-left: 4px solid #b00020; padding: .6em 1em; }
+table { border-collapse: collapse; margin: .5em 0; }
+th, td { border: 1px solid #ddd; padding: .2em .6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+table.source { border: 0; width: 100%; }
+table.source td { border: 0; padding: 0 .6em; white-space: pre; }
+table.source td.number { color: #999; text-align: right; user-select: none; }
+table.source td.notes { color: #0a6; white-space: normal; }
+table.source td.hits { color: #999; text-align: right; }
+.uncovered, .legend .uncovered { background: #f0f0f0; color: #888; }
+.captured { background: #eef7ff; }
+tr.loop td.number, .legend .loop { color: #7a3e9d; font-weight: bold; }
+.hit { background: #fff6d6; }
+tr.error, .legend .error { background: #fdecee; }
+.legend span { padding: 0 .5em; margin-right: .5em; }
+details { margin: .3em 0; }
+summary { cursor: pointer; }
+ul.section { list-style: none; padding-left: 0; }
+ul.section li.nested { padding-left: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Script}}</h1>
+<p class="status{{if .Failed}} failed{{end}}">{{.Title}}: {{.Status}}</p>
+{{with .Error}}
+<div class="error"><pre>{{.Message}}{{range .Frames}}
+    at {{.}}{{end}}</pre></div>
+{{end}}
+
+<h2>Source</h2>
+<p class="legend"><span class="captured">captured</span><span class="hit">breakpoint hit</span><span class="error">error</span><span class="loop">loop</span>{{if .Coverage}}<span class="uncovered">never ran</span>{{end}}</p>
+<table class="source">
+{{range .Source}}<tr id="L{{.Number}}"{{with .Marks}} class="{{.}}"{{end}}><td class="number">{{.Number}}</td>{{if $.Coverage}}<td class="hits">{{.Hits}}</td>{{end}}<td>{{.Text}}</td><td class="notes">{{.Notes}}</td></tr>
+{{end}}</table>
+
+{{with .Hits}}
+<h2>Breakpoints hit</h2>
+<table>
+<tr><th>Breakpoint</th><th>Line</th><th>At</th><th>Call stack</th></tr>
+{{range .}}<tr><td>{{.Label}}</td><td>{{if .Line}}<a href="#L{{.Line}}">{{.Line}}</a>{{end}}</td><td>+{{.At}}</td><td><pre>{{range .Stack}}at {{.}}
+{{end}}</pre></td></tr>
+{{end}}</table>
+{{end}}
+
+{{with .Vars}}
+<h2>Variables</h2>
+<table>
+<tr><th>Variable</th><th>Type</th><th>Declared</th><th>Value</th></tr>
+{{range .}}<tr><td><code>{{.Name}}</code></td><td>{{.Type}}</td><td>{{if .Line}}<a href="#L{{.Line}}">line {{.Line}}</a>{{end}}</td><td class="value"><pre>{{.Value}}</pre>
+{{if gt (len .Timeline) 1}}<details><summary>{{len .Timeline}} values</summary>
+<table>
+<tr><th>#</th><th>At</th><th>Line</th><th>Value</th></tr>
+{{range .Timeline}}<tr><td>{{.Seq}}</td><td>+{{.At}}</td><td><a href="#L{{.Line}}">{{.Line}}</a></td><td class="value">{{.Value}}</td></tr>
+{{end}}</table>
+</details>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{with .Loops}}
+<h2>Loops</h2>
+{{range $loop := .}}
+<h3>Loop {{.Number}}: <code>{{.Info.Type}}</code>, {{with .Info.File}}{{.}} lines {{$loop.Info.Line}}–{{$loop.Info.EndLine}}{{else}}<a href="#L{{.Info.Line}}">lines {{.Info.Line}}–{{.Info.EndLine}}</a>{{end}}</h3>
+<p>{{if .Info.Outer}}Nested in loop {{.Info.Outer}}. {{end}}{{.Info.Iterations}} iteration(s) in {{.Duration}}.</p>
+{{if .Rows}}<details open><summary>Iterations</summary>
+<table>
+<tr><th>Iteration</th>{{range .Columns}}<th><code>{{.}}</code></th>{{end}}{{if .Conditions}}<th>Condition</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{.Label}}</td>{{range .Cells}}<td class="value">{{.}}</td>{{end}}{{if $loop.Conditions}}<td class="value">{{.Condition}}</td>{{end}}</tr>
+{{end}}</table>
+{{if .Info.Dropped}}<p>{{.Info.Dropped}} more step(s) not recorded, raise -max-iterations to see them.</p>{{end}}
+</details>{{end}}
+{{end}}
+{{end}}
+
+{{with .Calls}}
+<h2>Call trace</h2>
+<pre>{{range .}}{{.}}
+{{end}}</pre>
+{{end}}
+
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+<ul class="section">
+{{range .Lines}}<li{{if .Nested}} class="nested"{{end}}><code>{{.Text}}</code></li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,600 @@
+package debugger
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+// inspector serves a subset of the Chrome DevTools Protocol on -inspect,
+// for Chrome DevTools (chrome://inspect) and other CDP clients: the
+// script's source, line breakpoints, stepping, the paused call stack with
+// its scopes, and evaluation. It serves one client at a time.
+//
+// The client's requests arrive on its connection's goroutine. Those that
+// need the runtime run on the loop: while paused, handed to pause through
+// commands, otherwise with RunOnLoop.
+type inspector struct {
+	vm     *goja.Runtime
+	loop   *eventloop.EventLoop
+	state  *RunState
+	opts   *Config
+	source string
+
+	id, url  string
+	server   *http.Server
+	listener net.Listener
+	ready    chan struct{}
+	start    sync.Once
+
+	mu          sync.Mutex
+	client      *wsConn
+	breakpoints map[string]inspectorBreakpoint
+	active      bool
+	next        string // the reason to pause at the next statement, if any
+	step        stepKind
+	stepDepth   int
+	paused      bool
+	commands    chan cdpMessage
+
+	// on the loop only: the frame paused in and the objects handed out
+	frame   *pausedFrame
+	objects map[string]*goja.Object
+}
+
+type inspectorBreakpoint struct {
+	line      int
+	condition string
+}
+
+type stepKind int
+
+const (
+	stepNone stepKind = iota
+	stepInto
+	stepOver
+	stepOut
+)
+
+type pausedFrame struct {
+	stack    []Frame
+	scope    int
+	evaluate func(string) (goja.Value, error)
+}
+
+// cdpMessage is a request from the client.
+type cdpMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// startInspector listens on opts.Inspect, a port or host:port, and serves
+// the script there. The HTTP endpoints under /json are how Chrome finds
+// it; the WebSocket is at /<id>.
+func startInspector(vm *goja.Runtime, loop *eventloop.EventLoop, source string, state *RunState, opts *Config) (*inspector, error) {
+	addr := opts.Inspect
+	if !strings.Contains(addr, ":") {
+		addr = "127.0.0.1:" + addr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [16]byte
+	rand.Read(id[:])
+	path, err := filepath.Abs(opts.Script)
+	if err != nil {
+		path = opts.Script
+	}
+	ins := &inspector{
+		vm: vm, loop: loop, state: state, opts: opts, source: source,
+		id:          fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		url:         "file://" + filepath.ToSlash(path),
+		listener:    listener,
+		ready:       make(chan struct{}),
+		breakpoints: make(map[string]inspectorBreakpoint),
+		active:      true,
+		next:        "Break on start",
+		commands:    make(chan cdpMessage, 64),
+		objects:     make(map[string]*goja.Object),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"Browser": "debug-smpl/" + Version, "Protocol-Version": "1.3"})
+	})
+	list := func(w http.ResponseWriter, r *http.Request) {
+		host := listener.Addr().String()
+		writeJSON(w, []map[string]string{{
+			"description":          "debug-smpl instance",
+			"devtoolsFrontendUrl":  "devtools://devtools/bundled/js_app.html?experiments=true&v8only=true&ws=" + host + "/" + ins.id,
+			"id":                   ins.id,
+			"title":                opts.Script,
+			"type":                 "node",
+			"url":                  ins.url,
+			"webSocketDebuggerUrl": "ws://" + host + "/" + ins.id,
+		}})
+	}
+	mux.HandleFunc("/json", list)
+	mux.HandleFunc("/json/list", list)
+	mux.HandleFunc("/"+ins.id, ins.serve)
+	ins.server = &http.Server{Handler: mux}
+	go ins.server.Serve(listener)
+	return ins, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// wait blocks until a client asked the script to run, as Chrome does
+// right after attaching.
+func (ins *inspector) wait() {
+	if ins == nil {
+		return
+	}
+	fmt.Fprintf(ins.opts.Stdout, "|~| Inspector listening on ws://%s/%s\n", ins.listener.Addr(), ins.id)
+	fmt.Fprintln(ins.opts.Stdout, "|~| Waiting for a debugger to attach (chrome://inspect, or any CDP client)...")
+	<-ins.ready
+}
+
+// close tells the client the script is done and stops serving.
+func (ins *inspector) close() {
+	if ins == nil {
+		return
+	}
+	ins.event("Runtime.executionContextDestroyed", map[string]any{"executionContextId": 1})
+	ins.mu.Lock()
+	client := ins.client
+	ins.mu.Unlock()
+	if client != nil {
+		client.Close()
+	}
+	ins.server.Close()
+}
+
+// serve is the WebSocket of a client, until it disconnects, which
+// resumes the script if it was paused.
+func (ins *inspector) serve(w http.ResponseWriter, r *http.Request) {
+	ins.mu.Lock()
+	busy := ins.client != nil
+	ins.mu.Unlock()
+	if busy {
+		http.Error(w, "another debugger is attached", http.StatusConflict)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	ins.mu.Lock()
+	ins.client = conn
+	ins.mu.Unlock()
+
+	for {
+		data, err := conn.read()
+		if err != nil {
+			break
+		}
+		var m cdpMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		ins.receive(m)
+	}
+
+	conn.Close()
+	ins.mu.Lock()
+	ins.client = nil
+	paused := ins.paused
+	ins.mu.Unlock()
+	// sent unlocked: pause takes the mutex while it handles what it reads
+	if paused {
+		ins.commands <- cdpMessage{Method: "Debugger.resume"}
+	}
+	// a client leaving before it started the script doesn't keep it waiting
+	ins.start.Do(func() { close(ins.ready) })
+}
+
+// receive handles a request on the client's goroutine.
+func (ins *inspector) receive(m cdpMessage) {
+	switch m.Method {
+	case "Runtime.enable":
+		ins.reply(m.ID, struct{}{})
+		ins.event("Runtime.executionContextCreated", map[string]any{
+			"context": map[string]any{"id": 1, "origin": "", "name": "debug-smpl"},
+		})
+	case "Debugger.enable":
+		ins.reply(m.ID, map[string]any{"debuggerId": ins.id})
+		sum := sha1.Sum([]byte(ins.source))
+		lines := strings.Split(ins.source, "\n")
+		ins.event("Debugger.scriptParsed", map[string]any{
+			"scriptId": "1", "url": ins.url, "executionContextId": 1, "hash": hex.EncodeToString(sum[:]),
+			"startLine": 0, "startColumn": 0, "endLine": len(lines) - 1, "endColumn": len(lines[len(lines)-1]),
+			"length": len(ins.source), "sourceMapURL": "", "isModule": false,
+		})
+	case "Debugger.getScriptSource":
+		ins.reply(m.ID, map[string]any{"scriptSource": ins.source})
+	case "Debugger.setBreakpointByUrl":
+		ins.setBreakpoint(m)
+	case "Debugger.removeBreakpoint":
+		var p struct {
+			BreakpointID string `json:"breakpointId"`
+		}
+		json.Unmarshal(m.Params, &p)
+		ins.mu.Lock()
+		delete(ins.breakpoints, p.BreakpointID)
+		ins.mu.Unlock()
+		ins.reply(m.ID, struct{}{})
+	case "Debugger.setBreakpointsActive":
+		var p struct {
+			Active bool `json:"active"`
+		}
+		json.Unmarshal(m.Params, &p)
+		ins.mu.Lock()
+		ins.active = p.Active
+		ins.mu.Unlock()
+		ins.reply(m.ID, struct{}{})
+	case "Debugger.getPossibleBreakpoints":
+		ins.possibleBreakpoints(m)
+	case "Debugger.pause":
+		ins.mu.Lock()
+		ins.next = "other"
+		ins.mu.Unlock()
+		ins.reply(m.ID, struct{}{})
+	case "Runtime.runIfWaitingForDebugger":
+		ins.start.Do(func() { close(ins.ready) })
+		ins.reply(m.ID, struct{}{})
+	case "Debugger.resume", "Debugger.stepInto", "Debugger.stepOver", "Debugger.stepOut",
+		"Runtime.evaluate", "Debugger.evaluateOnCallFrame", "Runtime.getProperties", "Runtime.releaseObjectGroup":
+		ins.mu.Lock()
+		paused := ins.paused
+		ins.mu.Unlock()
+		if paused {
+			ins.commands <- m
+			return
+		}
+		if strings.HasPrefix(m.Method, "Debugger.") && m.Method != "Debugger.evaluateOnCallFrame" {
+			// nothing to resume from
+			ins.reply(m.ID, struct{}{})
+			return
+		}
+		ins.loop.RunOnLoop(func(*goja.Runtime) { ins.command(m) })
+	case "Debugger.disable", "Runtime.disable", "Debugger.setPauseOnExceptions", "Debugger.setAsyncCallStackDepth",
+		"Debugger.setBlackboxPatterns", "Runtime.setAsyncCallStackDepth", "Profiler.enable", "Profiler.disable",
+		"HeapProfiler.enable", "Runtime.getIsolateId", "Runtime.discardConsoleEntries", "Log.enable":
+		ins.reply(m.ID, struct{}{})
+	default:
+		ins.send(map[string]any{"id": m.ID, "error": map[string]any{"code": -32601, "message": fmt.Sprintf("'%s' wasn't found", m.Method)}})
+	}
+}
+
+// setBreakpoint adds a breakpoint on a line of the script. Lines are
+// 0-based in CDP, columns are ignored: it pauses at the line's first
+// statement.
+func (ins *inspector) setBreakpoint(m cdpMessage) {
+	var p struct {
+		LineNumber int    `json:"lineNumber"`
+		URL        string `json:"url"`
+		URLRegex   string `json:"urlRegex"`
+		Condition  string `json:"condition"`
+	}
+	json.Unmarshal(m.Params, &p)
+	id := fmt.Sprintf("1:%d:0:%s%s", p.LineNumber, p.URL, p.URLRegex)
+
+	matches := p.URL == "" && p.URLRegex == "" || p.URL == ins.url || p.URL == ins.opts.Script
+	if re, err := regexp.Compile(p.URLRegex); p.URLRegex != "" && err == nil {
+		matches = re.MatchString(ins.url)
+	}
+	locations := []map[string]any{}
+	if matches {
+		ins.mu.Lock()
+		ins.breakpoints[id] = inspectorBreakpoint{line: p.LineNumber + 1, condition: strings.TrimSpace(p.Condition)}
+		ins.mu.Unlock()
+		locations = append(locations, ins.location(p.LineNumber+1, 1))
+	}
+	ins.reply(m.ID, map[string]any{"breakpointId": id, "locations": locations})
+}
+
+// possibleBreakpoints offers the start of each non-blank line in range.
+func (ins *inspector) possibleBreakpoints(m cdpMessage) {
+	var p struct {
+		Start struct {
+			LineNumber int `json:"lineNumber"`
+		} `json:"start"`
+		End *struct {
+			LineNumber int `json:"lineNumber"`
+		} `json:"end"`
+	}
+	json.Unmarshal(m.Params, &p)
+	lines := strings.Split(ins.source, "\n")
+	last := len(lines) - 1
+	if p.End != nil {
+		last = min(p.End.LineNumber, last)
+	}
+	locations := []map[string]any{}
+	for n := max(p.Start.LineNumber, 0); n <= last; n++ {
+		if text := strings.TrimSpace(lines[n]); text != "" {
+			locations = append(locations, ins.location(n+1, len(lines[n])-len(strings.TrimLeft(lines[n], " \t"))+1))
+		}
+	}
+	ins.reply(m.ID, map[string]any{"locations": locations})
+}
+
+// statement is called before every statement; it pauses there for a
+// breakpoint of the client, a pause it asked for or a step.
+func (ins *inspector) statement(line, scope int, evaluate func(string) (goja.Value, error)) {
+	depth := ins.state.depth
+	ins.mu.Lock()
+	reason := ins.next
+	switch {
+	case ins.step == stepInto,
+		ins.step == stepOver && depth <= ins.stepDepth,
+		ins.step == stepOut && depth < ins.stepDepth:
+		reason = "other"
+	}
+	var hits []string
+	conditions := make(map[string]string)
+	if ins.active {
+		for id, b := range ins.breakpoints {
+			if b.line == line {
+				conditions[id] = b.condition
+			}
+		}
+	}
+	ins.mu.Unlock()
+
+	for _, id := range sortedKeys(conditions) {
+		if condition := conditions[id]; condition != "" {
+			if result, err := evaluate(condition); err != nil || !result.ToBoolean() {
+				continue
+			}
+		}
+		hits = append(hits, id)
+	}
+	if len(hits) > 0 && reason == "" {
+		reason = "other"
+	}
+	if reason != "" {
+		ins.pause(reason, hits, callStack(ins.vm, ins.state, ins.opts), scope, evaluate)
+	}
+}
+
+// pause stops the script until the client resumes or steps, answering
+// its requests meanwhile. Without a client it doesn't pause.
+func (ins *inspector) pause(reason string, hits []string, stack []Frame, scope int, evaluate func(string) (goja.Value, error)) {
+	ins.mu.Lock()
+	if ins.client == nil {
+		ins.mu.Unlock()
+		return
+	}
+	ins.paused, ins.next, ins.step = true, "", stepNone
+	ins.mu.Unlock()
+	defer ins.state.hold()()
+
+	ins.frame = &pausedFrame{stack: stack, scope: scope, evaluate: evaluate}
+	clear(ins.objects)
+	if hits == nil {
+		hits = []string{}
+	}
+	ins.event("Debugger.paused", map[string]any{"callFrames": ins.callFrames(), "reason": reason, "hitBreakpoints": hits})
+	for m := range ins.commands {
+		if !ins.command(m) {
+			break
+		}
+	}
+	ins.frame = nil
+	ins.event("Debugger.resumed", struct{}{})
+}
+
+// command runs a request that needs the runtime, on the loop. It returns
+// false when the request resumes the script.
+func (ins *inspector) command(m cdpMessage) bool {
+	var p struct {
+		Expression string `json:"expression"`
+		ObjectID   string `json:"objectId"`
+	}
+	json.Unmarshal(m.Params, &p)
+
+	switch m.Method {
+	case "Debugger.resume", "Debugger.stepInto", "Debugger.stepOver", "Debugger.stepOut":
+		ins.mu.Lock()
+		ins.paused = false
+		ins.step = map[string]stepKind{"Debugger.stepInto": stepInto, "Debugger.stepOver": stepOver, "Debugger.stepOut": stepOut}[m.Method]
+		ins.stepDepth = ins.state.depth
+		ins.mu.Unlock()
+		ins.reply(m.ID, struct{}{})
+		return false
+	case "Runtime.evaluate", "Debugger.evaluateOnCallFrame":
+		evaluate := ins.vm.RunString
+		if m.Method == "Debugger.evaluateOnCallFrame" && ins.frame != nil {
+			evaluate = ins.frame.evaluate
+		}
+		value, err := evaluate(p.Expression)
+		if err == nil {
+			ins.reply(m.ID, map[string]any{"result": ins.remote(value)})
+			break
+		}
+		thrown := ins.vm.ToValue(err.Error())
+		var exception *goja.Exception
+		if errors.As(err, &exception) {
+			thrown = exception.Value()
+		}
+		ins.reply(m.ID, map[string]any{
+			"result":           ins.remote(thrown),
+			"exceptionDetails": map[string]any{"exceptionId": 1, "text": "Uncaught", "lineNumber": 0, "columnNumber": 0, "exception": ins.remote(thrown)},
+		})
+	case "Runtime.getProperties":
+		ins.reply(m.ID, map[string]any{"result": ins.properties(p.ObjectID)})
+	default:
+		ins.reply(m.ID, struct{}{})
+	}
+	return true
+}
+
+// callFrames are the paused stack's frames in the script, innermost
+// first; only that one has scopes.
+func (ins *inspector) callFrames() []map[string]any {
+	frames := []map[string]any{}
+	for i, f := range ins.frame.stack {
+		if f.File != ins.opts.Script {
+			continue
+		}
+		name := f.Func
+		if name == "<anonymous>" {
+			name = ""
+		}
+		frame := map[string]any{
+			"callFrameId":  strconv.Itoa(i),
+			"functionName": name,
+			"location":     ins.location(f.Line, f.Column),
+			"url":          ins.url,
+			"scopeChain":   []any{},
+			"this":         map[string]any{"type": "undefined"},
+		}
+		if len(frames) == 0 {
+			frame["scopeChain"] = ins.scopeChain()
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// scopeChain lists the scopes visible where the script paused, innermost
+// first, as objects whose properties are their variables.
+func (ins *inspector) scopeChain() []any {
+	chain := []any{}
+	for id, depth := ins.frame.scope, 0; id >= 0 && id < len(ins.state.Scopes); id, depth = ins.state.Scopes[id].Parent, depth+1 {
+		sc := &ins.state.Scopes[id]
+		kind := "closure"
+		switch {
+		case sc.Parent < 0:
+			kind = "global"
+		case depth == 0:
+			kind = "local"
+		}
+		chain = append(chain, map[string]any{
+			"type": kind,
+			"name": sc.title(),
+			"object": map[string]any{
+				"type": "object", "className": "Object", "description": sc.title(),
+				"objectId": fmt.Sprintf("scope:%d", id),
+			},
+		})
+	}
+	return chain
+}
+
+// properties are the own enumerable properties of an object handed out,
+// or the variables of a scope, read live from where the script paused.
+func (ins *inspector) properties(objectID string) []any {
+	props := []any{}
+	add := func(name string, value goja.Value) {
+		props = append(props, map[string]any{
+			"name": name, "value": ins.remote(value),
+			"writable": true, "configurable": true, "enumerable": true, "isOwn": true,
+		})
+	}
+	if rest, ok := strings.CutPrefix(objectID, "scope:"); ok {
+		id, _ := strconv.Atoi(rest)
+		if ins.frame == nil || id < 0 || id >= len(ins.state.Scopes) {
+			return props
+		}
+		sc := &ins.state.Scopes[id]
+		for _, name := range sc.names {
+			value, err := ins.frame.evaluate(name)
+			if err != nil {
+				value = ins.vm.ToValue(sc.Values[name])
+			}
+			add(name, value)
+		}
+		return props
+	}
+	if obj := ins.objects[objectID]; obj != nil {
+		for _, key := range obj.Keys() {
+			add(key, obj.Get(key))
+		}
+	}
+	return props
+}
+
+// remote is v as a CDP RemoteObject. Objects get an id the client can
+// ask the properties of while the script stays paused.
+func (ins *inspector) remote(v goja.Value) map[string]any {
+	kind := jsType(v)
+	switch kind {
+	case "undefined":
+		return map[string]any{"type": "undefined"}
+	case "null":
+		return map[string]any{"type": "object", "subtype": "null", "value": nil}
+	}
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		r := map[string]any{"type": kind, "description": v.String()}
+		if f, isFloat := v.Export().(float64); isFloat && (math.IsNaN(f) || math.IsInf(f, 0) || f == 0 && math.Signbit(f)) {
+			r["unserializableValue"] = v.String()
+		} else if kind == "bigint" {
+			r["unserializableValue"] = v.String() + "n"
+		} else if kind != "symbol" {
+			r["value"] = v.Export()
+		}
+		return r
+	}
+
+	id := fmt.Sprintf("obj:%d", len(ins.objects)+1)
+	ins.objects[id] = obj
+	r := map[string]any{"type": "object", "className": obj.ClassName(), "description": obj.ClassName(), "objectId": id}
+	switch kind {
+	case "function":
+		r["type"], r["description"] = "function", v.String()
+	case "array":
+		r["subtype"], r["description"] = "array", fmt.Sprintf("Array(%d)", obj.Get("length").ToInteger())
+	default:
+		if obj.ClassName() == "Error" {
+			r["subtype"], r["description"] = "error", v.String()
+		}
+	}
+	return r
+}
+
+// location is a 1-based script line and column as a CDP location.
+func (ins *inspector) location(line, column int) map[string]any {
+	return map[string]any{"scriptId": "1", "lineNumber": line - 1, "columnNumber": max(column-1, 0)}
+}
+
+func (ins *inspector) reply(id int, result any) {
+	ins.send(map[string]any{"id": id, "result": result})
+}
+
+func (ins *inspector) event(method string, params any) {
+	ins.send(map[string]any{"method": method, "params": params})
+}
+
+func (ins *inspector) send(message any) {
+	ins.mu.Lock()
+	client := ins.client
+	ins.mu.Unlock()
+	if client == nil {
+		return
+	}
+	if data, err := json.Marshal(message); err == nil {
+		client.write(wsText, data)
+	}
+}
@@ -0,0 +1,742 @@
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+)
+
+// instrumenter walks the AST of a script and collects the hooks to splice
+// into its source. Code is only ever inserted, never removed or moved, and
+// never contains a newline, so the instrumented script keeps the original
+// line numbers.
+type instrumenter struct {
+	src    string
+	base   int
+	starts []int // offsets the lines of src start at
+	opts   *Config
+
+	// Offsets into src. Hooks at the same offset go in the order ins,
+	// closes (inner first) and then opens, so that a capture stays inside
+	// the block being closed after it and a block closed before a statement
+	// isn't opened by it.
+	ins, closes, opens []insertion
+
+	loops    []LoopInfo
+	warnings []Warning
+	coverage []CoveredStatement
+
+	// firstLoop is the first of loops that is this source's, the ones
+	// before it being a module's placeholders for the script's loops.
+	firstLoop int
+
+	// header holds the variables each loop's header declares, assigned
+	// the ones its body assigns to, and snapshots the hooks that record
+	// its timeline, filled in by finishLoops.
+	header, assigned map[int][]string
+	snapshots        []loopSnapshot
+
+	// scopes is the scope tree; scope is the one being walked and fnScope
+	// the function (or global) scope around it, where var declares.
+	scopes         []Scope
+	scope, fnScope int
+
+	// paused are the -break and -logpoint lines that got their hooks,
+	// stepped the statements (by offset) that got their -step and
+	// -coverage hooks.
+	paused, stepped map[int]bool
+
+	// label is where the labels in front of the statement being walked
+	// start, -1 if it has none.
+	label int
+
+	// declared holds the names seen declared in each scope so far, which
+	// assignments are resolved against.
+	declared map[int]map[string]bool
+
+	// ticked are the header variables of the for loop whose update is
+	// being walked: its tick records them each iteration already.
+	ticked []string
+
+	// evaluator is the scopeEvaluator of the function being walked, or
+	// globalEvaluator in and under one with non-simple parameters.
+	evaluator string
+}
+
+func newInstrumenter(program *ast.Program, src string, opts *Config) *instrumenter {
+	in := &instrumenter{src: src, base: program.File.Base(), starts: []int{0}, opts: opts, label: -1, evaluator: scopeEvaluator, scopes: []Scope{{Name: "global", Parent: -1}},
+		header: make(map[int][]string), assigned: make(map[int][]string), paused: make(map[int]bool), stepped: make(map[int]bool),
+		declared: make(map[int]map[string]bool)}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			in.starts = append(in.starts, i+1)
+		}
+	}
+	return in
+}
+
+func (in *instrumenter) offset(idx file.Idx) int {
+	return int(idx) - in.base
+}
+
+// line returns the 1-based line an offset falls on.
+func (in *instrumenter) line(offset int) int {
+	return sort.Search(len(in.starts), func(i int) bool { return in.starts[i] > offset })
+}
+
+func (in *instrumenter) insert(offset int, text string) {
+	in.ins = append(in.ins, insertion{pos: offset, text: text})
+}
+
+// open inserts code that opens a block around a statement starting at offset.
+func (in *instrumenter) open(offset int, text string) {
+	in.opens = append(in.opens, insertion{pos: offset, text: text})
+}
+
+// close inserts code that closes a block, at the end of what it wraps.
+func (in *instrumenter) close(offset int, text string) {
+	in.closes = append(in.closes, insertion{pos: offset, text: text})
+}
+
+// start returns the offset node starts at. The parser drops the brackets
+// around a parenthesized expression, so they are looked for in the source.
+func (in *instrumenter) start(node ast.Node) int {
+	if s, ok := node.(*ast.IfStatement); ok && s.If == 0 {
+		// the parser never sets If; the keyword is right before `(test`
+		return strings.LastIndex(in.src[:in.start(s.Test)], "if")
+	}
+	pos := in.offset(node.Idx0())
+	for i := pos - 1; i >= 0; i-- {
+		if in.src[i] == '(' {
+			pos = i
+		} else if !isSpace(in.src[i]) {
+			break
+		}
+	}
+	return pos
+}
+
+// idx1 returns the offset just past node. goja's Idx1 is the one of
+// the node's last part, and two of those are short: `new C()` ends at C,
+// without its empty argument list, and `this.#p` one short, without the
+// `#`.
+func (in *instrumenter) idx1(node ast.Node) int {
+	last := node
+	for next := trailing(last); next != nil; next = trailing(last) {
+		last = next
+	}
+	switch n := last.(type) {
+	case *ast.NewExpression:
+		return in.offset(n.RightParenthesis) + 1
+	case *ast.PrivateDotExpression:
+		return in.offset(n.Identifier.Idx1()) + 1
+	}
+	return in.offset(node.Idx1())
+}
+
+// trailing returns the part of node whose Idx1 is node's, nil for a node
+// that knows its own end.
+func trailing(node ast.Node) ast.Node {
+	switch n := node.(type) {
+	case *ast.NewExpression:
+		if n.ArgumentList == nil && n.RightParenthesis == 0 {
+			// `new C`
+			return n.Callee
+		}
+	case *ast.AssignExpression:
+		return n.Right
+	case *ast.BinaryExpression:
+		return n.Right
+	case *ast.ConditionalExpression:
+		return n.Alternate
+	case *ast.SequenceExpression:
+		return n.Sequence[len(n.Sequence)-1]
+	case *ast.UnaryExpression:
+		if !n.Postfix {
+			return n.Operand
+		}
+	case *ast.AwaitExpression:
+		return n.Argument
+	case *ast.YieldExpression:
+		if n.Argument != nil {
+			return n.Argument
+		}
+	case *ast.ArrowFunctionLiteral:
+		return n.Body
+	case *ast.ExpressionBody:
+		return n.Expression
+	case *ast.ExpressionStatement:
+		return n.Expression
+	case *ast.ReturnStatement:
+		if n.Argument != nil {
+			return n.Argument
+		}
+	case *ast.ThrowStatement:
+		return n.Argument
+	case *ast.VariableStatement:
+		return n.List[len(n.List)-1]
+	case *ast.LexicalDeclaration:
+		return n.List[len(n.List)-1]
+	case *ast.Binding:
+		if n.Initializer != nil {
+			return n.Initializer
+		}
+		return n.Target
+	case *ast.LabelledStatement:
+		return n.Statement
+	case *ast.IfStatement:
+		if n.Alternate != nil {
+			return n.Alternate
+		}
+		return n.Consequent
+	case *ast.ForStatement:
+		return n.Body
+	case *ast.ForInStatement:
+		return n.Body
+	case *ast.ForOfStatement:
+		return n.Body
+	case *ast.WhileStatement:
+		return n.Body
+	case *ast.WithStatement:
+		return n.Body
+	}
+	return nil
+}
+
+// end returns the offset just past node, including any closing brackets
+// the parser left out.
+func (in *instrumenter) end(node ast.Node) int {
+	pos := in.idx1(node)
+	for i := pos; i < len(in.src); i++ {
+		if in.src[i] == ')' {
+			pos = i + 1
+		} else if !isSpace(in.src[i]) {
+			break
+		}
+	}
+	return pos
+}
+
+// balancedEnd is end for an operand, which shares the brackets around it
+// with what it is part of: only as many closing brackets are taken as
+// open right in front of node, so `x = (a + b)` ends after `)` but the
+// `)` of `if (x = f())` is left to the if.
+func (in *instrumenter) balancedEnd(node ast.Node) int {
+	opens := 0
+	for i := in.offset(node.Idx0()) - 1; i >= 0; i-- {
+		if in.src[i] == '(' {
+			opens++
+		} else if !isSpace(in.src[i]) {
+			break
+		}
+	}
+	pos := in.idx1(node)
+	for i := pos; i < len(in.src) && opens > 0; i++ {
+		if in.src[i] == ')' {
+			pos, opens = i+1, opens-1
+		} else if !isSpace(in.src[i]) {
+			break
+		}
+	}
+	return pos
+}
+
+// statementEnd is end, but also past the semicolon terminating stmt.
+func (in *instrumenter) statementEnd(stmt ast.Statement) int {
+	pos := in.end(stmt)
+	for i := pos; i < len(in.src) && in.src[i] != '\n'; i++ {
+		if in.src[i] == ';' {
+			return i + 1
+		}
+		if !isSpace(in.src[i]) {
+			break
+		}
+	}
+	return pos
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
+
+// apply splices the collected hooks into the source, recording in columns
+// where they went on each line.
+func (in *instrumenter) apply(columns sourceMap) string {
+	all := in.ins
+	for i := len(in.closes) - 1; i >= 0; i-- {
+		all = append(all, in.closes[i])
+	}
+	all = append(all, in.opens...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].pos < all[j].pos })
+
+	perLine := make(map[int][]insertion)
+	for _, ins := range all {
+		line := in.line(ins.pos)
+		perLine[line] = append(perLine[line], insertion{pos: ins.pos - in.starts[line-1], text: ins.text})
+	}
+
+	var out strings.Builder
+	for i, line := range strings.Split(in.src, "\n") {
+		out.WriteString(applyInsertions(line, perLine[i+1]))
+		out.WriteString("\n")
+		columns.record(i+1, perLine[i+1])
+	}
+	return out.String()
+}
+
+// statements walks a statement list. Declarations in it are followed by a
+// capture of every variable they bind, attributed to loop, the innermost
+// loop around the list, when that isn't -1.
+func (in *instrumenter) statements(list []ast.Statement, loop int) {
+	in.unreachable(list)
+	for _, stmt := range list {
+		in.pause(stmt, true)
+		if _, ok := stmt.(*ast.DebuggerStatement); ok {
+			in.open(in.start(stmt), in.debuggerHook()+"; ")
+			continue
+		}
+		in.statement(stmt, loop)
+
+		line := in.line(in.offset(stmt.Idx0()))
+		var names []string
+		scope := in.scope
+		switch s := stmt.(type) {
+		case *ast.VariableStatement:
+			names, scope = bindingNames(s.List), in.fnScope
+		case *ast.LexicalDeclaration:
+			names = bindingNames(s.List)
+		case *ast.ExpressionStatement:
+			if in.opts.TrackElements {
+				for _, t := range in.elementTargets(s.Expression) {
+					in.insert(in.end(stmt), t.hook(line))
+				}
+			}
+		}
+
+		in.declare(scope, names...)
+		for _, name := range names {
+			in.track(loop, line, scope, name)
+			in.insert(in.end(stmt), captureStatement(name, line, scope, in.opts))
+		}
+	}
+}
+
+// track attributes the capture of name (in scope) on line to loop, if any.
+func (in *instrumenter) track(loop, line, scope int, name string) {
+	if loop < 0 {
+		return
+	}
+	l := &in.loops[loop]
+	l.Variables = append(l.Variables, name)
+	l.scopes = append(l.scopes, scope)
+	if l.captures == nil {
+		l.captures = make(map[loopCapture]bool)
+	}
+	l.captures[loopCapture{line, name}] = true
+}
+
+func (in *instrumenter) statement(stmt ast.Statement, loop int) {
+	if in.label < 0 {
+		in.pause(stmt, false)
+	}
+
+	// a labelled loop is timed from its label, which has to stay on the loop
+	from := in.start(stmt)
+	if in.label >= 0 {
+		from, in.label = in.label, -1
+	}
+
+	switch s := stmt.(type) {
+	case *ast.BlockStatement:
+		defer in.blockScope("block", in.line(from), s.List)()
+		in.statements(s.List, loop)
+	case *ast.ExpressionStatement:
+		in.expression(s.Expression, loop)
+	case *ast.VariableStatement:
+		in.bindings(s.List, loop)
+	case *ast.LexicalDeclaration:
+		in.bindings(s.List, loop)
+	case *ast.FunctionDeclaration:
+		in.function(s.Function, "anonymous", loop)
+	case *ast.ClassDeclaration:
+		in.class(s.Class, loop)
+	case *ast.IfStatement:
+		in.expression(s.Test, loop)
+		in.statement(s.Consequent, loop)
+		if s.Alternate != nil {
+			in.statement(s.Alternate, loop)
+		}
+	case *ast.ForStatement:
+		scope := in.fnScope
+		switch init := s.Initializer.(type) {
+		case *ast.ForLoopInitializerExpression:
+			in.expression(init.Expression, loop)
+		case *ast.ForLoopInitializerVarDeclList:
+			in.bindings(init.List, loop)
+		case *ast.ForLoopInitializerLexicalDecl:
+			defer in.enterScope("for loop", in.line(from), false)()
+			scope = in.scope
+			in.bindings(init.LexicalDeclaration.List, loop)
+		}
+		id := in.loop("for", stmt, from, s.Body, loop, headerNames(s.Initializer), scope)
+		in.expression(s.Test, id)
+		if id != loop {
+			in.ticked = headerNames(s.Initializer)
+		}
+		in.expression(s.Update, id)
+		in.ticked = nil
+		in.statement(s.Body, id)
+	case *ast.ForInStatement:
+		in.expression(s.Source, loop)
+		scope, end := in.intoScope(s.Into, from)
+		defer end()
+		in.statement(s.Body, in.loop("for", stmt, from, s.Body, loop, intoNames(s.Into), scope))
+	case *ast.ForOfStatement:
+		in.expression(s.Source, loop)
+		scope, end := in.intoScope(s.Into, from)
+		defer end()
+		in.statement(s.Body, in.loop("for", stmt, from, s.Body, loop, intoNames(s.Into), scope))
+	case *ast.WhileStatement:
+		id := in.loop("while", stmt, from, s.Body, loop, nil, in.scope)
+		in.expression(s.Test, id)
+		in.statement(s.Body, id)
+	case *ast.DoWhileStatement:
+		id := in.loop("do-while", stmt, from, s.Body, loop, nil, in.scope)
+		in.statement(s.Body, id)
+		in.expression(s.Test, id)
+	case *ast.LabelledStatement:
+		in.label = from
+		in.statement(s.Statement, loop)
+	case *ast.ReturnStatement:
+		in.traceReturnStatement(s)
+		in.expression(s.Argument, loop)
+	case *ast.ThrowStatement:
+		in.throw(s)
+		in.expression(s.Argument, loop)
+	case *ast.SwitchStatement:
+		in.expression(s.Discriminant, loop)
+		for _, c := range s.Body {
+			in.expression(c.Test, loop)
+			in.statements(c.Consequent, loop)
+		}
+	case *ast.TryStatement:
+		in.statement(s.Body, loop)
+		if s.Catch != nil {
+			in.statement(s.Catch.Body, loop)
+		}
+		if s.Finally != nil {
+			in.statement(s.Finally, loop)
+		}
+	case *ast.WithStatement:
+		in.expression(s.Object, loop)
+		in.statement(s.Body, loop)
+	case *ast.DebuggerStatement:
+		// a body of its own, as in `if (x) debugger;`
+		in.open(in.start(s), "{ "+in.debuggerHook()+"; ")
+		in.close(in.statementEnd(s), " }")
+	}
+}
+
+// loop registers stmt, a loop of kind starting at from, unless -loop-types
+// leaves it out. The loop is timed and ticked at the top of body, where the
+// variables its header declares are captured too: the header itself runs
+// before the first binding exists (`i`) or has nowhere to put a statement
+// (`x` in `for (const x of xs)`); they belong to scope. It returns the
+// loop the body belongs to.
+func (in *instrumenter) loop(kind string, stmt ast.Statement, from int, body ast.Statement, outer int, header []string, scope int) int {
+	if !in.opts.analyzesLoop(kind) {
+		return outer
+	}
+	fmt.Fprintf(in.opts.progress(), "|+| Detected %s loop \n", kind)
+	id := len(in.loops)
+	in.loops = append(in.loops, LoopInfo{Type: kind, Line: in.line(from), EndLine: in.line(in.statementEnd(stmt) - 1), Variables: []string{}, Outer: outer + 1})
+
+	in.open(from, fmt.Sprintf("try { __loop_start(%d); ", id))
+	in.close(in.statementEnd(stmt), fmt.Sprintf(" } finally { __loop_end(%d); }", id))
+
+	line := in.line(in.offset(stmt.Idx0()))
+	in.header[id] = header
+	in.declare(scope, header...)
+	var captures strings.Builder
+	for _, name := range header {
+		in.track(id, line, scope, name)
+		captures.WriteString(captureStatement(name, line, scope, in.opts))
+	}
+	// %s is where finishLoops puts the timeline getters, if the loop
+	// reports from its tick
+	tick := fmt.Sprintf(" __loop_tick(%d%%s)", id) + strings.ReplaceAll(captures.String(), "%", "%%") + ";"
+	var ref hookRef
+	if block, ok := body.(*ast.BlockStatement); ok {
+		in.insert(in.offset(block.LeftBrace)+1, tick)
+		ref = hookRef{&in.ins, len(in.ins) - 1}
+	} else {
+		// braceless body: `for (...) sum += i;` becomes a block
+		in.open(in.start(body), "{"+tick+" ")
+		in.close(in.statementEnd(body), " }")
+		ref = hookRef{&in.opens, len(in.opens) - 1}
+	}
+	in.snapshot(id, stmt, ref)
+	return id
+}
+
+// headerNames returns the variables a `for (...;...;...)` initializer
+// declares.
+func headerNames(init ast.ForLoopInitializer) []string {
+	switch init := init.(type) {
+	case *ast.ForLoopInitializerVarDeclList:
+		return bindingNames(init.List)
+	case *ast.ForLoopInitializerLexicalDecl:
+		return bindingNames(init.LexicalDeclaration.List)
+	}
+	return nil
+}
+
+// intoScope returns the scope the variables on the left side of a for-in
+// or for-of belong to, a new loop scope for let/const, and the function
+// that ends it.
+func (in *instrumenter) intoScope(into ast.ForInto, from int) (int, func()) {
+	if _, ok := into.(*ast.ForDeclaration); ok {
+		end := in.enterScope("for loop", in.line(from), false)
+		return in.scope, end
+	}
+	return in.fnScope, func() {}
+}
+
+// intoNames is headerNames for the left side of for-in and for-of.
+func intoNames(into ast.ForInto) []string {
+	switch into := into.(type) {
+	case *ast.ForIntoVar:
+		return patternNames(into.Binding.Target)
+	case *ast.ForDeclaration:
+		return patternNames(into.Target)
+	}
+	return nil
+}
+
+func (in *instrumenter) bindings(list []*ast.Binding, loop int) {
+	for _, b := range list {
+		in.expression(b.Target, loop)
+		if b.Initializer != nil {
+			in.named(b.Initializer, identifierName(b.Target), loop)
+		}
+	}
+}
+
+// named walks e, naming it name if it is a function.
+func (in *instrumenter) named(e ast.Expression, name string, loop int) {
+	switch fn := e.(type) {
+	case *ast.FunctionLiteral:
+		in.function(fn, name, loop)
+	case *ast.ArrowFunctionLiteral:
+		in.arrow(fn, name, loop)
+	default:
+		in.expression(e, loop)
+	}
+}
+
+func (in *instrumenter) expression(e ast.Expression, loop int) {
+	switch e := e.(type) {
+	case *ast.FunctionLiteral, *ast.ArrowFunctionLiteral:
+		in.named(e, "anonymous", loop)
+	case *ast.ClassLiteral:
+		in.class(e, loop)
+	case *ast.AssignExpression:
+		done := in.assignment(e)
+		in.assign(loop, e.Left)
+		in.expression(e.Left, loop)
+		in.named(e.Right, identifierName(e.Left), loop)
+		done()
+	case *ast.CallExpression:
+		in.breakpoint(e)
+		in.expression(e.Callee, loop)
+		in.expressions(e.ArgumentList, loop)
+	case *ast.NewExpression:
+		in.expression(e.Callee, loop)
+		in.expressions(e.ArgumentList, loop)
+	case *ast.ObjectLiteral:
+		in.properties(e.Value, loop)
+	case *ast.ObjectPattern, *ast.ArrayPattern:
+		in.pattern(e, loop)
+	case *ast.ArrayLiteral:
+		in.expressions(e.Value, loop)
+	case *ast.BinaryExpression:
+		in.expression(e.Left, loop)
+		in.expression(e.Right, loop)
+	case *ast.ConditionalExpression:
+		in.expression(e.Test, loop)
+		in.expression(e.Consequent, loop)
+		in.expression(e.Alternate, loop)
+	case *ast.SequenceExpression:
+		in.expressions(e.Sequence, loop)
+	case *ast.UnaryExpression:
+		done := in.assignment(e)
+		in.assign(loop, e)
+		in.expression(e.Operand, loop)
+		done()
+	case *ast.AwaitExpression:
+		done := in.await(e)
+		in.expression(e.Argument, loop)
+		done()
+	case *ast.YieldExpression:
+		in.expression(e.Argument, loop)
+	case *ast.DotExpression:
+		in.expression(e.Left, loop)
+	case *ast.PrivateDotExpression:
+		in.expression(e.Left, loop)
+	case *ast.BracketExpression:
+		in.expression(e.Left, loop)
+		in.expression(e.Member, loop)
+	case *ast.OptionalChain:
+		in.expression(e.Expression, loop)
+	case *ast.Optional:
+		in.expression(e.Expression, loop)
+	case *ast.SpreadElement:
+		in.expression(e.Expression, loop)
+	case *ast.TemplateLiteral:
+		in.expression(e.Tag, loop)
+		in.expressions(e.Expressions, loop)
+	}
+}
+
+func (in *instrumenter) expressions(list []ast.Expression, loop int) {
+	for _, e := range list {
+		in.expression(e, loop)
+	}
+}
+
+// pattern walks the target of a destructuring, where `x = 1` is a
+// default of the pattern rather than an assignment to hook.
+func (in *instrumenter) pattern(e ast.Expression, loop int) {
+	switch e := e.(type) {
+	case *ast.ArrayPattern:
+		for _, el := range e.Elements {
+			in.pattern(el, loop)
+		}
+		in.pattern(e.Rest, loop)
+	case *ast.ObjectPattern:
+		for _, p := range e.Properties {
+			switch p := p.(type) {
+			case *ast.PropertyKeyed:
+				if p.Computed {
+					in.expression(p.Key, loop)
+				}
+				in.pattern(p.Value, loop)
+			case *ast.PropertyShort:
+				in.named(p.Initializer, p.Name.Name.String(), loop)
+			}
+		}
+		in.pattern(e.Rest, loop)
+	case *ast.AssignExpression:
+		in.pattern(e.Left, loop)
+		in.named(e.Right, identifierName(e.Left), loop)
+	default:
+		in.expression(e, loop)
+	}
+}
+
+func (in *instrumenter) properties(list []ast.Property, loop int) {
+	for _, p := range list {
+		switch p := p.(type) {
+		case *ast.PropertyKeyed:
+			if p.Computed {
+				in.expression(p.Key, loop)
+			}
+			in.named(p.Value, propertyName(p.Key, p.Computed), loop)
+		case *ast.PropertyShort:
+			in.expression(p.Initializer, loop)
+		case *ast.SpreadElement:
+			in.expression(p.Expression, loop)
+		}
+	}
+}
+
+func (in *instrumenter) class(c *ast.ClassLiteral, loop int) {
+	in.expression(c.SuperClass, loop)
+	for _, el := range c.Body {
+		switch el := el.(type) {
+		case *ast.MethodDefinition:
+			if el.Computed {
+				in.expression(el.Key, loop)
+			}
+			in.function(el.Body, propertyName(el.Key, el.Computed), loop)
+		case *ast.FieldDefinition:
+			if el.Computed {
+				in.expression(el.Key, loop)
+			}
+			if el.Initializer != nil {
+				in.named(el.Initializer, propertyName(el.Key, el.Computed), loop)
+			}
+		case *ast.ClassStaticBlock:
+			in.statement(el.Block, loop)
+		}
+	}
+}
+
+// bindingNames returns the variables a declaration list binds.
+func bindingNames(list []*ast.Binding) []string {
+	var names []string
+	for _, b := range list {
+		names = append(names, patternNames(b.Target)...)
+	}
+	return names
+}
+
+// patternNames returns the variables a binding target such as `x`,
+// `{ a, b: [c, d = 1], ...rest }` binds.
+func patternNames(target ast.Expression) []string {
+	switch t := target.(type) {
+	case *ast.Identifier:
+		return []string{t.Name.String()}
+	case *ast.AssignExpression:
+		return patternNames(t.Left)
+	case *ast.ArrayPattern:
+		var names []string
+		for _, el := range t.Elements {
+			names = append(names, patternNames(el)...)
+		}
+		return append(names, patternNames(t.Rest)...)
+	case *ast.ObjectPattern:
+		var names []string
+		for _, p := range t.Properties {
+			switch p := p.(type) {
+			case *ast.PropertyShort:
+				names = append(names, p.Name.Name.String())
+			case *ast.PropertyKeyed:
+				names = append(names, patternNames(p.Value)...)
+			}
+		}
+		return append(names, patternNames(t.Rest)...)
+	}
+	return nil
+}
+
+// identifierName is the name a function assigned to target is known by:
+// `add` for both `const add = ...` and `math.add = ...`.
+func identifierName(target ast.Expression) string {
+	switch t := target.(type) {
+	case *ast.Identifier:
+		return t.Name.String()
+	case *ast.DotExpression:
+		return t.Identifier.Name.String()
+	}
+	return "anonymous"
+}
+
+// propertyName is the name of an object or class member with the given key.
+func propertyName(key ast.Expression, computed bool) string {
+	if computed {
+		return "anonymous"
+	}
+	switch k := key.(type) {
+	case *ast.StringLiteral:
+		return k.Value.String()
+	case *ast.Identifier:
+		return k.Name.String()
+	case *ast.NumberLiteral:
+		return k.Literal
+	}
+	return "anonymous"
+}
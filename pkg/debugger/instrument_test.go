@@ -0,0 +1,182 @@
+package debugger
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSpreadCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		history map[string]string
+		inLoop  []string // Variables of the script's loop, if it has one
+		after   string   // declared after the loop
+	}{
+		{
+			name:    "object spread",
+			script:  "const a = { x: 1 };\nconst b = { y: 2 };\nconst merged = { ...a, ...b, z: 3 };",
+			history: map[string]string{"merged": "[map[x:1 y:2 z:3]]"},
+		},
+		{
+			name:    "array spread",
+			script:  "const x = [1, 2];\nconst y = [3];\nconst arr = [...x, ...y];",
+			history: map[string]string{"arr": "[[1 2 3]]"},
+		},
+		{
+			name: "in a loop",
+			script: `const base = { n: 0 };
+for (let i = 0; i < 2; i++) {
+  const next = { ...base, n: i };
+  const pair = [...[i], i * 2];
+}
+const after = { ...base };`,
+			history: map[string]string{"next": "[map[n:0] map[n:1]]", "pair": "[[0 0] [1 2]]", "after": "[map[n:0]]"},
+			inLoop:  []string{"i", "next", "pair"},
+			after:   "after",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			for name, want := range tt.history {
+				if got := captured(r, name); got != want {
+					t.Errorf("%s captured %s, want %s", name, got, want)
+				}
+			}
+			if tt.inLoop == nil {
+				return
+			}
+			if len(r.Loops) != 1 || !slices.Equal(r.Loops[0].Variables, tt.inLoop) {
+				t.Fatalf("loops = %+v, want one with variables %v", r.Loops, tt.inLoop)
+			}
+			if slices.Contains(r.Loops[0].Variables, tt.after) {
+				t.Errorf("%s is attributed to the loop", tt.after)
+			}
+		})
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		variable string
+		want     string
+	}{
+		{
+			name:     "no implicit globals",
+			script:   "\"use strict\";\nlet threw = false;\ntry {\n  undeclared = 1;\n} catch (e) {\n  threw = e instanceof ReferenceError;\n}",
+			variable: "threw",
+			want:     "[false true]",
+		},
+		{
+			name:     "this in a function",
+			script:   "'use strict';\nfunction f() {\n  const self = this;\n  return self === undefined;\n}\nconst unbound = f();",
+			variable: "unbound",
+			want:     "[true]",
+		},
+		{
+			name:     "function directive",
+			script:   "function f() {\n  \"use strict\";\n  let arr = [];\n  for (let i = 0; i < 2; i++) arr.push(i);\n  return (function () { return this; })();\n}\nconst inner = f();",
+			variable: "inner",
+			want:     "[undefined]",
+		},
+		{
+			name:     "after a comment",
+			script:   "// strict from here\n\"use strict\";\nlet n = 0;\nfor (let i = 0; i < 3; i++) {\n  n += i;\n}\nconst frozen = Object.freeze({ n });\nlet failed = false;\ntry { frozen.n = 1; } catch (e) { failed = true; }",
+			variable: "failed",
+			want:     "[false true]",
+		},
+	}
+	// the hooks these put in front of statements mustn't go before
+	// a directive
+	configs := []struct {
+		name      string
+		configure func(*Config)
+	}{
+		{"default", nil},
+		{"coverage", func(c *Config) { c.Coverage = true }},
+		{"step", func(c *Config) {
+			c.Step = true
+			c.Hooks.Breakpoint = func(string, int, map[string]any) bool { return true }
+		}},
+	}
+	for _, config := range configs {
+		for _, tt := range tests {
+			t.Run(config.name+"/"+tt.name, func(t *testing.T) {
+				r := runScript(t, tt.script, config.configure)
+				if got := captured(r, tt.variable); got != tt.want {
+					t.Errorf("%s captured %s, want %s", tt.variable, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// goja ends `new C()` before its `()` and `this.#p` before its last
+// character; the hooks after them have to go after the whole expression.
+func TestExpressionEnds(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		variable string
+		want     string
+	}{
+		{name: "new in a declaration", script: "const m = new Map();", variable: "m", want: "[Map(0) {}]"},
+		{name: "new in an assignment", script: "let s;\ns = new Set( );", variable: "s", want: "[undefined Set(0) {}]"},
+		{name: "new of new", script: "const n = new new Function(\"this.z = 1\")();", variable: "n", want: "[anonymous { z: 1 }]"},
+		{name: "new of a class expression", script: "const k = new (class {})();", variable: "k", want: "[{}]"},
+		{name: "new in a sum", script: "const size = 1 + new Array().length;", variable: "size", want: "[1]"},
+		{name: "private field", script: "class C {\n  #p = 7;\n  read() {\n    const v = this.#p;\n    return v;\n  }\n}\nconst got = new C().read();", variable: "v", want: "[7]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			if got := renderCaptured(r, tt.variable); got != tt.want {
+				t.Errorf("%s captured %s, want %s", tt.variable, got, tt.want)
+			}
+		})
+	}
+}
+
+// A default in a destructuring pattern looks like an assignment to goja,
+// but can't be wrapped as one.
+func TestDestructuringDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		history map[string]string
+	}{
+		{
+			name:    "array declaration",
+			script:  "const [p = 5] = [];",
+			history: map[string]string{"p": "[5]"},
+		},
+		{
+			name:    "nested in an object",
+			script:  "const { b: [c, d = 4] } = { b: [3] };",
+			history: map[string]string{"c": "[3]", "d": "[4]"},
+		},
+		{
+			name:    "assignment",
+			script:  "let x, y;\n[x, y = 2] = [1];\nconst sum = x + y;",
+			history: map[string]string{"sum": "[3]"},
+		},
+		{
+			name:    "function default",
+			script:  "const { e = () => 6 } = {};\nconst f = e();",
+			history: map[string]string{"f": "[6]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			for name, want := range tt.history {
+				if got := captured(r, name); got != want {
+					t.Errorf("%s captured %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
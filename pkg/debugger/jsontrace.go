@@ -0,0 +1,65 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// JSONCall is one traced JSON.parse or JSON.stringify call.
+type JSONCall struct {
+	Seq    int
+	Op     string
+	Input  any
+	Output any
+	Err    string
+}
+
+func (c JSONCall) render(limit int) string {
+	// JSON text is quoted so pretty-printed output stays on one line
+	operand := func(v any) string {
+		if s, isString := v.(string); isString {
+			return renderValue(strconv.Quote(s), limit)
+		}
+		return renderValue(v, limit)
+	}
+	if c.Err != "" {
+		return fmt.Sprintf("#%d %s: %s → threw %s", c.Seq, c.Op, operand(c.Input), c.Err)
+	}
+	return fmt.Sprintf("#%d %s: %s → %s", c.Seq, c.Op, operand(c.Input), operand(c.Output))
+}
+
+// traceJSON replaces JSON.parse and JSON.stringify with versions that
+// record each call's first argument and result (-trace-json).
+func traceJSON(vm *goja.Runtime, state *RunState) {
+	json := vm.Get("JSON").ToObject(vm)
+
+	for _, op := range []string{"parse", "stringify"} {
+		original, ok := goja.AssertFunction(json.Get(op))
+		if !ok {
+			continue
+		}
+		op := op
+		json.Set(op, func(call goja.FunctionCall) goja.Value {
+			record := JSONCall{Seq: len(state.JSONCalls) + 1, Op: op, Input: captureValue(vm, call.Argument(0))}
+
+			result, err := original(call.This, call.Arguments...)
+			if err != nil {
+				var exception *goja.Exception
+				if errors.As(err, &exception) {
+					record.Err = exception.Value().String()
+				} else {
+					record.Err = err.Error()
+				}
+				state.JSONCalls = append(state.JSONCalls, record)
+				panic(err)
+			}
+
+			record.Output = captureValue(vm, result)
+			state.JSONCalls = append(state.JSONCalls, record)
+			return result
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heapInterval is how often -max-memory looks at the heap.
+const heapInterval = 50 * time.Millisecond
+
+// heapWatcher is the -max-memory limit. The script's values live in the
+// Go heap along with the debugger's captures of them, so the limit is on
+// both.
+type heapWatcher struct {
+	stop chan struct{}
+	done sync.WaitGroup
+
+	// exceeded is the heap size that went past the limit, only read once
+	// the watcher is stopped
+	exceeded uint64
+}
+
+// watchHeap calls exceed once the heap holds more than limit bytes,
+// unless stopped first.
+func watchHeap(limit uint64, exceed func()) *heapWatcher {
+	w := &heapWatcher{stop: make(chan struct{})}
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	ticker := time.NewTicker(heapInterval)
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			metrics.Read(sample)
+			if size := sample[0].Value.Uint64(); size > limit {
+				w.exceeded = size
+				exceed()
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// close stops the watcher and returns the heap size that went past the
+// limit, 0 if none did. A nil watcher watches nothing.
+func (w *heapWatcher) close() uint64 {
+	if w == nil {
+		return 0
+	}
+	close(w.stop)
+	w.done.Wait()
+	return w.exceeded
+}
+
+// sandboxed reports whether path is inside the script's directory, where
+// -sandbox lets require() load from. Links are followed, so one can't
+// point out of it.
+func sandboxed(path, script string) bool {
+	root, err := filepath.Abs(filepath.Dir(script))
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// stopReason says which limit stopped the run, for the console and the
+// reports; "" when none did.
+func (s *RunState) stopReason(opts *Config) string {
+	switch {
+	case s.Limit != "":
+		return s.Limit
+	case s.TimedOut && s.Pending > 0:
+		return fmt.Sprintf("timed out after %v with %d timer(s) pending", opts.Timeout, s.Pending)
+	case s.TimedOut:
+		return fmt.Sprintf("timed out after %v", opts.Timeout)
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package debugger
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNestedLoopVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		loops   [][]string // each loop's Variables
+		outer   []int      // each loop's Outer
+		history map[string]string
+	}{
+		{
+			name: "for in while",
+			script: `let n = 0;
+while (n < 2) {
+  let outer = n * 10;
+  for (let j = 0; j < 2; j++) {
+    let inner = outer + j;
+  }
+  n++;
+}`,
+			loops:   [][]string{{"outer"}, {"j", "inner"}},
+			outer:   []int{0, 1},
+			history: map[string]string{"outer": "[0 10]", "j": "[0 1 0 1]", "inner": "[0 1 10 11]"},
+		},
+		{
+			name: "for in for",
+			script: `for (var a = 0; a < 2; a++) {
+  const row = [];
+  for (var b = 0; b < 3; b++) {
+    const cell = a * 3 + b;
+  }
+}`,
+			loops:   [][]string{{"a", "row"}, {"b", "cell"}},
+			outer:   []int{0, 1},
+			history: map[string]string{"a": "[0 1]", "b": "[0 1 2 0 1 2]", "cell": "[0 1 2 3 4 5]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			if len(r.Loops) != len(tt.loops) {
+				t.Fatalf("got %d loops, want %d", len(r.Loops), len(tt.loops))
+			}
+			for i, loop := range r.Loops {
+				if !slices.Equal(loop.Variables, tt.loops[i]) {
+					t.Errorf("loop %d variables = %v, want %v", i+1, loop.Variables, tt.loops[i])
+				}
+				if loop.Outer != tt.outer[i] {
+					t.Errorf("loop %d outer = %d, want %d", i+1, loop.Outer, tt.outer[i])
+				}
+			}
+			for name, want := range tt.history {
+				if got := captured(r, name); got != want {
+					t.Errorf("%s captured %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoopHeaderVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		variable string
+		want     string
+	}{
+		{name: "for let", script: "let sum = 0;\nfor (let i = 0; i < 3; i++) {\n  sum += i;\n}", variable: "i", want: "[0 1 2]"},
+		{name: "for let braceless", script: "let sum = 0;\nfor (let i = 0; i < 3; i++) sum += i;", variable: "i", want: "[0 1 2]"},
+		{name: "for var", script: "for (var i = 0, j = 10; i < 2; i++, j--) {}", variable: "j", want: "[10 9]"},
+		{name: "for of", script: "const arr = [1, 2, 3];\nlet total = 0;\nfor (const x of arr) {\n  total += x;\n}", variable: "x", want: "[1 2 3]"},
+		{name: "for of destructuring", script: "for (const [k, v] of [[\"a\", 1], [\"b\", 2]]) {}", variable: "v", want: "[1 2]"},
+		{name: "for in", script: "const obj = { a: 1, b: 2 };\nfor (const k in obj) {\n  obj[k]++;\n}", variable: "k", want: "[a b]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			if len(r.Loops) != 1 {
+				t.Fatalf("got %d loops, want 1", len(r.Loops))
+			}
+			if !slices.Contains(r.Loops[0].Variables, tt.variable) {
+				t.Errorf("loop variables = %v, want %s among them", r.Loops[0].Variables, tt.variable)
+			}
+			if got := captured(r, tt.variable); got != tt.want {
+				t.Errorf("%s captured %s, want %s", tt.variable, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoopTimeline(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string // the script's one loop's Timeline, rendered
+	}{
+		{name: "for", script: "for (let i = 0; i < 2; i++) {}", want: []string{"#1: i = 0 (condition: true)", "#2: i = 1 (condition: true)", "exit: i = 2 (condition: false)"}},
+		{name: "while", script: "let n = 2;\nwhile (n > 0) {\n  n--;\n}", want: []string{"#1: n = 2 (condition: true)", "#2: n = 1 (condition: true)", "exit: n = 0 (condition: false)"}},
+		{name: "for of", script: "for (const x of [\"a\", \"b\"]) {}", want: []string{"#1: x = a", "#2: x = b"}},
+		{name: "do-while", script: "let i = 0;\ndo {\n  i++;\n} while (i < 2);", want: []string{"#1: i = 0", "#2: i = 1 (condition: true)", "exit: i = 2 (condition: false)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, nil)
+			if len(r.Loops) != 1 {
+				t.Fatalf("got %d loops, want 1", len(r.Loops))
+			}
+			var got []string
+			for _, step := range r.Loops[0].Timeline {
+				got = append(got, step.render(r.Loops[0].Tracked, 0))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("timeline = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
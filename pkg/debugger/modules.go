@@ -0,0 +1,224 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// ModuleLoad pairs a require() specifier with the file it resolved to.
+type ModuleLoad struct {
+	Specifier string
+	Path      string
+
+	// Name is the module's path relative to the script, what its
+	// variables, scopes and loops are tagged with. Source and Variables,
+	// the latest capture of each variable in the module, are set for the
+	// local .js files, which are instrumented as the script is.
+	Name      string
+	Source    []string
+	Variables map[string]any
+
+	// filename is the module as the registry names it, in call stacks,
+	// and columns maps their positions back to Source, through transpiled
+	// for a TypeScript module.
+	filename   string
+	columns    sourceMap
+	transpiled sourceMap
+}
+
+// moduleWrapper is what the require registry puts in front of the first
+// line of a module, shifting its columns.
+const moduleWrapper = "(function(exports,require,module,__filename,__dirname){"
+
+// moduleRecorder wraps the require registry's resolver and loader so every
+// module file actually loaded is noted along with what was asked for, and
+// instruments the script's own modules before they run. Only require() is
+// seen; the runtime doesn't support import.
+type moduleRecorder struct {
+	state   *RunState
+	opts    *Config
+	pending string // specifier of the require() currently being resolved
+
+	// set once a package.json was probed, so its "main" isn't mistaken
+	// for a new specifier
+	inPackage bool
+}
+
+func (m *moduleRecorder) resolve(base, target string) string {
+	switch {
+	case target == "package.json":
+		m.inPackage = true
+	case target == "index.js" || target == "index.json":
+		// probes made while resolving a directory, not a new specifier
+	case m.inPackage:
+		m.inPackage = false
+	default:
+		m.pending = target
+	}
+	return require.DefaultPathResolver(base, target)
+}
+
+func (m *moduleRecorder) load(filename string) ([]byte, error) {
+	path, absErr := filepath.Abs(filename)
+	if absErr != nil {
+		path = filename
+	}
+	if m.opts.Sandbox && !sandboxed(path, m.opts.Script) {
+		m.pending, m.inPackage = "", false
+		return nil, fmt.Errorf("-sandbox: %s is outside the script's directory", filename)
+	}
+	data, err := require.DefaultSourceLoader(filename)
+	if errors.Is(err, require.ModuleFileDoesNotExistError) && m.opts.typeScript() {
+		// TypeScript imports util.ts as ./util or ./util.js; the registry
+		// keeps the name it asked for
+		if ts := strings.TrimSuffix(filename, ".js") + ".ts"; ts != filename {
+			if tsData, tsErr := require.DefaultSourceLoader(ts); tsErr == nil {
+				data, err = tsData, nil
+				if path, absErr = filepath.Abs(ts); absErr != nil {
+					path = ts
+				}
+			}
+		}
+	}
+	if err != nil || filepath.Base(filename) == "package.json" {
+		return data, err
+	}
+
+	specifier := m.pending
+	if specifier == "" {
+		specifier = filename
+	}
+	module := ModuleLoad{Specifier: specifier, Path: path, filename: filename}
+	m.pending, m.inPackage = "", false
+	if ext := filepath.Ext(path); (ext == ".js" || ext == ".cjs" || typeScriptExts[ext]) && !slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "node_modules") {
+		instrumented, err := m.instrument(&module, string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(instrumented)
+	}
+	m.state.Modules = append(m.state.Modules, module)
+	return data, nil
+}
+
+// instrument instruments the source of module as instrumentCode does the
+// script's, its scopes, loops and covered statements numbered on from the
+// ones already known, after transpiling a TypeScript module.
+// -break, -logpoint and -inspect stay with the script. A module that
+// doesn't parse is left for the registry to report; one that doesn't
+// transpile fails with the error.
+func (m *moduleRecorder) instrument(module *ModuleLoad, src string) (string, error) {
+	module.Name = filepath.Base(module.Path)
+	if script, err := filepath.Abs(m.opts.Script); err == nil {
+		if rel, err := filepath.Rel(filepath.Dir(script), module.Path); err == nil {
+			module.Name = filepath.ToSlash(rel)
+		}
+	}
+	source := src
+	if typeScriptExts[filepath.Ext(module.Path)] {
+		js, columns, err := transpile(src, module.Name, true)
+		if err != nil {
+			return "", err
+		}
+		src, module.transpiled = js, columns
+	}
+	program, err := parser.ParseFile(nil, module.filename, src, 0, parser.WithDisableSourceMaps)
+	if err != nil {
+		return src, nil
+	}
+
+	opts := *m.opts
+	opts.Script, opts.Breaks, opts.Logpoints, opts.Inspect = module.filename, nil, nil, ""
+	state := m.state
+	in := newInstrumenter(program, src, &opts)
+	firstScope, firstLoop, firstStatement := len(state.Scopes), len(state.Loops), len(state.Coverage)
+	in.scopes = append(make([]Scope, firstScope), Scope{Name: "module " + module.Name, Parent: -1, File: module.Name})
+	in.scope, in.fnScope = firstScope, firstScope
+	in.loops, in.firstLoop = make([]LoopInfo, firstLoop), firstLoop
+	in.coverage = make([]CoveredStatement, firstStatement)
+	in.body(program.Body, -1)
+	in.finishLoops()
+
+	module.columns = make(sourceMap)
+	instrumented := in.apply(module.columns)
+	module.columns[1] = append([]insertion{{pos: 0, text: moduleWrapper}}, module.columns[1]...)
+	module.Source = strings.Split(source, "\n")
+	module.Variables = make(map[string]any)
+
+	for _, sc := range in.scopes[firstScope:] {
+		sc.File = module.Name
+		state.Scopes = append(state.Scopes, sc)
+	}
+	for _, loop := range in.loops[firstLoop:] {
+		loop.File, loop.variables = module.Name, module.Variables
+		state.Loops = append(state.Loops, loop)
+	}
+	for _, st := range in.coverage[firstStatement:] {
+		st.File = module.Name
+		state.Coverage = append(state.Coverage, st)
+	}
+	for _, w := range in.warnings {
+		w.File = module.Name
+		fmt.Fprintf(opts.Stdout, "|!| %s\n", w)
+		state.Warnings = append(state.Warnings, w)
+	}
+	if !opts.NoInstrumentedDump {
+		fmt.Fprintf(opts.progress(), "\n|||> Instrumented %s:\n", module.Name)
+		fmt.Fprintln(opts.progress(), instrumented)
+	}
+	return instrumented, nil
+}
+
+// module is the instrumented module scope is in, nil for the script's
+// own scopes.
+func (s *RunState) module(scope int) *ModuleLoad {
+	if scope < 0 || scope >= len(s.Scopes) || s.Scopes[scope].File == "" {
+		return nil
+	}
+	for i := range s.Modules {
+		if s.Modules[i].Name == s.Scopes[scope].File {
+			return &s.Modules[i]
+		}
+	}
+	return nil
+}
+
+// sourceLine is line of the file scope is in, trimmed.
+func (s *RunState) sourceLine(scope, line int) string {
+	source := s.Source
+	if m := s.module(scope); m != nil {
+		source = m.Source
+	}
+	if line < 1 || line > len(source) {
+		return ""
+	}
+	return strings.TrimSpace(source[line-1])
+}
+
+// location is "line N", with the module in front for one in a module.
+func (s *RunState) location(scope, line int) string {
+	if m := s.module(scope); m != nil {
+		return fmt.Sprintf("%s line %d", m.Name, line)
+	}
+	return fmt.Sprintf("line %d", line)
+}
+
+// columnsFor maps the columns of file back to its source: the script's or
+// an instrumented module's.
+func (s *RunState) columnsFor(file, script string) (sourceMaps, bool) {
+	if file == script {
+		return sourceMaps{s.Columns, s.Transpiled}, true
+	}
+	for _, m := range s.Modules {
+		if m.columns != nil && m.filename == file {
+			return sourceMaps{m.columns, m.transpiled}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,168 @@
+package debugger
+
+import (
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// mutationTracker snapshots global variables and object arguments around
+// every call so it can report what each function changed (-detect-mutation).
+// A function is charged with its callees' mutations too.
+type mutationTracker struct {
+	vm       *goja.Runtime
+	programs map[string]*goja.Program
+	stack    []mutationFrame
+
+	// snapshotting is set while globals reads the variables: one that is
+	// an accessor runs script code, whose calls mustn't snapshot again.
+	snapshotting bool
+
+	// Functions lists every function called, in first-call order, with
+	// the paths it mutated.
+	Functions []string
+	Mutated   map[string][]string
+}
+
+type mutationFrame struct {
+	fn      string
+	globals map[string]any
+	args    map[string]*goja.Object
+	before  map[string]any
+}
+
+func newMutationTracker(vm *goja.Runtime) *mutationTracker {
+	return &mutationTracker{vm: vm, programs: make(map[string]*goja.Program), Mutated: make(map[string][]string)}
+}
+
+// globals reads names in the global scope, skipping any that don't resolve
+// there (locals of some function) or aren't initialised yet.
+func (m *mutationTracker) globals(names []string) map[string]any {
+	m.snapshotting = true
+	defer func() { m.snapshotting = false }()
+	values := make(map[string]any, len(names))
+	for _, name := range names {
+		program, ok := m.programs[name]
+		if !ok {
+			program, _ = goja.Compile("mutation", name, false)
+			m.programs[name] = program
+		}
+		if program == nil {
+			continue
+		}
+		if v, err := m.vm.RunProgram(program); err == nil {
+			values[name] = captureValue(m.vm, v)
+		}
+	}
+	return values
+}
+
+func (m *mutationTracker) enter(fn string, names []string, params goja.Value) {
+	if m.snapshotting {
+		return
+	}
+	frame := mutationFrame{fn: fn, globals: m.globals(names), args: make(map[string]*goja.Object), before: make(map[string]any)}
+	if obj, ok := params.(*goja.Object); ok {
+		for _, k := range obj.Keys() {
+			if arg, isObj := obj.Get(k).(*goja.Object); isObj {
+				frame.args[k] = arg
+				frame.before[k] = captureValue(m.vm, arg)
+			}
+		}
+	}
+	m.stack = append(m.stack, frame)
+
+	if _, seen := m.Mutated[fn]; !seen {
+		m.Mutated[fn] = nil
+		m.Functions = append(m.Functions, fn)
+	}
+}
+
+func (m *mutationTracker) exit() {
+	n := len(m.stack)
+	if n == 0 || m.snapshotting {
+		return
+	}
+	frame := m.stack[n-1]
+	m.stack = m.stack[:n-1]
+
+	names := make([]string, 0, len(frame.globals))
+	for name := range frame.globals {
+		names = append(names, name)
+	}
+	after := m.globals(names)
+
+	var paths []string
+	for _, name := range sortedKeys(frame.globals) {
+		paths = append(paths, changedPaths(name, frame.globals[name], after[name])...)
+	}
+	for _, name := range sortedKeys(frame.before) {
+		for _, p := range changedPaths(name, frame.before[name], captureValue(m.vm, frame.args[name])) {
+			paths = append(paths, p+" (argument)")
+		}
+	}
+
+	for _, p := range paths {
+		if !containsString(m.Mutated[frame.fn], p) {
+			m.Mutated[frame.fn] = append(m.Mutated[frame.fn], p)
+		}
+	}
+}
+
+// changedPaths names what differs between two captures of a variable:
+// `cache.hits` for a changed property of an object, else the name.
+func changedPaths(name string, before, after any) []string {
+	if renderValue(before, 0) == renderValue(after, 0) {
+		return nil
+	}
+
+	old, wasObj := objectFields(before)
+	current, isObj := objectFields(after)
+	if !wasObj || !isObj {
+		return []string{name}
+	}
+
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range current {
+		keys[k] = true
+	}
+	var paths []string
+	for _, k := range sortedKeys(keys) {
+		if renderValue(old[k], 0) != renderValue(current[k], 0) {
+			paths = append(paths, name+"."+k)
+		}
+	}
+	return paths
+}
+
+// describe renders the report line for fn, e.g. `compute mutates: total`.
+func (m *mutationTracker) describe(fn string) string {
+	if len(m.Mutated[fn]) == 0 {
+		return fn + " mutates nothing"
+	}
+	return fn + " mutates: " + strings.Join(m.Mutated[fn], ", ")
+}
+
+// objectFields is the properties of a captured plain object or class
+// instance.
+func objectFields(v any) (map[string]any, bool) {
+	switch t := v.(type) {
+	case map[string]any:
+		return t, true
+	case jsInstance:
+		return t.Fields, true
+	}
+	return nil, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package debugger
+
+import (
+	"slices"
+	"testing"
+)
+
+// Snapshotting the globals can run script code, a getter or an accessor,
+// whose calls mustn't start another snapshot.
+func TestMutationSnapshotReentry(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{
+			name:   "getter",
+			script: "const o = { get g() { return 3; } };\nfunction f() { return 1; }\nf();",
+		},
+		{
+			name:   "global accessor",
+			script: "let count = 0;\nfunction tick() { count++; return count; }\nseen = 0;\nObject.defineProperty(globalThis, \"seen\", { get() { return tick(); }, configurable: true });\nfunction f() { return 1; }\nf();",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, func(c *Config) { c.DetectMutation = true })
+			if got := r.State.Mutations.Functions; !slices.Equal(got, []string{"f"}) {
+				t.Errorf("functions = %q, want [f]", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package debugger
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Config holds the switches that tune a debug run, set from the command
+// line by ParseFlags or directly by programs embedding the debugger.
+type Config struct {
+	// Stdin, Stdout and Stderr are the console of a run: breakpoint
+	// prompts read Stdin, progress and snapshots go to Stdout. Left nil,
+	// they are the process's own.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+
+	// Report, when set, receives the snapshot and loop reports instead of
+	// the files in Out.
+	Report io.Writer
+
+	Hooks Hooks
+
+	Script          string
+	Out             string
+	ChangedOnly     bool
+	Indent          string
+	NoFiles         bool
+	RequireCaptures bool
+	ScriptArgs      []string
+	Env             []string
+	WarningsOut     string
+	Flamegraph      string
+	Annotate        string
+	MaxBreakpoints  int
+	MaxValueLen     int
+	MaxIterations   int
+	Timings         bool
+	CaptureWhen     string
+	FirstErrorOnly  bool
+	GroupLoopsBy    string
+	LoopStats       bool
+	TraceJSON       bool
+	FinalOnly       bool
+	TrackElements   bool
+	DetectMutation  bool
+	Step            bool
+	Format          string
+	LoopDiff        *LoopDiff
+
+	// MaxStringCapture caps the bytes kept for a captured string, unlike
+	// MaxValueLen which only shortens what is printed.
+	MaxStringCapture int
+
+	// QuietBreakpoints records a snapshot per breakpoint hit instead of
+	// pausing, keyed by the breakpoint's label.
+	QuietBreakpoints bool
+
+	// CaptureTemplate is the snippet appended after each declaration to
+	// capture it; Name is the variable's name as a JS string literal, Expr
+	// the expression to capture, Line the script line and Scope the number
+	// of the scope it is declared in.
+	CaptureTemplate string
+	captureTemplate *template.Template
+
+	// Breaks are the 1-based script lines -break pauses in front of, each
+	// with the condition it only pauses under ("" for always).
+	Breaks map[int]string
+
+	// Logpoints are the messages -logpoint prints in front of a line,
+	// with the JS expressions in {braces} filled in.
+	Logpoints map[int]string
+
+	// LoopTypes restricts loop analysis to these kinds; nil means all.
+	LoopTypes map[string]bool
+
+	// BreakOnException pauses where an exception is thrown, caught later
+	// or not, and reports unhandled promise rejections.
+	BreakOnException bool
+
+	// TraceCalls logs every call of an instrumented function with its
+	// arguments and how it returned, as an indented call tree.
+	TraceCalls bool
+
+	// Timeout bounds how long the script runs, its top level and then the
+	// timers and promises it left, not counting time paused at a prompt;
+	// 0 lets it run as long as it does.
+	Timeout time.Duration
+
+	// MaxLoopIterations stops the script once an analyzed loop starts
+	// more iterations than this, where MaxIterations only bounds what is
+	// recorded; 0 is no limit.
+	MaxLoopIterations int
+
+	// MaxMemory stops the script once the heap holds more than this many
+	// bytes; 0 is no limit.
+	MaxMemory uint64
+
+	// Profile times the calls of every function and the loops, for a
+	// hot-spot table in the report, and writes the folded stacks to
+	// profile.folded, or to Flamegraph when that is set.
+	Profile bool
+
+	// Coverage counts the runs of every statement, for coverage.txt and
+	// the report; LCOV is a file to also write them to as an LCOV
+	// tracefile, which the -lcov flag turns Coverage on for.
+	Coverage bool
+	LCOV     string
+
+	// Sandbox only lets require() load files inside the script's
+	// directory.
+	Sandbox bool
+
+	// Lang is the script's language, "js" or "ts"; "" tells them apart by
+	// the extension. TypeScript is transpiled before it is instrumented,
+	// and so are the .ts modules it requires, which `./name` and
+	// `./name.js` find.
+	Lang string
+
+	// Inspect, a port or host:port, serves the run to Chrome DevTools and
+	// other Chrome DevTools Protocol clients, which then pause it instead
+	// of the prompt. The script waits for one to attach.
+	Inspect string
+
+	// InspectDepth is how many levels of nested objects the listings of
+	// variables expand before showing `[Object]`; -1 expands them all.
+	InspectDepth int
+
+	// Watches are the -watch expressions, shown at every pause.
+	Watches []string
+
+	// Record is the file the run's events are saved to, for Replay.
+	Record string
+
+	// Replay is a file Record saved, stepped through by the CLI instead of
+	// running a script.
+	Replay string
+
+	// Plugins are JS files loaded into the runtime before the script, whose
+	// exported hooks are called as Hooks' are; see pluginHooks.
+	Plugins []string
+
+	// WatchFiles tells the CLI to run the script again, with Session.Watch,
+	// every time it or one of its modules is saved.
+	WatchFiles bool
+
+	// LoopsOut, when set, is where the loop report goes instead of -out.
+	LoopsOut string
+
+	// Quiet leaves breakpoints, warnings and errors the only console
+	// output; NoInstrumentedDump only drops the instrumented code.
+	Quiet              bool
+	NoInstrumentedDump bool
+}
+
+// ParseFlags builds a Config from command-line arguments, registering the
+// flags on fs. Arguments after the flags name the script.
+func ParseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	opts := &Config{}
+	var indent, scriptArgs, env, loopTypes, loopDiff string
+
+	fs.StringVar(&opts.Script, "script", "script.js", "the script to debug, or - to read it from stdin")
+	fs.StringVar(&opts.Out, "out", ".", "directory to write output and loop reports to")
+	fs.StringVar(&opts.LoopsOut, "loops-out", "", "write the loop report to this file instead of loops.txt (loops.md) in -out")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "only print breakpoints, warnings and errors; the reports are still written")
+	fs.BoolVar(&opts.NoInstrumentedDump, "no-instrumented-dump", false, "don't print the instrumented code before running it")
+	fs.BoolVar(&opts.ChangedOnly, "diff-only", false, "at breakpoints, only show what changed since the previous pause: new variables, old → new values and the added and deleted keys of objects")
+	fs.BoolVar(&opts.ChangedOnly, "changed-only", false, "the same as -diff-only")
+	fs.StringVar(&indent, "indent", "2", "indentation for pretty output: a number of spaces or \"tab\"")
+	fs.BoolVar(&opts.NoFiles, "no-files", false, "don't write any report files, only print to the console")
+	fs.BoolVar(&opts.RequireCaptures, "require-captures", false, "exit non-zero if no variables were captured")
+	fs.StringVar(&scriptArgs, "script-args", "", "comma-separated arguments exposed to the script as scriptArgs and process.argv")
+	fs.StringVar(&env, "env", "", "comma-separated host environment variables to expose as process.env, or \"*\" for all")
+	fs.StringVar(&opts.Format, "format", "text", "report format: text (output.txt, loops.txt), markdown (output.md, loops.md), json (output.json), html (output.html, one page to share) or both (text and json)")
+	fs.StringVar(&opts.WarningsOut, "warnings-out", "", "also write warnings as a JSON array to this file")
+	fs.StringVar(&opts.Flamegraph, "flamegraph", "", "profile function calls and write folded stacks for flamegraph tools to this file")
+	fs.StringVar(&opts.Annotate, "annotate", "", "write a copy of the script with each line's captured values as trailing comments to this file")
+	fs.IntVar(&opts.MaxBreakpoints, "max-breakpoints", 0, "stop pausing after this many breakpoint hits (0 = unlimited)")
+	fs.BoolVar(&opts.QuietBreakpoints, "quiet-breakpoints", false, "don't pause at breakpoints; record a snapshot per hit, keyed by its label, in the report")
+	fs.IntVar(&opts.MaxValueLen, "max-value-len", 0, "truncate rendered values to this many characters (0 = no limit)")
+	fs.IntVar(&opts.InspectDepth, "inspect-depth", 2, "how many levels of nested objects and arrays the variable listings expand before showing [Object] (-1 = all)")
+	fs.IntVar(&opts.MaxIterations, "max-iterations", 1000, "record at most this many steps of each loop's timeline (0 = no limit)")
+	fs.IntVar(&opts.MaxStringCapture, "max-string-capture", 0, "store at most this many bytes of each captured string (0 = no limit)")
+	fs.BoolVar(&opts.Timings, "timings", false, "report time spent instrumenting, executing and writing output")
+	fs.StringVar(&opts.CaptureWhen, "capture-when", "", "only record a capture when this JS expression is truthy at the capture point")
+	fs.BoolVar(&opts.FirstErrorOnly, "first-error-only", false, "print only the failing line, its error and the captured variables; nothing on success but a short note")
+	fs.StringVar(&opts.GroupLoopsBy, "group-loops-by", "", "group the loop report: \"type\" lists all for loops, then while, ...; default is source order")
+	fs.BoolVar(&opts.LoopStats, "loop-stats", false, "add sum/avg/min/max of numeric values captured in each loop to the loop report")
+	fs.StringVar(&opts.CaptureTemplate, "capture-template", defaultCaptureTemplate, "Go template for the snippet injected after each declaration, e.g. to call your own collector")
+	fs.BoolVar(&opts.TraceJSON, "trace-json", false, "record the input and result of every JSON.parse and JSON.stringify call")
+	fs.BoolVar(&opts.FinalOnly, "final-only", false, "capture each variable once, when its function returns or the script ends, instead of after every declaration")
+	fs.BoolVar(&opts.TrackElements, "track-elements", false, "record every arr[i] = v assignment as a sequence of element writes")
+	fs.BoolVar(&opts.DetectMutation, "detect-mutation", false, "report, per function, which global variables and object arguments its calls changed")
+	fs.BoolVar(&opts.Step, "step", false, "pause before every statement: n or ENTER steps, c runs to the next breakpoint, q quits")
+	fs.StringVar(&loopDiff, "loop-diff", "", "compare the values captured in two iterations of a loop, as loop:from:to (e.g. 1:5:6)")
+	fs.Func("break", "pause before the statement on this script line, or only when a condition holds there as line:condition; repeat or comma-separate for more (e.g. -break 12,40 -break '25:i > 100')", func(value string) error {
+		return opts.addBreaks(value)
+	})
+	fs.Func("logpoint", "print a message before the statement on a line without pausing, as line:message; {expr} in it is evaluated there (e.g. -logpoint '30:user is {user.name}')", func(value string) error {
+		return opts.addLogpoint(value)
+	})
+	fs.Func("watch", "evaluate this JS expression at every breakpoint and step, showing when it changes; repeat for more (e.g. -watch cart.total)", func(value string) error {
+		opts.Watches = append(opts.Watches, strings.TrimSpace(value))
+		return nil
+	})
+	fs.Func("plugin", "load this JS file before the script; the onVariableAssigned(name, value, line), onLoopIteration(loop, n) and onFunctionCall(frame) its module.exports has are called as those happen, and pause there by returning true or a label; repeat for more", func(value string) error {
+		opts.Plugins = append(opts.Plugins, value)
+		return nil
+	})
+	fs.StringVar(&opts.Record, "record", "", "save every assignment, loop iteration, breakpoint hit and console line of the run, in order, to this file for -replay")
+	fs.StringVar(&opts.Replay, "replay", "", "step forward and back through a run -record saved to this file, inspecting the variables at any point, instead of running a script")
+	fs.BoolVar(&opts.WatchFiles, "watch-files", false, "run the script again every time it or a module it requires is saved, listing the captured values that changed since the previous run")
+	fs.BoolVar(&opts.TraceCalls, "trace-calls", false, "log every function call with its arguments and return value as an indented call tree, also written to trace.txt (with -format=json, to output.json)")
+	fs.DurationVar(&opts.Timeout, "timeout", 30*time.Second, "stop the script after this long, its top level or the timers and promises it left, and report what ran (0 = no limit)")
+	fs.IntVar(&opts.MaxLoopIterations, "max-loop-iterations", 0, "stop the script once a loop starts more than this many iterations, and report what ran (0 = no limit)")
+	fs.Func("max-memory", "stop the script once the heap holds more than this many MiB, and report what ran", func(value string) error {
+		mib, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil || mib == 0 {
+			return fmt.Errorf("%q is not a positive number of MiB", value)
+		}
+		opts.MaxMemory = mib << 20
+		return nil
+	})
+	fs.BoolVar(&opts.Profile, "profile", false, "time every function call and loop, not counting pauses, and report the hot spots; the folded stacks go to profile.folded (or the -flamegraph file)")
+	fs.BoolVar(&opts.Coverage, "coverage", false, "count how often each statement runs; summarize the lines that never ran and list the source with the counts in coverage.txt")
+	fs.StringVar(&opts.LCOV, "lcov", "", "also write the -coverage counts as an LCOV tracefile to this file (implies -coverage)")
+	fs.StringVar(&opts.Lang, "lang", "", "the script's language, js or ts (TypeScript, transpiled before it runs); default by its extension, .ts, .mts and .cts being TypeScript")
+	fs.BoolVar(&opts.Sandbox, "sandbox", false, "only let require() load files inside the script's directory")
+	fs.StringVar(&opts.Inspect, "inspect", "", "serve the run to Chrome DevTools or another CDP client on this port (or host:port), waiting for one to attach and pausing on the first statement")
+	fs.BoolVar(&opts.BreakOnException, "break-on-exception", false, "pause where an exception is thrown, with the variables and call stack there, and report unhandled promise rejections")
+	fs.StringVar(&loopTypes, "loop-types", "", "comma-separated loop kinds to analyze (for, while, do-while); default all")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// `debug-smpl -break 12 app.js` names the script without -script
+	if fs.NArg() > 0 {
+		opts.Script = fs.Arg(0)
+	}
+	if opts.LCOV != "" {
+		opts.Coverage = true
+	}
+	if opts.Replay != "" && (opts.Record != "" || opts.WatchFiles) {
+		return nil, errors.New("-replay steps through a recording without running the script; it can't -record or -watch-files")
+	}
+	if opts.WatchFiles && opts.Script == "-" {
+		return nil, errors.New("-watch-files needs a script file to watch, not stdin")
+	}
+	if scriptArgs != "" {
+		opts.ScriptArgs = strings.Split(scriptArgs, ",")
+	}
+	if env != "" {
+		opts.Env = strings.Split(env, ",")
+	}
+
+	var err error
+	if opts.Indent, err = parseIndent(indent); err != nil {
+		return nil, fmt.Errorf("invalid -indent: %v", err)
+	}
+
+	if opts.CaptureWhen != "" {
+		// injected inline, so keep it on one line to preserve line numbers
+		opts.CaptureWhen = strings.Join(strings.Fields(opts.CaptureWhen), " ")
+		if _, err := goja.Compile("capture-when", "("+opts.CaptureWhen+")", false); err != nil {
+			return nil, fmt.Errorf("invalid -capture-when expression: %v", err)
+		}
+	}
+
+	switch opts.Format = strings.ToLower(opts.Format); opts.Format {
+	case "text":
+	case "markdown", "md":
+		opts.Format = "markdown"
+	case "json", "html", "both":
+	default:
+		return nil, fmt.Errorf("invalid -format %q: expected text, markdown, json, html or both", opts.Format)
+	}
+
+	switch opts.Lang = strings.ToLower(opts.Lang); opts.Lang {
+	case "", "js", "ts":
+	case "typescript":
+		opts.Lang = "ts"
+	case "javascript":
+		opts.Lang = "js"
+	default:
+		return nil, fmt.Errorf("invalid -lang %q: expected js or ts", opts.Lang)
+	}
+
+	if opts.GroupLoopsBy != "" && opts.GroupLoopsBy != "type" {
+		return nil, fmt.Errorf("invalid -group-loops-by %q: the only grouping is \"type\"", opts.GroupLoopsBy)
+	}
+
+	if opts.captureTemplate, err = compileCaptureTemplate(opts.CaptureTemplate); err != nil {
+		return nil, fmt.Errorf("invalid -capture-template: %v", err)
+	}
+
+	if loopDiff != "" {
+		if opts.LoopDiff, err = parseLoopDiff(loopDiff); err != nil {
+			return nil, fmt.Errorf("invalid -loop-diff: %v", err)
+		}
+	}
+
+	if loopTypes != "" {
+		opts.LoopTypes = parseLoopTypes(loopTypes, fs.Output())
+	}
+
+	return opts, nil
+}
+
+func parseLoopTypes(list string, warn io.Writer) map[string]bool {
+	kinds := make(map[string]bool)
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case "for", "while", "do-while":
+			kinds[kind] = true
+		default:
+			fmt.Fprintf(warn, "|!| -loop-types: ignoring unknown loop type %q (expected for, while, do-while)\n", kind)
+		}
+	}
+	return kinds
+}
+
+func (o *Config) addBreaks(list string) error {
+	if o.Breaks == nil {
+		o.Breaks = make(map[int]string)
+	}
+	// a condition can hold commas of its own, so `12:a, b` is one breakpoint
+	if line, condition, ok := strings.Cut(list, ":"); ok {
+		n, err := parseLine(line)
+		if err != nil {
+			return err
+		}
+		o.Breaks[n] = strings.TrimSpace(condition)
+		return nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		n, err := parseLine(part)
+		if err != nil {
+			return err
+		}
+		o.Breaks[n] = ""
+	}
+	return nil
+}
+
+// addLogpoint adds a -logpoint, `line:message`. The message may be quoted.
+func (o *Config) addLogpoint(spec string) error {
+	line, message, ok := strings.Cut(spec, ":")
+	if !ok {
+		return fmt.Errorf("%q is not line:message", spec)
+	}
+	n, err := parseLine(line)
+	if err != nil {
+		return err
+	}
+	message = strings.TrimSpace(message)
+	if unquoted, err := strconv.Unquote(message); err == nil {
+		message = unquoted
+	}
+	if o.Logpoints == nil {
+		o.Logpoints = make(map[int]string)
+	}
+	o.Logpoints[n] = message
+	return nil
+}
+
+func parseLine(text string) (int, error) {
+	line, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || line < 1 {
+		return 0, fmt.Errorf("%q is not a line number", text)
+	}
+	return line, nil
+}
+
+// capture returns the compiled capture template, falling back to the
+// default for a Config not built by ParseFlags.
+func (o *Config) capture() *template.Template {
+	if o.captureTemplate == nil {
+		o.captureTemplate = template.Must(compileCaptureTemplate(defaultCaptureTemplate))
+	}
+	return o.captureTemplate
+}
+
+// LoopDiff selects two iterations of a loop to compare; all are 1-based.
+type LoopDiff struct {
+	Loop, From, To int
+}
+
+func parseLoopDiff(spec string) (*LoopDiff, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%q is not loop:from:to", spec)
+	}
+	var n [3]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("%q: %q is not a positive number", spec, part)
+		}
+		n[i] = v
+	}
+	return &LoopDiff{Loop: n[0], From: n[1], To: n[2]}, nil
+}
+
+// reportFile is the path of a report ("output", "loops") in the -out
+// directory, named for the chosen -format, unless -loops-out moves the
+// loop report. With -format=json every report is part of output.json,
+// and with -format=html of output.html.
+func (o *Config) reportFile(name string) string {
+	if name == "loops" && o.LoopsOut != "" && !o.singleReport() {
+		return o.LoopsOut
+	}
+	switch o.Format {
+	case "markdown":
+		name += ".md"
+	case "json":
+		name = "output.json"
+	case "html":
+		name = "output.html"
+	default:
+		name += ".txt"
+	}
+	return filepath.Join(o.Out, name)
+}
+
+// progress is where a run narrates itself: the instrumented code, the
+// loops it found and the closing summary. -quiet silences it, leaving
+// breakpoints, warnings and errors.
+func (o *Config) progress() io.Writer {
+	if o.Quiet {
+		return io.Discard
+	}
+	return o.Stdout
+}
+
+// singleReport reports whether every report goes into the one file,
+// output.json or output.html.
+func (o *Config) singleReport() bool {
+	return o.Format == "json" || o.Format == "html"
+}
+
+// writesJSON reports whether output.json is written, -format=json or both.
+func (o *Config) writesJSON() bool {
+	return o.Format == "json" || o.Format == "both"
+}
+
+func (o *Config) analyzesLoop(kind string) bool {
+	return o.LoopTypes == nil || o.LoopTypes[kind]
+}
+
+// parseIndent turns an -indent value ("4", "tab") into the literal indent string.
+func parseIndent(spec string) (string, error) {
+	switch strings.ToLower(spec) {
+	case "tab", "\\t", "\t":
+		return "\t", nil
+	}
+
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 || n > 16 {
+		return "", fmt.Errorf("%q is not \"tab\" or a width between 0 and 16", spec)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+const defaultCaptureTemplate = `; debug({{.Name}}, {{.Expr}}, {{.Line}}, {{.Scope}})`
+
+// captureSite is what a capture template is executed with.
+type captureSite struct {
+	Name  string
+	Expr  string
+	Line  int
+	Scope int
+}
+
+// compileCaptureTemplate parses a capture template and checks that it
+// produces valid JS that stays on one line, so line numbers are preserved.
+func compileCaptureTemplate(text string) (*template.Template, error) {
+	t, err := template.New("capture").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var sample strings.Builder
+	if err := t.Execute(&sample, captureSite{Name: `"x"`, Expr: "x", Line: 1}); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(sample.String(), "\r\n") {
+		return nil, fmt.Errorf("%q must not produce line breaks", text)
+	}
+	if _, err := goja.Compile("capture-template", "let x = 1"+sample.String(), false); err != nil {
+		return nil, fmt.Errorf("%q doesn't produce valid JS after a declaration: %v", text, err)
+	}
+	return t, nil
+}
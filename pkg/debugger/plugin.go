@@ -0,0 +1,119 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dop251/goja"
+)
+
+// pluginHooks are the functions a -plugin file can export, called on the
+// events Hooks.Check, Hooks.LoopIteration and Hooks.FunctionCall are:
+//
+//	onVariableAssigned(name, value, line)
+//	onLoopIteration(loop, n)   // loop: { number, type, line, file }
+//	onFunctionCall(frame)      // frame: { name, file, line, column, args }
+//
+// value and args are the script's own values, not copies. A hook that
+// returns a string pauses there as a breakpoint with that label, one that
+// returns another truthy value as one labelled with the plugin's file.
+var pluginHooks = []string{"onVariableAssigned", "onLoopIteration", "onFunctionCall"}
+
+// plugin is a loaded -plugin file: a CommonJS module run in the script's
+// runtime, uninstrumented, before the script.
+type plugin struct {
+	name  string
+	hooks map[string]goja.Callable
+
+	// failed are the hooks that threw, reported the first time they did
+	failed map[string]bool
+}
+
+func loadPlugin(vm *goja.Runtime, path string) (*plugin, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// on one line with the first, so the plugin's lines stay its own
+	wrapper, err := vm.RunScript(path, "(function (exports, require, module) {"+string(src)+"\n})")
+	if err != nil {
+		return nil, err
+	}
+	run, _ := goja.AssertFunction(wrapper)
+	exports, module := vm.NewObject(), vm.NewObject()
+	module.Set("exports", exports)
+	if _, err := run(goja.Undefined(), exports, vm.Get("require"), module); err != nil {
+		return nil, err
+	}
+
+	p := &plugin{name: filepath.Base(path), hooks: make(map[string]goja.Callable), failed: make(map[string]bool)}
+	if exported, ok := module.Get("exports").(*goja.Object); ok {
+		for _, hook := range pluginHooks {
+			if fn, ok := goja.AssertFunction(exported.Get(hook)); ok {
+				p.hooks[hook] = fn
+			}
+		}
+	}
+	if len(p.hooks) == 0 {
+		return nil, errors.New("its module.exports has none of onVariableAssigned, onLoopIteration and onFunctionCall")
+	}
+	return p, nil
+}
+
+// hasPluginHook reports whether a plugin would be called on hook, so the
+// arguments are only built for one.
+func (s *RunState) hasPluginHook(hook string) bool {
+	if s.inPlugin {
+		return false
+	}
+	for _, p := range s.plugins {
+		if p.hooks[hook] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// runPlugins calls hook of every plugin that has it with args, and returns
+// the label of the first pause one asked for, "" for none. A hook that
+// throws is reported once and otherwise ignored.
+func (s *RunState) runPlugins(vm *goja.Runtime, hook string, opts *Config, args ...any) string {
+	if s.inPlugin {
+		return ""
+	}
+	s.inPlugin = true
+	defer func() { s.inPlugin = false }()
+
+	values := make([]goja.Value, len(args))
+	for i, arg := range args {
+		values[i] = vm.ToValue(arg)
+	}
+	label := ""
+	for _, p := range s.plugins {
+		fn := p.hooks[hook]
+		if fn == nil {
+			continue
+		}
+		result, err := fn(goja.Undefined(), values...)
+		var interrupted *goja.InterruptedError
+		switch {
+		case errors.As(err, &interrupted):
+			// the runtime stays interrupted, and stops the script as soon
+			// as it runs on
+			return ""
+		case err != nil:
+			if !p.failed[hook] {
+				p.failed[hook] = true
+				fmt.Fprintf(opts.Stdout, "\n|!| Plugin %s: %s failed, ignoring it: %v\n", p.name, hook, err)
+			}
+		case label != "" || result == nil || !result.ToBoolean():
+		case goja.IsString(result):
+			label = result.String()
+		default:
+			label = "plugin " + p.name
+		}
+	}
+	return label
+}
@@ -0,0 +1,179 @@
+package debugger
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// hold stops the clocks while the script is paused at a prompt: the
+// -timeout and the one loops and functions are timed with. The returned
+// func starts them again.
+func (s *RunState) hold() func() {
+	release := s.guard.hold()
+	held := time.Now()
+	return func() {
+		s.paused += time.Since(held)
+		release()
+	}
+}
+
+// clock is how long the run has been going, not counting pauses.
+func (s *RunState) clock() time.Duration {
+	return time.Since(s.Started) - s.paused
+}
+
+type profileFrame struct {
+	name     string
+	started  time.Duration
+	children time.Duration
+}
+
+// FunctionProfile is the time -profile measured in one function: Total
+// from its calls' entries to their exits, Self without the time spent in
+// the instrumented functions they called. A recursive call only counts
+// towards Total once, with the outermost.
+type FunctionProfile struct {
+	Name        string
+	Calls       int
+	Total, Self time.Duration
+}
+
+// profiler turns the __enter/__exit hooks into self time per call stack,
+// for -flamegraph, and per function, for -profile. Each folded line is
+// `outer;inner microseconds`.
+type profiler struct {
+	clock     func() time.Duration
+	stack     []profileFrame
+	folded    map[string]time.Duration
+	functions map[string]*FunctionProfile
+
+	// started and elapsed bound the script's run, what the shares of the
+	// hot spots are of
+	started, elapsed time.Duration
+}
+
+func newProfiler(clock func() time.Duration) *profiler {
+	return &profiler{clock: clock, folded: make(map[string]time.Duration), functions: make(map[string]*FunctionProfile)}
+}
+
+func (p *profiler) enter(name string) {
+	p.stack = append(p.stack, profileFrame{name: name, started: p.clock()})
+	fn := p.functions[name]
+	if fn == nil {
+		fn = &FunctionProfile{Name: name}
+		p.functions[name] = fn
+	}
+	fn.Calls++
+}
+
+func (p *profiler) exit() {
+	n := len(p.stack)
+	if n == 0 {
+		return
+	}
+	frame := p.stack[n-1]
+	total := p.clock() - frame.started
+
+	names := make([]string, n)
+	for i, f := range p.stack {
+		names[i] = f.name
+	}
+	p.folded[strings.Join(names, ";")] += total - frame.children
+	fn := p.functions[frame.name]
+	fn.Self += total - frame.children
+	if !slices.Contains(names[:n-1], frame.name) {
+		fn.Total += total
+	}
+
+	p.stack = p.stack[:n-1]
+	if n > 1 {
+		p.stack[n-2].children += total
+	}
+}
+
+// hotSpots are the functions by self time, the most first.
+func (p *profiler) hotSpots() []*FunctionProfile {
+	spots := make([]*FunctionProfile, 0, len(p.functions))
+	for _, fn := range p.functions {
+		spots = append(spots, fn)
+	}
+	slices.SortFunc(spots, func(a, b *FunctionProfile) int {
+		return cmp.Or(cmp.Compare(b.Self, a.Self), cmp.Compare(a.Name, b.Name))
+	})
+	return spots
+}
+
+// share is d with its percentage of the run, `12.5ms (40.1%)`.
+func (p *profiler) share(d time.Duration) string {
+	if p.elapsed <= 0 {
+		return d.Round(time.Microsecond).String()
+	}
+	return fmt.Sprintf("%v (%.1f%%)", d.Round(time.Microsecond), 100*float64(d)/float64(p.elapsed))
+}
+
+// profileTable is the -profile hot-spot table: the functions by self
+// time, then the loops by time, at most limit of each (0 for all).
+func profileTable(state *RunState, limit int) []string {
+	p := state.Profile
+	lines := []string{fmt.Sprintf("ran %v, not counting pauses", p.elapsed.Round(time.Microsecond))}
+	spots := p.hotSpots()
+	if limit > 0 && len(spots) > limit {
+		spots = spots[:limit]
+	}
+	if len(spots) > 0 {
+		lines = append(lines, fmt.Sprintf("%-20s  %-20s  %8s  %s", "self", "total", "calls", "function"))
+		for _, fn := range spots {
+			lines = append(lines, fmt.Sprintf("%-20s  %-20s  %8d  %s", p.share(fn.Self), p.share(fn.Total), fn.Calls, fn.Name))
+		}
+	}
+
+	loops := make([]int, 0, len(state.Loops))
+	for i, loop := range state.Loops {
+		if loop.Iterations > 0 {
+			loops = append(loops, i)
+		}
+	}
+	slices.SortStableFunc(loops, func(a, b int) int { return cmp.Compare(state.Loops[b].Duration, state.Loops[a].Duration) })
+	if limit > 0 && len(loops) > limit {
+		loops = loops[:limit]
+	}
+	if len(loops) > 0 {
+		lines = append(lines, fmt.Sprintf("%-20s  %10s  %s", "time", "iterations", "loop"))
+		for _, i := range loops {
+			loop := state.Loops[i]
+			lines = append(lines, fmt.Sprintf("%-20s  %10d  loop %d (%s at %s)", p.share(loop.Duration), loop.Iterations, i+1, loop.Type, loop.where()))
+		}
+	}
+	return lines
+}
+
+// foldedPath is where the folded stacks go: -flamegraph's file, or
+// profile.folded in -out for -profile alone.
+func (o *Config) foldedPath() string {
+	if o.Flamegraph != "" {
+		return o.Flamegraph
+	}
+	return filepath.Join(o.Out, "profile.folded")
+}
+
+// writeFolded writes the profile in the folded format read by
+// flamegraph.pl, speedscope and similar tools.
+func (p *profiler) writeFolded(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, stack := range sortedKeys(p.folded) {
+		fmt.Fprintf(writer, "%s %d\n", stack, p.folded[stack].Microseconds())
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,482 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// recordingVersion is the format of the files -record writes; -replay
+// reads no other.
+const recordingVersion = 1
+
+// Recording is a run -record saved: the script's source and every event
+// of the run in the order it happened, all -replay needs to step through
+// the run again without running anything.
+type Recording struct {
+	Version int
+	Script  string
+	Started time.Time
+	Source  []string
+
+	// Modules holds the source of each instrumented module, by its name.
+	Modules map[string][]string `json:",omitempty"`
+
+	Events []RecordedEvent
+}
+
+// RecordedEvent is one event of a recorded run. Kind is one of
+//
+//	"assign"      Name was captured with Value
+//	"loop"        loop number Loop started iteration Iteration
+//	"breakpoint"  the breakpoint Label was hit, with Stack and the
+//	              script's Variables read again there
+//	"console"     the script printed Text, with Label "warn" or "error" for those
+//	"crash"       the script stopped with the error Text, thrown at Stack
+//
+// Line is where it happened in File, the module ("" for the script), and
+// 0 when that isn't known.
+type RecordedEvent struct {
+	Seq  int
+	Kind string
+
+	// Elapsed is the time since the run started, not counting pauses.
+	Elapsed time.Duration
+
+	Line int    `json:",omitempty"`
+	File string `json:",omitempty"`
+
+	Name  string         `json:",omitempty"`
+	Value *RecordedValue `json:",omitempty"`
+
+	Loop      int                      `json:",omitempty"`
+	Iteration int                      `json:",omitempty"`
+	Label     string                   `json:",omitempty"`
+	Text      string                   `json:",omitempty"`
+	Stack     []Frame                  `json:",omitempty"`
+	Variables map[string]RecordedValue `json:",omitempty"`
+}
+
+// RecordedValue is a captured value as the debugger shows it, Text, and
+// the same as JSON, Data, which `print` walks.
+type RecordedValue struct {
+	Text string
+	Data any `json:",omitempty"`
+}
+
+func recordedValue(v any) RecordedValue {
+	return RecordedValue{Text: renderValue(v, 0), Data: jsonValue(v)}
+}
+
+// where is the event's line, with the module in front for one in a module.
+func (e RecordedEvent) where() string {
+	where := fmt.Sprintf("line %d", e.Line)
+	if e.Line == 0 {
+		where = "line ?"
+	}
+	if e.File != "" {
+		where = e.File + " " + where
+	}
+	return where
+}
+
+// render is the event as -replay shows it, after its marker.
+func (e RecordedEvent) render(limit int) string {
+	text := ""
+	switch e.Kind {
+	case "assign":
+		text = fmt.Sprintf("%s = %s", e.Name, cutRunes(e.Value.Text, limit))
+	case "loop":
+		text = fmt.Sprintf("loop %d, iteration %d", e.Loop, e.Iteration)
+	case "breakpoint":
+		text = "breakpoint " + e.Label
+	case "console":
+		text = "console: " + e.Text
+		if e.Label != "" {
+			text = "console." + e.Label + ": " + e.Text
+		}
+	case "crash":
+		text = "crashed: " + e.Text
+	}
+	return fmt.Sprintf("#%d +%s %s: %s", e.Seq, e.Elapsed.Round(time.Microsecond), e.where(), text)
+}
+
+// tape adds e to the -record recording, numbered and timed.
+func (s *RunState) tape(e RecordedEvent) {
+	if s.Recording == nil {
+		return
+	}
+	e.Seq, e.Elapsed = len(s.Recording.Events)+1, s.clock()
+	s.Recording.Events = append(s.Recording.Events, e)
+}
+
+// tapePrinter prints the console output as the console module does by
+// default, to stdout for log and to stderr for warn and error, and records
+// it with the line it was printed from.
+type tapePrinter struct {
+	vm     *goja.Runtime
+	state  *RunState
+	opts   *Config
+	stdout *log.Logger
+}
+
+func newTapePrinter(vm *goja.Runtime, state *RunState, opts *Config) tapePrinter {
+	return tapePrinter{vm: vm, state: state, opts: opts, stdout: log.New(os.Stdout, "", log.LstdFlags)}
+}
+
+func (p tapePrinter) Log(s string) {
+	p.stdout.Print(s)
+	p.record("", s)
+}
+
+func (p tapePrinter) Warn(s string) {
+	log.Print(s)
+	p.record("warn", s)
+}
+
+func (p tapePrinter) Error(s string) {
+	log.Print(s)
+	p.record("error", s)
+}
+
+func (p tapePrinter) record(level, text string) {
+	e := RecordedEvent{Kind: "console", Label: level, Text: text}
+	if stack := callStack(p.vm, p.state, p.opts); len(stack) > 0 {
+		e.Line = stack[0].Line
+		if stack[0].File != p.opts.Script {
+			e.File = p.state.moduleName(stack[0].File)
+		}
+	}
+	p.state.tape(e)
+}
+
+// moduleName is the name of the instrumented module the registry calls
+// file, or file itself for one that isn't.
+func (s *RunState) moduleName(file string) string {
+	for _, m := range s.Modules {
+		if m.filename == file && m.Name != "" {
+			return m.Name
+		}
+	}
+	return file
+}
+
+// writeRecording writes the -record file, the run's events with the
+// sources they point into.
+func writeRecording(path string, state *RunState, opts *Config) {
+	rec := state.Recording
+	rec.Source = state.Source
+	for _, m := range state.Modules {
+		if m.Source != nil {
+			if rec.Modules == nil {
+				rec.Modules = make(map[string][]string)
+			}
+			rec.Modules[m.Name] = m.Source
+		}
+	}
+
+	file, err := os.Create(path)
+	if err == nil {
+		writer := bufio.NewWriter(file)
+		err = json.NewEncoder(writer).Encode(rec)
+		if flushErr := writer.Flush(); err == nil {
+			err = flushErr
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not write %s: %v\n", path, err)
+		return
+	}
+	fmt.Fprintf(opts.progress(), "\n Recorded %d events to %s, step through them with -replay %s\n", len(rec.Events), path, path)
+}
+
+func readRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("%s is not a -record file: %v", path, err)
+	}
+	if rec.Version != recordingVersion {
+		return nil, fmt.Errorf("%s is a version %d recording; this debugger reads version %d", path, rec.Version, recordingVersion)
+	}
+	return &rec, nil
+}
+
+// replayer is the position of a -replay in its recording: at is the index
+// of the current event, -1 before the first.
+type replayer struct {
+	rec  *Recording
+	at   int
+	opts *Config
+}
+
+// Replay steps through the run -record saved at path, forward and back,
+// reading commands from the Config's Stdin as the breakpoint prompt does.
+// Nothing is run: the variables at any point are the values recorded up
+// to it.
+func (s *Session) Replay(path string) error {
+	opts := s.Config.withConsole()
+	rec, err := readRecording(path)
+	if err != nil {
+		return err
+	}
+	r := &replayer{rec: rec, at: -1, opts: opts}
+
+	hits := 0
+	for _, e := range rec.Events {
+		if e.Kind == "breakpoint" {
+			hits++
+		}
+	}
+	fmt.Fprintf(opts.Stdout, "|~| Replaying %s as recorded %s: %d events, %d breakpoint hits\n", rec.Script, rec.Started.Format(time.DateTime), len(rec.Events), hits)
+	fmt.Fprintf(opts.Stdout, "\n|>  ENTER / n step forward, b step back, c run to the next breakpoint, rc back to the previous one, q quit (or: vars, print <path>, history <name>, console, list, goto <n>, help)... ")
+
+	stdin := bufio.NewReader(opts.Stdin)
+	for {
+		input, err := stdin.ReadString('\n')
+		command := strings.TrimSpace(input)
+		if err != nil && command == "" {
+			return nil
+		}
+		verb, arg, _ := strings.Cut(command, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch {
+		case command == "" || verb == "n" || verb == "next":
+			r.step(stepCount(arg))
+		case verb == "b" || verb == "back":
+			r.step(-stepCount(arg))
+		case command == "c" || command == "continue":
+			r.seek(1)
+		case command == "rc":
+			r.seek(-1)
+		case verb == "goto" && arg != "":
+			if seq, err := strconv.Atoi(arg); err != nil || seq < 1 || seq > len(rec.Events) {
+				fmt.Fprintf(opts.Stdout, "  there is no event %s, the recording has 1 to %d\n", arg, len(rec.Events))
+			} else {
+				r.at = seq - 1
+				r.show()
+			}
+		case command == "vars":
+			r.vars()
+		case (verb == "print" || verb == "p") && arg != "":
+			r.print(arg)
+		case verb == "history" && arg != "":
+			r.history(arg)
+		case command == "console":
+			r.console()
+		case command == "list":
+			r.list()
+		case command == "q" || command == "quit":
+			return nil
+		case command == "help" || command == "?":
+			fmt.Fprintln(opts.Stdout, "  ENTER / n [count] step forward, b [count] step back, c run to the next breakpoint, rc back to the previous one, goto <n> jump to event n, vars (the variables at this point), print <path> (e.g. print obj.a.b), history <name>, console (the output so far), list (the events around this one), q quit")
+		default:
+			fmt.Fprintf(opts.Stdout, "  unknown command %q; nothing runs in a replay, try help\n", command)
+		}
+
+		if err != nil {
+			return nil
+		}
+		fmt.Fprint(opts.Stdout, "|> ")
+	}
+}
+
+// stepCount is the number a step command was given, 1 without one.
+func stepCount(arg string) int {
+	if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// step moves n events forward, or back for a negative n, stopping at the
+// ends of the recording.
+func (r *replayer) step(n int) {
+	to := min(max(r.at+n, -1), len(r.rec.Events)-1)
+	switch {
+	case to == r.at && n > 0:
+		fmt.Fprintln(r.opts.Stdout, "  end of the recording")
+	case to == r.at:
+		fmt.Fprintln(r.opts.Stdout, "  start of the recording")
+	default:
+		r.at = to
+		r.show()
+	}
+}
+
+// seek moves to the next breakpoint hit or crash in direction dir, or to
+// the end of the recording there when there is none.
+func (r *replayer) seek(dir int) {
+	to := r.at + dir
+	for ; to >= 0 && to < len(r.rec.Events); to += dir {
+		if kind := r.rec.Events[to].Kind; kind == "breakpoint" || kind == "crash" {
+			break
+		}
+	}
+	r.step(to - r.at)
+}
+
+// show prints the current event, with the source around its line.
+func (r *replayer) show() {
+	opts := r.opts
+	if r.at < 0 {
+		fmt.Fprintln(opts.Stdout, "\n|~| Before the first event")
+		return
+	}
+	e := r.rec.Events[r.at]
+	marker := "|~|"
+	switch e.Kind {
+	case "breakpoint":
+		marker = "|_|"
+	case "crash":
+		marker = "|!|"
+	}
+	fmt.Fprintf(opts.Stdout, "\n%s %s\n", marker, e.render(opts.MaxValueLen))
+	for _, f := range e.Stack {
+		fmt.Fprintf(opts.Stdout, "%sat %s\n", opts.Indent, f)
+	}
+	source := r.rec.Source
+	if e.File != "" {
+		source = r.rec.Modules[e.File]
+	}
+	if e.Line > 0 && e.Line <= len(source) {
+		fmt.Fprintln(opts.Stdout)
+		printSourceContext(source, e.Line, opts)
+	}
+}
+
+// variables is the latest value of every variable up to the current
+// event, a module's named with the module in front: the one it was last
+// assigned, or read at a breakpoint since.
+func (r *replayer) variables() map[string]RecordedValue {
+	vars := make(map[string]RecordedValue)
+	for _, e := range r.rec.Events[:r.at+1] {
+		switch {
+		case e.Kind == "assign" && e.Value != nil:
+			vars[e.qualified()] = *e.Value
+		case e.Kind == "breakpoint":
+			maps.Copy(vars, e.Variables)
+		}
+	}
+	return vars
+}
+
+// qualified is the name of the variable an assignment is to, with the
+// module in front for one in a module.
+func (e RecordedEvent) qualified() string {
+	if e.File != "" {
+		return e.File + ":" + e.Name
+	}
+	return e.Name
+}
+
+func (r *replayer) vars() {
+	vars := r.variables()
+	if len(vars) == 0 {
+		fmt.Fprintln(r.opts.Stdout, "  no variables captured yet")
+		return
+	}
+	for _, name := range sortedKeys(vars) {
+		fmt.Fprintf(r.opts.Stdout, "%s%s = %s\n", r.opts.Indent, name, vars[name].Text)
+	}
+}
+
+// print shows the value at a property path, as it was at the current
+// event, by walking the recorded JSON of its variable.
+func (r *replayer) print(path string) {
+	root := identifierRegex.FindString(path)
+	value, captured := r.variables()[root]
+	if root == "" || !captured {
+		fmt.Fprintf(r.opts.Stdout, "  %s was not captured by this point\n", path)
+		return
+	}
+	if root == path {
+		fmt.Fprintf(r.opts.Stdout, "  %s = %s\n", path, value.Text)
+		return
+	}
+
+	current, walked, rest := value.Data, root, path[len(root):]
+	for rest != "" {
+		m := pathSegmentRegex.FindStringSubmatch(rest)
+		if m == nil {
+			fmt.Fprintf(r.opts.Stdout, "  can't parse %q in %s\n", rest, path)
+			return
+		}
+		switch node := current.(type) {
+		case map[string]any:
+			current = node[m[1]+m[3]+m[4]]
+		case []any:
+			i, err := strconv.Atoi(m[2])
+			if err != nil || i >= len(node) {
+				current = nil
+			} else {
+				current = node[i]
+			}
+		default:
+			fmt.Fprintf(r.opts.Stdout, "  %s: undefined (%s is %s)\n", path, walked, renderValue(current, r.opts.MaxValueLen))
+			return
+		}
+		walked += m[0]
+		rest = rest[len(m[0]):]
+	}
+	fmt.Fprintf(r.opts.Stdout, "  %s = %s\n", path, inspectValue(current, "  ", r.opts))
+}
+
+// history lists the values name was captured with up to the current event.
+func (r *replayer) history(name string) {
+	found := false
+	for _, e := range r.rec.Events[:r.at+1] {
+		if e.Kind == "assign" && e.qualified() == name {
+			fmt.Fprintf(r.opts.Stdout, "%s%s\n", r.opts.Indent, e.render(r.opts.MaxValueLen))
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintf(r.opts.Stdout, "  no captures of %s by this point\n", name)
+	}
+}
+
+// console prints what the script printed up to the current event.
+func (r *replayer) console() {
+	found := false
+	for _, e := range r.rec.Events[:r.at+1] {
+		if e.Kind == "console" {
+			fmt.Fprintf(r.opts.Stdout, "%s%s\n", r.opts.Indent, e.Text)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintln(r.opts.Stdout, "  nothing printed by this point")
+	}
+}
+
+// list prints the events around the current one.
+func (r *replayer) list() {
+	if len(r.rec.Events) == 0 {
+		fmt.Fprintln(r.opts.Stdout, "  the recording has no events")
+		return
+	}
+	from, to := max(r.at-5, 0), min(r.at+6, len(r.rec.Events))
+	for i := from; i < to; i++ {
+		marker := "  "
+		if i == r.at {
+			marker = "> "
+		}
+		fmt.Fprintf(r.opts.Stdout, "%s%s%s\n", r.opts.Indent, marker, r.rec.Events[i].render(r.opts.MaxValueLen))
+	}
+}
@@ -0,0 +1,621 @@
+package debugger
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// typedArray is how Uint8Array, Float64Array etc. are captured: goja
+// exports them as bare Go slices, which print without their type.
+type typedArray struct {
+	Type   string
+	Values []any
+}
+
+func (t typedArray) String() string {
+	return renderValue(t, 0)
+}
+
+func (t typedArray) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonValue(t.Values))
+}
+
+// getterValue marks a property computed by an accessor.
+type getterValue struct {
+	Value any
+}
+
+func (g getterValue) String() string {
+	return renderValue(g, 0)
+}
+
+func (g getterValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonValue(g.Value))
+}
+
+// getterError stands in for an accessor that threw while being read.
+type getterError struct{}
+
+func (getterError) String() string {
+	return "<getter error>"
+}
+
+func (e getterError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+// undefinedValue is how undefined is captured, null being nil.
+type undefinedValue struct{}
+
+func (undefinedValue) String() string {
+	return "undefined"
+}
+
+func (undefinedValue) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+// jsLabel stands in for a value shown by a label rather than walked:
+// `[Function: add]`, `[TypeError: boom]`, `/a+/g`, `Promise { 1 }`, and
+// `[Circular]` or `[Object]` where a capture stopped. Type is its typeof.
+type jsLabel struct {
+	Type string
+	Text string
+}
+
+func (l jsLabel) String() string {
+	return l.Text
+}
+
+func (l jsLabel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Text)
+}
+
+// jsInstance is an object made by a constructor other than Object, a
+// class instance, captured with its own properties.
+type jsInstance struct {
+	Class  string
+	Fields map[string]any
+}
+
+func (o jsInstance) String() string {
+	return renderValue(o, 0)
+}
+
+func (o jsInstance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonValue(o.Fields))
+}
+
+// jsMap is a captured Map, its entries in insertion order, and jsSet a
+// captured Set.
+type jsMap struct {
+	Keys, Values []any
+}
+
+type jsSet struct {
+	Values []any
+}
+
+func (m jsMap) String() string {
+	return renderValue(m, 0)
+}
+
+func (m jsMap) MarshalJSON() ([]byte, error) {
+	entries := make([][2]any, len(m.Keys))
+	for i := range m.Keys {
+		entries[i] = [2]any{jsonValue(m.Keys[i]), jsonValue(m.Values[i])}
+	}
+	return json.Marshal(entries)
+}
+
+func (s jsSet) String() string {
+	return renderValue(s, 0)
+}
+
+func (s jsSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonValue(s.Values))
+}
+
+// maxCaptureDepth bounds how far captureValue walks nested objects.
+const maxCaptureDepth = 8
+
+var (
+	plainObjectType = reflect.TypeOf(map[string]any{})
+	promiseType     = reflect.TypeOf((*goja.Promise)(nil))
+)
+
+// captureValue converts a JS value into what gets stored for reporting.
+func captureValue(vm *goja.Runtime, v goja.Value) any {
+	return captureNested(vm, v, map[*goja.Object]bool{}, 0)
+}
+
+func captureNested(vm *goja.Runtime, v goja.Value, visiting map[*goja.Object]bool, depth int) any {
+	if v == nil || goja.IsUndefined(v) {
+		return undefinedValue{}
+	}
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		return v.Export()
+	}
+	if t, ok := asTypedArray(obj); ok {
+		return t
+	}
+	if label, ok := labelFor(vm, obj); ok {
+		return label
+	}
+
+	class := obj.ClassName()
+	for _, c := range []string{"Map", "Set"} {
+		// goja gives them the class name Object
+		if ctor, ok := vm.Get(c).(*goja.Object); ok && vm.InstanceOf(obj, ctor) {
+			class = c
+		}
+	}
+	if class != "Array" && class != "Map" && class != "Set" && obj.ExportType() != plainObjectType {
+		switch exported := v.Export().(type) {
+		case string, bool, int64, float64, *big.Int, time.Time:
+			// new String("a"), a Date
+			return exported
+		default:
+			return hostLabel(exported)
+		}
+	}
+	if visiting[obj] {
+		return jsLabel{Type: "object", Text: "[Circular]"}
+	}
+	if depth >= maxCaptureDepth {
+		if class == "Object" {
+			return jsLabel{Type: "object", Text: "[Object]"}
+		}
+		return jsLabel{Type: "object", Text: "[" + class + "]"}
+	}
+	visiting[obj] = true
+	defer delete(visiting, obj)
+
+	switch class {
+	case "Array":
+		items := make([]any, obj.Get("length").ToInteger())
+		for i := range items {
+			items[i] = captureNested(vm, obj.Get(strconv.Itoa(i)), visiting, depth+1)
+		}
+		return items
+	case "Map", "Set":
+		// Array.from gives the entries, as [key, value] for a Map
+		from, _ := goja.AssertFunction(vm.Get("Array").ToObject(vm).Get("from"))
+		entries, err := from(goja.Undefined(), obj)
+		if err != nil {
+			return v.Export()
+		}
+		list := entries.ToObject(vm)
+		n := int(list.Get("length").ToInteger())
+		if class == "Set" {
+			set := jsSet{Values: make([]any, n)}
+			for i := range set.Values {
+				set.Values[i] = captureNested(vm, list.Get(strconv.Itoa(i)), visiting, depth+1)
+			}
+			return set
+		}
+		m := jsMap{Keys: make([]any, n), Values: make([]any, n)}
+		for i := range n {
+			entry := list.Get(strconv.Itoa(i)).ToObject(vm)
+			m.Keys[i] = captureNested(vm, entry.Get("0"), visiting, depth+1)
+			m.Values[i] = captureNested(vm, entry.Get("1"), visiting, depth+1)
+		}
+		return m
+	}
+
+	fields := captureFields(vm, obj, visiting, depth)
+	if ctor, ok := obj.Get("constructor").(*goja.Object); ok {
+		if name := ctor.Get("name"); name != nil && name.String() != "Object" && name.String() != "" {
+			return jsInstance{Class: name.String(), Fields: fields}
+		}
+	}
+	return fields
+}
+
+// captureFields walks the own properties of obj ourselves so getters are
+// invoked deliberately, and one that throws doesn't abort the capture.
+func captureFields(vm *goja.Runtime, obj *goja.Object, visiting map[*goja.Object]bool, depth int) map[string]any {
+	describe, _ := goja.AssertFunction(vm.Get("Object").ToObject(vm).Get("getOwnPropertyDescriptor"))
+	out := make(map[string]any)
+	for _, key := range obj.Keys() {
+		var desc goja.Value
+		if describe != nil {
+			desc, _ = describe(goja.Undefined(), obj, vm.ToValue(key))
+		}
+		isGetter := false
+		if d, ok := desc.(*goja.Object); ok {
+			getter := d.Get("get")
+			isGetter = getter != nil && !goja.IsUndefined(getter)
+		}
+
+		var value goja.Value
+		read := func() { value = obj.Get(key) }
+		if isGetter {
+			read = func() {
+				defer readingGetter(vm)()
+				value = obj.Get(key)
+			}
+		}
+		if ex := vm.Try(read); ex != nil {
+			out[key] = getterError{}
+			continue
+		}
+
+		captured := captureNested(vm, value, visiting, depth+1)
+		if isGetter {
+			captured = getterValue{Value: captured}
+		}
+		out[key] = captured
+	}
+	return out
+}
+
+// gettersReading counts, per runtime, the getters captureFields is in the
+// middle of. The hooks in a getter's body stand down meanwhile (setHook):
+// a capture would otherwise run the getter's own captures, which capture
+// the object again, and the read would show up as a call in -trace-calls,
+// the call stats and the stack depth.
+var (
+	gettersReading   = map[*goja.Runtime]int{}
+	gettersReadingMu sync.Mutex
+)
+
+// readingGetter notes that a getter of vm is being read, until the
+// function it returns is called.
+func readingGetter(vm *goja.Runtime) func() {
+	gettersReadingMu.Lock()
+	gettersReading[vm]++
+	gettersReadingMu.Unlock()
+	return func() {
+		gettersReadingMu.Lock()
+		if gettersReading[vm]--; gettersReading[vm] == 0 {
+			delete(gettersReading, vm)
+		}
+		gettersReadingMu.Unlock()
+	}
+}
+
+// inGetter reports whether vm is running a getter for captureFields.
+func inGetter(vm *goja.Runtime) bool {
+	gettersReadingMu.Lock()
+	defer gettersReadingMu.Unlock()
+	return gettersReading[vm] > 0
+}
+
+// labelFor is the label for functions, errors, regular expressions and
+// promises, which are shown as what they are rather than walked.
+func labelFor(vm *goja.Runtime, obj *goja.Object) (jsLabel, bool) {
+	if _, callable := goja.AssertFunction(obj); callable {
+		name := ""
+		if n := obj.Get("name"); n != nil {
+			name = n.String()
+		}
+		switch {
+		case strings.HasPrefix(obj.String(), "class"):
+			return jsLabel{Type: "function", Text: "[class " + cmp.Or(name, "(anonymous)") + "]"}, true
+		case name == "":
+			return jsLabel{Type: "function", Text: "[Function (anonymous)]"}, true
+		}
+		return jsLabel{Type: "function", Text: "[Function: " + name + "]"}, true
+	}
+
+	switch obj.ClassName() {
+	case "Error":
+		return jsLabel{Type: "object", Text: "[" + obj.String() + "]"}, true
+	case "RegExp":
+		return jsLabel{Type: "object", Text: obj.String()}, true
+	}
+	// only a promise is exported: a plain object's export calls its getters
+	if obj.ExportType() != promiseType {
+		return jsLabel{}, false
+	}
+	if p, ok := obj.Export().(*goja.Promise); ok {
+		switch p.State() {
+		case goja.PromiseStatePending:
+			return jsLabel{Type: "object", Text: "Promise { <pending> }"}, true
+		case goja.PromiseStateRejected:
+			return jsLabel{Type: "object", Text: "Promise { <rejected> " + renderValue(captureValue(vm, p.Result()), 0) + " }"}, true
+		}
+		return jsLabel{Type: "object", Text: "Promise { " + renderValue(captureValue(vm, p.Result()), 0) + " }"}, true
+	}
+	return jsLabel{}, false
+}
+
+// hostLabel stands in for a Go value the runtime handed the script, such
+// as the handle setTimeout returns, which has no JS properties to show:
+// `[Timeout]`, or `[object]` for a type without a name.
+func hostLabel(exported any) jsLabel {
+	t := reflect.TypeOf(exported)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := "object"
+	if t != nil && t.Name() != "" {
+		name = t.Name()
+	}
+	if name == "Timer" || name == "Interval" {
+		// what Node calls both
+		name = "Timeout"
+	}
+	return jsLabel{Type: "object", Text: "[" + name + "]"}
+}
+
+func asTypedArray(obj *goja.Object) (typedArray, bool) {
+	exportType := obj.ExportType()
+	if exportType == nil || exportType.Kind() != reflect.Slice {
+		return typedArray{}, false
+	}
+	switch exportType.Elem().Kind() {
+	case reflect.Int8, reflect.Uint8, reflect.Int16, reflect.Uint16, reflect.Int32, reflect.Uint32,
+		reflect.Int64, reflect.Uint64, reflect.Float32, reflect.Float64:
+	default:
+		return typedArray{}, false
+	}
+
+	slice := reflect.ValueOf(obj.Export())
+	t := typedArray{Values: make([]any, slice.Len())}
+	for i := range t.Values {
+		t.Values[i] = slice.Index(i).Interface()
+	}
+	if ctor, ok := obj.Get("constructor").(*goja.Object); ok {
+		t.Type = ctor.Get("name").String()
+	}
+	return t, true
+}
+
+const (
+	// inspectWidth is how wide inspectValue lets a value get before it
+	// breaks it over several lines
+	inspectWidth = 72
+
+	// maxArrayItems is how many elements of an array, Set or Map are
+	// shown before the rest are counted
+	maxArrayItems = 100
+)
+
+// renderValue formats a captured value on one line the way Node's
+// util.inspect does, `{ name: 'ann', tags: [ 1, 2 ] }`, cutting it to
+// limit runes (0 = no limit). A string on its own is left unquoted.
+func renderValue(v any, limit int) string {
+	return cutRunes(printer{depth: -1}.format(v, 0), limit)
+}
+
+// inspectLine is renderValue expanding nested objects only -inspect-depth
+// levels deep, `{ user: { address: [Object] } }`, for the listings of
+// variables.
+func inspectLine(v any, opts *Config) string {
+	return cutRunes(printer{depth: opts.InspectDepth}.format(v, 0), opts.MaxValueLen)
+}
+
+// inspectValue is inspectLine for a value shown on its own, broken over
+// indented lines once it is wider than inspectWidth. Lines after the first
+// start with margin, and strings are cut rather than the whole text.
+func inspectValue(v any, margin string, opts *Config) string {
+	p := printer{depth: opts.InspectDepth, width: inspectWidth, margin: margin, indent: opts.Indent, maxString: opts.MaxValueLen}
+	if p.indent == "" {
+		p.indent = "  "
+	}
+	return p.format(v, 0)
+}
+
+// fitValue is text, an inspectLine rendering of value or its history, as
+// long as it fits on a line, and value alone per inspectValue otherwise.
+func fitValue(text string, value any, margin string, opts *Config) string {
+	if utf8.RuneCountInString(text) <= inspectWidth {
+		return text
+	}
+	return inspectValue(value, margin, opts)
+}
+
+func cutRunes(text string, limit int) string {
+	if limit > 0 && utf8.RuneCountInString(text) > limit {
+		runes := []rune(text)
+		text = string(runes[:limit]) + "…"
+	}
+	return text
+}
+
+// printer renders captured values for renderValue and inspectValue.
+type printer struct {
+	depth     int // levels of nested objects expanded, -1 = all
+	width     int // 0 keeps everything on one line
+	margin    string
+	indent    string
+	maxString int // runes of a string shown, 0 = all
+}
+
+var plainKeyRegex = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+func (p printer) format(v any, level int) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if level == 0 {
+			return t
+		}
+		return p.quote(t)
+	case float64:
+		return formatNumber(t)
+	case *big.Int:
+		return t.String() + "n"
+	case time.Time:
+		return t.UTC().Format("2006-01-02T15:04:05.000Z")
+	case getterValue:
+		return "[Getter: " + p.format(t.Value, max(level, 1)) + "]"
+	case []any:
+		if p.folded(level) {
+			return "[Array]"
+		}
+		return p.group("", "[", "]", p.items(t, level), level)
+	case typedArray:
+		if p.folded(level) {
+			return "[" + t.Type + "]"
+		}
+		return p.group(fmt.Sprintf("%s(%d) ", t.Type, len(t.Values)), "[", "]", p.items(t.Values, level), level)
+	case map[string]any:
+		if p.folded(level) {
+			return "[Object]"
+		}
+		return p.group("", "{", "}", p.fields(t, level), level)
+	case jsInstance:
+		if p.folded(level) {
+			return "[" + t.Class + "]"
+		}
+		return p.group(t.Class+" ", "{", "}", p.fields(t.Fields, level), level)
+	case jsSet:
+		if p.folded(level) {
+			return "[Set]"
+		}
+		return p.group(fmt.Sprintf("Set(%d) ", len(t.Values)), "{", "}", p.items(t.Values, level), level)
+	case jsMap:
+		if p.folded(level) {
+			return "[Map]"
+		}
+		entries := make([]string, 0, min(len(t.Keys), maxArrayItems+1))
+		for i := range t.Keys {
+			if i == maxArrayItems {
+				entries = append(entries, moreItems(len(t.Keys)-i))
+				break
+			}
+			entries = append(entries, p.format(t.Keys[i], level+1)+" => "+p.format(t.Values[i], level+1))
+		}
+		return p.group(fmt.Sprintf("Map(%d) ", len(t.Keys)), "{", "}", entries, level)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// folded tells whether objects at level are beyond the depth and shown
+// as `[Object]`.
+func (p printer) folded(level int) bool {
+	return p.depth >= 0 && level > p.depth
+}
+
+func (p printer) items(values []any, level int) []string {
+	items := make([]string, 0, min(len(values), maxArrayItems+1))
+	for i, v := range values {
+		if i == maxArrayItems {
+			items = append(items, moreItems(len(values)-i))
+			break
+		}
+		items = append(items, p.format(v, level+1))
+	}
+	return items
+}
+
+func (p printer) fields(fields map[string]any, level int) []string {
+	entries := make([]string, 0, len(fields))
+	for _, k := range sortedKeys(fields) {
+		key := k
+		if !plainKeyRegex.MatchString(k) {
+			key = p.quote(k)
+		}
+		entries = append(entries, key+": "+p.format(fields[k], level+1))
+	}
+	return entries
+}
+
+func moreItems(n int) string {
+	if n == 1 {
+		return "... 1 more item"
+	}
+	return fmt.Sprintf("... %d more items", n)
+}
+
+// group lays entries out between open and close: on one line when it
+// fits, else one entry to a line, indented a level deeper than level.
+func (p printer) group(prefix, open, close string, entries []string, level int) string {
+	if len(entries) == 0 {
+		return prefix + open + close
+	}
+	line := prefix + open + " " + strings.Join(entries, ", ") + " " + close
+	pad := p.margin + strings.Repeat(p.indent, level)
+	if p.width == 0 || !strings.Contains(line, "\n") && utf8.RuneCountInString(pad+line) <= p.width {
+		return line
+	}
+	inner := pad + p.indent
+	if short(entries) {
+		// a long run of numbers and such fills the lines instead
+		var lines []string
+		row := ""
+		for _, e := range entries {
+			if row != "" && utf8.RuneCountInString(inner+row+", "+e) > p.width {
+				lines, row = append(lines, row), ""
+			}
+			if row != "" {
+				row += ", "
+			}
+			row += e
+		}
+		entries = append(lines, row)
+	}
+	return prefix + open + "\n" + inner + strings.Join(entries, ",\n"+inner) + "\n" + pad + close
+}
+
+// short tells whether there are more than 6 entries, all of them short
+// and on one line.
+func short(entries []string) bool {
+	if len(entries) <= 6 {
+		return false
+	}
+	for _, e := range entries {
+		if utf8.RuneCountInString(e) > 16 && !strings.HasPrefix(e, "... ") || strings.Contains(e, "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// quote renders s as a JS string literal in single quotes, cut to
+// maxString runes.
+func (p printer) quote(s string) string {
+	more := ""
+	if n := utf8.RuneCountInString(s); p.maxString > 0 && n > p.maxString {
+		s = string([]rune(s)[:p.maxString])
+		more = fmt.Sprintf("... %d more characters", n-p.maxString)
+	}
+	q := strconv.Quote(s)
+	q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(q, "'", `\'`) + "'" + more
+}
+
+// formatNumber prints f as JS does, `Infinity` and `-0` included.
+func formatNumber(f float64) string {
+	switch a := math.Abs(f); {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0 && math.Signbit(f):
+		return "-0"
+	case math.IsNaN(f) || a != 0 && (a < 1e-6 || a >= 1e21):
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// truncateCapture bounds a captured string to limit bytes (cut on a rune
+// boundary) so huge strings aren't retained, noting how much was dropped.
+func truncateCapture(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s…(+%d bytes)", s[:cut], len(s)-cut)
+}
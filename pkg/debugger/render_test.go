@@ -0,0 +1,41 @@
+package debugger
+
+import (
+	"slices"
+	"testing"
+)
+
+// Capturing an object runs its getters, whose own hooks mustn't capture
+// the object again or count as calls the script made.
+func TestGetterCaptures(t *testing.T) {
+	script := `const o = {
+  v: 2,
+  get g() {
+    const me = this;
+    return me.v;
+  },
+  get bad() { throw new Error("boom"); },
+};
+function f() { return o.v; }
+const r = f();`
+	r := runScript(t, script, func(c *Config) { c.TraceCalls = true })
+	if got := renderCaptured(r, "o"); got != "[{ bad: <getter error>, g: [Getter: 2], v: 2 }]" {
+		t.Errorf("o captured %s, want [{ bad: <getter error>, g: [Getter: 2], v: 2 }]", got)
+	}
+	if got := captured(r, "me"); got != "[]" {
+		t.Errorf("me captured %s, want nothing", got)
+	}
+	var calls []string
+	for _, e := range r.State.Calls {
+		calls = append(calls, e.render("  ", 0))
+	}
+	if want := []string{"→ f()", "← f = 2"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if _, ok := r.State.LastArgs["g"]; ok {
+		t.Error("the getter's read is recorded as a call")
+	}
+	if r.State.MaxDepth != 1 {
+		t.Errorf("max depth %d, want 1", r.State.MaxDepth)
+	}
+}
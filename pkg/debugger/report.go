@@ -0,0 +1,565 @@
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// reportSection is one titled block of the snapshot report, shared by the
+// text and Markdown writers.
+type reportSection struct {
+	Title string
+	Lines []reportLine
+}
+
+type reportLine struct {
+	Text   string
+	Nested bool // belongs to the line above it
+}
+
+func (s *reportSection) add(nested bool, format string, args ...any) {
+	s.Lines = append(s.Lines, reportLine{Text: fmt.Sprintf(format, args...), Nested: nested})
+}
+
+// reportSections lists the sections that follow the variables, leaving out
+// the empty ones.
+func reportSections(state *RunState, opts *Config) []reportSection {
+	var sections []reportSection
+	section := func(title string) *reportSection {
+		sections = append(sections, reportSection{Title: title})
+		return &sections[len(sections)-1]
+	}
+
+	if reason := state.stopReason(opts); reason != "" {
+		s := section("STOPPED")
+		s.add(false, "%s", reason)
+	}
+
+	if len(state.Stack) > 0 {
+		s := section("CALL STACK")
+		for _, f := range state.Stack {
+			s.add(false, "at %s", f)
+		}
+	}
+
+	if len(state.Modules) > 0 {
+		s := section("MODULES")
+		for _, m := range state.Modules {
+			s.add(false, "%s -> %s", m.Specifier, m.Path)
+			for _, k := range sortedKeys(m.Variables) {
+				s.add(true, "%s: %s", k, inspectLine(m.Variables[k], opts))
+			}
+		}
+	}
+
+	if opts.Profile && state.Profile != nil {
+		s := section("PROFILE")
+		for _, line := range profileTable(state, 0) {
+			s.add(false, "%s", line)
+		}
+	}
+
+	if opts.Coverage {
+		s := section("COVERAGE")
+		for _, c := range state.coverageByFile(opts) {
+			s.add(false, "%s", c.summary())
+		}
+	}
+
+	if len(state.Messages) > 0 {
+		s := section("MESSAGES")
+		for _, m := range state.Messages {
+			s.add(false, "#%d %s: %s", m.Seq, m.Channel, renderValue(m.Value, opts.MaxValueLen))
+		}
+	}
+
+	if len(state.Checkpoints) > 0 {
+		s := section("BREAKPOINT SNAPSHOTS")
+		for _, c := range state.Checkpoints {
+			s.add(false, "[%s]", c.Key())
+			for _, k := range sortedKeys(c.Values) {
+				s.add(true, "%s: %s", k, inspectLine(c.Values[k], opts))
+			}
+		}
+	}
+
+	if scoped := state.scopesWithValues(); len(scoped) > 1 || len(scoped) == 1 && scoped[0].Parent >= 0 {
+		s := section("SCOPES")
+		for _, sc := range scoped {
+			s.add(false, "[%s]", sc.title())
+			for _, name := range sc.names {
+				s.add(true, "%s: %s", name, inspectLine(sc.Values[name], opts))
+			}
+		}
+	}
+
+	if len(state.Watches) > 0 {
+		s := section("WATCHES")
+		for _, w := range state.Watches {
+			s.add(false, "%s = %s", w.Expr, w.Value)
+			if len(w.History) > 1 {
+				s.add(true, "history: %s", strings.Join(w.History, " → "))
+			}
+		}
+	}
+
+	if len(state.LastArgs) > 0 {
+		s := section("LAST CALL ARGUMENTS")
+		for _, fn := range sortedKeys(state.LastArgs) {
+			s.add(false, "%s", describeCall(fn, state.LastArgs[fn], opts.MaxValueLen))
+		}
+	}
+
+	if state.Mutations != nil && len(state.Mutations.Functions) > 0 {
+		s := section("MUTATIONS")
+		for _, fn := range state.Mutations.Functions {
+			s.add(false, "%s", state.Mutations.describe(fn))
+		}
+	}
+
+	if len(state.ElementWrites) > 0 {
+		s := section("ELEMENT WRITES")
+		for _, w := range state.ElementWrites {
+			s.add(false, "%s", w.render(opts.MaxValueLen))
+		}
+	}
+
+	if len(state.JSONCalls) > 0 {
+		s := section("JSON")
+		for _, c := range state.JSONCalls {
+			s.add(false, "%s", c.render(opts.MaxValueLen))
+		}
+	}
+
+	if len(state.EnvReads) > 0 {
+		s := section("ENV READS")
+		for _, r := range state.EnvReads {
+			s.add(false, "%s", r)
+		}
+	}
+
+	if len(state.Expectations) > 0 {
+		s := section(fmt.Sprintf("EXPECTATIONS (%d/%d passed)", passedExpectations(state.Expectations), len(state.Expectations)))
+		for _, e := range state.Expectations {
+			s.add(false, "%s", describeExpectation(e, opts.MaxValueLen))
+		}
+	}
+
+	if len(state.Async) > 0 {
+		s := section("ASYNC")
+		for _, t := range state.Async {
+			s.add(false, "%s", t)
+		}
+	}
+
+	if len(state.Events) > 0 {
+		s := section("VALUE TIMELINE")
+		for _, e := range state.Events {
+			s.add(false, "%s", e.render(state.Started, opts.MaxValueLen))
+		}
+	}
+
+	if len(state.Warnings) > 0 {
+		s := section("WARNINGS")
+		for _, w := range state.Warnings {
+			s.add(false, "%s", w)
+		}
+	}
+
+	// the latest exception is the crash's, unless something caught it
+	crashed := state.Crash != nil && state.Thrown != nil
+	if crashed || len(state.Rejections) > 0 {
+		s := section("CRASH SNAPSHOT")
+		if t := state.Thrown; crashed {
+			if t.Line > 0 {
+				s.add(false, "%s, thrown on line %d", t.Message, t.Line)
+			} else {
+				s.add(false, "%s", t.Message)
+			}
+			for _, line := range t.Scope {
+				s.add(true, "%s", line)
+			}
+			for _, f := range t.Stack {
+				s.add(true, "at %s", f)
+			}
+		}
+		for _, reason := range state.Rejections {
+			s.add(false, "unhandled promise rejection: %s", reason)
+		}
+	}
+
+	if state.Crash != nil {
+		s := section("CRASH TRACE")
+		s.add(false, "%s", state.Crash.Message)
+		for _, f := range state.Crash.Frames {
+			s.add(true, "at %s", f)
+		}
+	}
+
+	return sections
+}
+
+// writeDebugInfoMarkdown renders the same report as writeDebugInfo, with
+// the variables as a table, for pasting into issues and docs.
+func writeDebugInfoMarkdown(writer io.Writer, debugInfo map[string]any, label string, state *RunState, opts *Config) {
+	fmt.Fprintf(writer, "# %s\n\n", label)
+	if len(debugInfo) > 0 {
+		fmt.Fprintf(writer, "| Variable | Value | Type |\n|---|---|---|\n")
+		for _, k := range sortedKeys(debugInfo) {
+			fmt.Fprintf(writer, "| %s | %s | %s |\n", markdownCell(k), markdownCell(state.history(state.latestKey(k), debugInfo[k], opts)), valueType(debugInfo[k]))
+		}
+		fmt.Fprintln(writer)
+	}
+	fmt.Fprintf(writer, "Max stack depth: %d\n", state.MaxDepth)
+
+	for _, section := range reportSections(state, opts) {
+		fmt.Fprintf(writer, "\n## %s\n\n", section.Title)
+		for _, line := range section.Lines {
+			if line.Nested {
+				fmt.Fprint(writer, "  ")
+			}
+			fmt.Fprintf(writer, "- %s\n", markdownText(line.Text))
+		}
+	}
+}
+
+// writeLoopInfoMarkdown renders the loop analysis as one section per loop.
+func writeLoopInfoMarkdown(writer io.Writer, loopInfos []LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	fmt.Fprintf(writer, "# Loop analysis\n")
+
+	if opts.GroupLoopsBy != "type" {
+		for i, loop := range loopInfos {
+			writeLoopMarkdown(writer, "##", i+1, loop, allVariables, state, opts)
+		}
+	} else {
+		for _, kind := range []string{"for", "while", "do-while"} {
+			var numbers []int
+			iterations := 0
+			for i, loop := range loopInfos {
+				if loop.Type == kind {
+					numbers = append(numbers, i+1)
+					iterations += loop.Iterations
+				}
+			}
+			if len(numbers) == 0 {
+				continue
+			}
+			fmt.Fprintf(writer, "\n## `%s` loops: %d loop(s), %d iteration(s) total\n", kind, len(numbers), iterations)
+			for _, n := range numbers {
+				writeLoopMarkdown(writer, "###", n, loopInfos[n-1], allVariables, state, opts)
+			}
+		}
+	}
+
+	if d := opts.LoopDiff; d != nil && d.Loop <= len(loopInfos) {
+		fmt.Fprintf(writer, "\n## Loop %d: iteration %d vs %d\n\n", d.Loop, d.From, d.To)
+		for _, line := range iterationDiff(loopInfos[d.Loop-1], d.From, d.To, opts.MaxValueLen) {
+			fmt.Fprintf(writer, "- %s\n", markdownText(line))
+		}
+	}
+}
+
+func writeLoopMarkdown(writer io.Writer, heading string, number int, loop LoopInfo, allVariables map[string]any, state *RunState, opts *Config) {
+	fmt.Fprintf(writer, "\n%s Loop %d (`%s`)\n\n", heading, number, loop.Type)
+	if loop.Outer > 0 {
+		fmt.Fprintf(writer, "Nested in loop %d\n\n", loop.Outer)
+	}
+	fmt.Fprintf(writer, "Iterations: %d, time: %v\n", loop.Iterations, loop.Duration.Round(time.Microsecond))
+
+	var rows []string
+	for _, v := range state.loopValues(loop, allVariables) {
+		rows = append(rows, fmt.Sprintf("| %s | %s |", markdownCell(v.Name), markdownCell(inspectLine(v.Value, opts))))
+	}
+	if len(rows) > 0 {
+		fmt.Fprintf(writer, "\n| Variable | Value |\n|---|---|\n%s\n", strings.Join(rows, "\n"))
+	}
+
+	var details strings.Builder
+	writeTimeline(&details, loop, "", opts)
+	writeCollectionSizes(&details, loop.Sizes, "")
+	if opts.LoopStats {
+		writeLoopStats(&details, loop.Values, "")
+	}
+	for _, line := range strings.Split(strings.TrimRight(details.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasSuffix(line, ":") {
+			fmt.Fprintf(writer, "\n%s\n\n", markdownText(line))
+		} else {
+			fmt.Fprintf(writer, "- %s\n", markdownText(line))
+		}
+	}
+}
+
+// valueType names the JS type of a captured value.
+func valueType(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case typedArray:
+		return t.Type
+	case undefinedValue:
+		return "undefined"
+	case *big.Int:
+		return "bigint"
+	case jsLabel:
+		return t.Type
+	}
+	return "object"
+}
+
+// markdownText escapes text for a Markdown paragraph or list item. Only
+// `|`, which would break a table, is escaped: values like `a < b` or
+// `arr_1` are shown as they are.
+func markdownText(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+// markdownCell escapes text for a table cell, which must stay on one line.
+func markdownCell(text string) string {
+	return strings.ReplaceAll(markdownText(text), "\n", "<br>")
+}
+
+// jsonVariable is a captured variable in the JSON report. The top-level
+// variables also tell their type and where they were declared.
+type jsonVariable struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Value any    `json:"value"`
+	Line  int    `json:"line,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+type jsonLoop struct {
+	Type       string         `json:"type"`
+	Line       int            `json:"line"`
+	EndLine    int            `json:"end_line"`
+	Outer      int            `json:"outer,omitempty"`
+	File       string         `json:"file,omitempty"`
+	Iterations int            `json:"iterations"`
+	DurationNS int64          `json:"duration_ns"`
+	Variables  []jsonVariable `json:"variables"`
+	Timeline   []jsonStep     `json:"timeline,omitempty"`
+}
+
+type jsonBreakpoint struct {
+	Label string      `json:"label"`
+	Line  int         `json:"line,omitempty"`
+	Time  time.Time   `json:"time"`
+	Stack []jsonFrame `json:"stack"`
+}
+
+type jsonEvent struct {
+	Seq   int       `json:"seq"`
+	Name  string    `json:"name"`
+	Value any       `json:"value"`
+	Line  int       `json:"line"`
+	File  string    `json:"file,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// jsonFunction is a FunctionProfile, listed by self time.
+type jsonFunction struct {
+	Name    string `json:"name"`
+	Calls   int    `json:"calls"`
+	TotalNS int64  `json:"total_ns"`
+	SelfNS  int64  `json:"self_ns"`
+}
+
+// jsonCoverage is the -coverage of one file; lines are those a
+// statement starts on.
+type jsonCoverage struct {
+	File       string         `json:"file"`
+	Path       string         `json:"path"`
+	Statements int            `json:"statements"`
+	Covered    int            `json:"covered"`
+	Lines      []jsonLineHits `json:"lines"`
+}
+
+type jsonLineHits struct {
+	Line int `json:"line"`
+	Hits int `json:"hits"`
+}
+
+// jsonModule is a ModuleLoad; name and variables are set for the
+// instrumented ones.
+type jsonModule struct {
+	Specifier string         `json:"specifier"`
+	Path      string         `json:"path"`
+	Name      string         `json:"name,omitempty"`
+	Variables []jsonVariable `json:"variables,omitempty"`
+}
+
+// jsonCall is a CallEvent; args are set on calls, return on the exits
+// of calls that returned a value.
+type jsonCall struct {
+	Event  string         `json:"event"`
+	Name   string         `json:"name"`
+	Depth  int            `json:"depth"`
+	Args   []jsonVariable `json:"args,omitempty"`
+	Return any            `json:"return,omitempty"`
+	Threw  bool           `json:"threw,omitempty"`
+}
+
+// jsonAsync is an AsyncTask; delay_ms is left out for awaits.
+type jsonAsync struct {
+	Kind     string `json:"kind"`
+	Line     int    `json:"line"`
+	Function string `json:"function,omitempty"`
+	DelayMS  int64  `json:"delay_ms,omitempty"`
+	Set      int    `json:"set"`
+	Ran      int    `json:"ran"`
+}
+
+type jsonWatch struct {
+	Expr    string   `json:"expr"`
+	Value   string   `json:"value"`
+	History []string `json:"history"`
+}
+
+type jsonFrame struct {
+	Func   string `json:"func"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// jsonStep is a LoopStep; iteration is 0 for the exit step.
+type jsonStep struct {
+	Iteration int            `json:"iteration"`
+	Condition any            `json:"condition,omitempty"`
+	Values    []jsonVariable `json:"values"`
+}
+
+// debugInfoJSON encodes the captured variables, the loops, with the
+// variables in scope of each, the breakpoint hits, the watches, the value
+// timeline, the -trace-calls call tree, the timers and awaits, the
+// required modules, the -coverage counts and the -profile hot spots as one
+// JSON object for editor tooling.
+func debugInfoJSON(debugInfo map[string]any, state *RunState, opts *Config) ([]byte, error) {
+	report := struct {
+		Variables   []jsonVariable   `json:"variables"`
+		Loops       []jsonLoop       `json:"loops"`
+		Breakpoints []jsonBreakpoint `json:"breakpoints"`
+		Watches     []jsonWatch      `json:"watches"`
+		Events      []jsonEvent      `json:"events"`
+		Calls       []jsonCall       `json:"calls,omitempty"`
+		Async       []jsonAsync      `json:"async,omitempty"`
+		Modules     []jsonModule     `json:"modules,omitempty"`
+		Coverage    []jsonCoverage   `json:"coverage,omitempty"`
+		Profile     []jsonFunction   `json:"profile,omitempty"`
+		TimedOut    bool             `json:"timed_out,omitempty"`
+		Limit       string           `json:"limit,omitempty"`
+	}{Variables: []jsonVariable{}, Loops: []jsonLoop{}, Breakpoints: []jsonBreakpoint{}, Watches: []jsonWatch{}, Events: []jsonEvent{}}
+
+	for _, k := range sortedKeys(debugInfo) {
+		v := jsonVariable{Name: k, Value: jsonValue(debugInfo[k])}
+		if d := state.Declarations[k]; d != nil {
+			v.Type, v.Line = d.Type, d.Line
+			if d.Scope >= 0 && d.Scope < len(state.Scopes) {
+				v.Scope = state.Scopes[d.Scope].title()
+			}
+		}
+		report.Variables = append(report.Variables, v)
+	}
+	for _, loop := range state.Loops {
+		entry := jsonLoop{Type: loop.Type, Line: loop.Line, EndLine: loop.EndLine, Outer: loop.Outer, File: loop.File, Iterations: loop.Iterations, DurationNS: loop.Duration.Nanoseconds(), Variables: []jsonVariable{}}
+		for _, v := range state.loopValues(loop, debugInfo) {
+			entry.Variables = append(entry.Variables, jsonVariable{Name: v.Name, Value: jsonValue(v.Value)})
+		}
+		for _, step := range loop.Timeline {
+			js := jsonStep{Iteration: step.Iteration, Values: []jsonVariable{}}
+			if step.Tested {
+				js.Condition = jsonValue(step.Condition)
+			}
+			for _, name := range loop.Tracked {
+				if value, ok := step.Values[name]; ok {
+					js.Values = append(js.Values, jsonVariable{Name: name, Value: jsonValue(value)})
+				}
+			}
+			entry.Timeline = append(entry.Timeline, js)
+		}
+		report.Loops = append(report.Loops, entry)
+	}
+	for _, hit := range state.Hits {
+		entry := jsonBreakpoint{Label: hit.Label, Line: hit.Line, Time: hit.Time, Stack: []jsonFrame{}}
+		for _, f := range hit.Stack {
+			entry.Stack = append(entry.Stack, jsonFrame{Func: f.Func, File: f.File, Line: f.Line, Column: f.Column})
+		}
+		report.Breakpoints = append(report.Breakpoints, entry)
+	}
+	for _, c := range state.Calls {
+		entry := jsonCall{Event: "call", Name: c.Name, Depth: c.Depth, Threw: c.Threw}
+		if c.Exit {
+			entry.Event = "return"
+			if c.Returned && !c.Threw {
+				entry.Return = jsonValue(c.Return)
+			}
+		}
+		for _, a := range c.Args {
+			entry.Args = append(entry.Args, jsonVariable{Name: a.Name, Value: jsonValue(a.Value)})
+		}
+		report.Calls = append(report.Calls, entry)
+	}
+	for _, e := range state.Events {
+		report.Events = append(report.Events, jsonEvent{Seq: e.Seq, Name: e.Name, Value: jsonValue(e.Value), Line: e.Line, File: e.File, Time: e.Time})
+	}
+	for _, t := range state.Async {
+		report.Async = append(report.Async, jsonAsync{Kind: t.Kind, Line: t.Line, Function: t.Func, DelayMS: t.Delay.Milliseconds(), Set: t.Set, Ran: t.Ran})
+	}
+	for _, m := range state.Modules {
+		entry := jsonModule{Specifier: m.Specifier, Path: m.Path, Name: m.Name}
+		for _, k := range sortedKeys(m.Variables) {
+			entry.Variables = append(entry.Variables, jsonVariable{Name: k, Value: jsonValue(m.Variables[k])})
+		}
+		report.Modules = append(report.Modules, entry)
+	}
+	if opts.Coverage {
+		for _, c := range state.coverageByFile(opts) {
+			entry := jsonCoverage{File: c.Name, Path: c.Path, Statements: c.Statements, Covered: c.Covered, Lines: []jsonLineHits{}}
+			for _, line := range sortedKeys(c.Lines) {
+				entry.Lines = append(entry.Lines, jsonLineHits{Line: line, Hits: c.Lines[line]})
+			}
+			report.Coverage = append(report.Coverage, entry)
+		}
+	}
+	if opts.Profile && state.Profile != nil {
+		for _, fn := range state.Profile.hotSpots() {
+			report.Profile = append(report.Profile, jsonFunction{Name: fn.Name, Calls: fn.Calls, TotalNS: fn.Total.Nanoseconds(), SelfNS: fn.Self.Nanoseconds()})
+		}
+	}
+	report.TimedOut, report.Limit = state.TimedOut, state.Limit
+	for _, w := range state.Watches {
+		report.Watches = append(report.Watches, jsonWatch{Expr: w.Expr, Value: w.Value, History: append([]string{}, w.History...)})
+	}
+
+	data, err := json.MarshalIndent(report, "", opts.Indent)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// jsonValue is v when it marshals, and its rendering when it doesn't
+// (NaN), so one such value can't cost the whole report.
+func jsonValue(v any) any {
+	if _, err := json.Marshal(v); err != nil {
+		return renderValue(v, 0)
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		variable string
+		typ      string
+		value    string
+	}{
+		{name: "typed array", script: "const bytes = new Uint8Array([1, 2]);", variable: "bytes", typ: "Uint8Array", value: "[1,2]"},
+		{name: "getters", script: "const o = { v: 1, get g() { return 5; }, get bad() { throw new Error(\"boom\"); } };", variable: "o", typ: "object", value: `{"bad":"<getter error>","g":5,"v":1}`},
+		{name: "getter of a typed array", script: "const o = { get b() { return new Int8Array([-1]); } };", variable: "o", typ: "object", value: `{"b":[-1]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config *Config
+			r := runScript(t, tt.script, func(c *Config) { config = c })
+			data, err := debugInfoJSON(r.Variables, r.State, config)
+			if err != nil {
+				t.Fatalf("debugInfoJSON: %v", err)
+			}
+			var report struct {
+				Variables []struct {
+					Name  string          `json:"name"`
+					Type  string          `json:"type"`
+					Value json.RawMessage `json:"value"`
+				} `json:"variables"`
+			}
+			if err := json.Unmarshal(data, &report); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			for _, v := range report.Variables {
+				if v.Name != tt.variable {
+					continue
+				}
+				if v.Type != tt.typ {
+					t.Errorf("type %q, want %q", v.Type, tt.typ)
+				}
+				var got, want any
+				if err := json.Unmarshal(v.Value, &got); err != nil {
+					t.Fatalf("unmarshal %s: %v", v.Value, err)
+				}
+				json.Unmarshal([]byte(tt.value), &want)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("value %s, want %s", v.Value, tt.value)
+				}
+				return
+			}
+			t.Fatalf("no variable %s in %s", tt.variable, data)
+		})
+	}
+}
+
+// A variable's history is its scope's: a loop's `let i` doesn't run into
+// a global `i`.
+func TestHistoryPerScope(t *testing.T) {
+	script := "let i = 10;\nfor (let i = 0; i < 2; i++) {}\ni = 11;"
+	r := runScript(t, script, nil)
+	if got := captured(r, "i"); got != "[10 11]" {
+		t.Errorf("global i captured %s, want [10 11]", got)
+	}
+	var loop []any
+	for key, h := range r.State.History {
+		if key.Name == "i" && key.Scope != 0 {
+			loop = h.Values
+		}
+	}
+	if got := fmt.Sprint(loop); got != "[0 1]" {
+		t.Errorf("loop i captured %s, want [0 1]", got)
+	}
+}
+
+func TestReportOrder(t *testing.T) {
+	var config *Config
+	r := runScript(t, "let b = 1;\nlet c = 2;\nlet a = 3;\nb = 4;", func(c *Config) { config = c })
+	var out strings.Builder
+	for range 5 {
+		out.Reset()
+		writeDebugInfo(&out, r.Variables, "Final", r.State, config)
+		if want := "=== Final ===\na: 3\nb: 1 → 4\nc: 2\n"; !strings.HasPrefix(out.String(), want) {
+			t.Fatalf("report starts %q, want %q", out.String(), want)
+		}
+	}
+}
+
+func TestMarkdownTable(t *testing.T) {
+	var config *Config
+	r := runScript(t, "const s = \"<b>|x_y*</b>\";\nlet n = 1;\nn = 2;", func(c *Config) { config = c })
+	var out strings.Builder
+	writeDebugInfoMarkdown(&out, r.Variables, "Final", r.State, config)
+	want := "# Final\n\n| Variable | Value | Type |\n|---|---|---|\n| n | 1 → 2 | number |\n| s | <b>\\|x_y*</b> | string |\n"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("report starts %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,178 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+)
+
+// Scope is a function, block or loop scope of the script, so that two
+// variables of the same name in different scopes are kept apart. Scope 0
+// is the global scope. Blocks and loops only get a scope of their own when
+// they declare let/const variables; var belongs to the function.
+type Scope struct {
+	Name   string
+	Line   int
+	Parent int // -1 for the global scope
+
+	// File is the module the scope is in, "" for the script.
+	File string
+
+	// Values are the latest captures in the scope, names in capture order.
+	Values map[string]any
+	names  []string
+}
+
+func (s *Scope) set(name string, value any) {
+	if s.Values == nil {
+		s.Values = make(map[string]any)
+	}
+	if _, seen := s.Values[name]; !seen {
+		s.names = append(s.names, name)
+	}
+	s.Values[name] = value
+}
+
+func (s *Scope) title() string {
+	switch {
+	case s.Parent < 0:
+		return s.Name
+	case s.File != "":
+		return fmt.Sprintf("%s, %s line %d", s.Name, s.File, s.Line)
+	}
+	return fmt.Sprintf("%s, line %d", s.Name, s.Line)
+}
+
+// render lists the scope's captures as `a = 1, b = 2`.
+func (s *Scope) render(limit int) string {
+	parts := make([]string, len(s.names))
+	for i, name := range s.names {
+		parts[i] = fmt.Sprintf("%s = %s", name, renderValue(s.Values[name], limit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// captureScope records value as the latest capture of name in scope. A
+// capture template that doesn't pass {{.Scope}} leaves scope undefined,
+// which arrives here as -1 and is only kept in the flat snapshot.
+func (s *RunState) captureScope(scope int, name string, value any) {
+	if scope >= 0 && scope < len(s.Scopes) {
+		s.Scopes[scope].set(name, value)
+	}
+}
+
+// scopesWithValues lists the scopes anything was captured in.
+func (s *RunState) scopesWithValues() []*Scope {
+	var scoped []*Scope
+	for i := range s.Scopes {
+		if len(s.Scopes[i].names) > 0 {
+			scoped = append(scoped, &s.Scopes[i])
+		}
+	}
+	return scoped
+}
+
+// printFinalSnapshot lists the last captures by scope once anything was
+// captured outside the global scope, as a pause does, so that two
+// variables of the same name don't collapse into one. What was captured
+// without a scope is listed flat after the scopes; modules have their own
+// section.
+func printFinalSnapshot(out io.Writer, debugInfo map[string]any, state *RunState, opts *Config) {
+	var scoped []int
+	for i, sc := range state.Scopes {
+		if len(sc.names) > 0 && sc.File == "" {
+			scoped = append(scoped, i)
+		}
+	}
+	if len(scoped) == 0 || len(scoped) == 1 && state.Scopes[scoped[0]].Parent < 0 {
+		for _, k := range sortedKeys(debugInfo) {
+			fmt.Fprintf(out, "%s%s: %s \n", opts.Indent, k, state.describe(state.latestKey(k), debugInfo[k], opts))
+		}
+		return
+	}
+	inScopes := make(map[string]bool)
+	for _, i := range scoped {
+		sc := &state.Scopes[i]
+		fmt.Fprintf(out, "%s%s:\n", opts.Indent, sc.title())
+		for _, name := range sc.names {
+			inScopes[name] = true
+			fmt.Fprintf(out, "%s%s%s: %s \n", opts.Indent, opts.Indent, name, state.describe(VarKey{name, i}, sc.Values[name], opts))
+		}
+	}
+	for _, k := range sortedKeys(debugInfo) {
+		if !inScopes[k] {
+			fmt.Fprintf(out, "%s%s: %s \n", opts.Indent, k, state.describe(state.latestKey(k), debugInfo[k], opts))
+		}
+	}
+}
+
+// loopValues pairs each of loop's variables with its latest capture in
+// the scope the loop captures it in, so that a loop's `let i` isn't
+// reported with the value of an `i` outside it. A capture kept only in
+// the flat snapshot (flat, or the module's for a module's loop) is taken
+// from there.
+func (s *RunState) loopValues(loop LoopInfo, flat map[string]any) []namedValue {
+	if loop.File != "" {
+		flat = loop.variables
+	}
+	var values []namedValue
+	for i, name := range loop.Variables {
+		value, ok := flat[name]
+		if id := loop.scopes[i]; id >= 0 && id < len(s.Scopes) {
+			if v, scoped := s.Scopes[id].Values[name]; scoped {
+				value, ok = v, true
+			}
+		}
+		if ok {
+			values = append(values, namedValue{name, value})
+		}
+	}
+	return values
+}
+
+// scopeLines lists the captures visible from scope at a breakpoint, the
+// innermost scope first: `local (f, line 3): i = 3` ... `global: ...`.
+func (s *RunState) scopeLines(scope, limit int) []string {
+	var lines []string
+	for id, depth := scope, 0; id >= 0 && id < len(s.Scopes); id, depth = s.Scopes[id].Parent, depth+1 {
+		sc := &s.Scopes[id]
+		if len(sc.names) == 0 {
+			continue
+		}
+		label := sc.title()
+		switch {
+		case sc.Parent < 0:
+		case depth == 0:
+			label = "local (" + label + ")"
+		default:
+			label = "outer (" + label + ")"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", label, sc.render(limit)))
+	}
+	return lines
+}
+
+// enterScope starts a scope named name at line inside the current one and
+// returns the function that ends it.
+func (in *instrumenter) enterScope(name string, line int, function bool) func() {
+	scope, fnScope := in.scope, in.fnScope
+	in.scopes = append(in.scopes, Scope{Name: name, Line: line, Parent: scope})
+	in.scope = len(in.scopes) - 1
+	if function {
+		in.fnScope = in.scope
+	}
+	return func() { in.scope, in.fnScope = scope, fnScope }
+}
+
+// blockScope is enterScope for a block (or loop body) when it declares
+// let/const variables of its own, and a no-op otherwise.
+func (in *instrumenter) blockScope(name string, line int, list []ast.Statement) func() {
+	for _, stmt := range list {
+		if _, ok := stmt.(*ast.LexicalDeclaration); ok {
+			return in.enterScope(name, line, false)
+		}
+	}
+	return func() {}
+}
@@ -0,0 +1,199 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+// Version is the debugger's release, printed by -version.
+const Version = "0.9.0"
+
+// Debugger debugs scripts read from any io.Reader with one Config. It is
+// what programs embedding the debugger start from.
+type Debugger struct {
+	Config *Config
+}
+
+// New returns a debugger that runs with cfg, or with the NewSession
+// defaults when cfg is nil.
+func New(cfg *Config) *Debugger {
+	if cfg == nil {
+		cfg = NewSession().Config
+	}
+	return &Debugger{Config: cfg}
+}
+
+// Run reads the script from src and debugs it as name, the file reported
+// in errors and crash sites.
+func (d *Debugger) Run(name string, src io.Reader) (*Result, error) {
+	script, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	cfg := *d.Config
+	cfg.Script = name
+	return (&Session{Config: &cfg}).Run(string(script))
+}
+
+// Hooks let a program embedding the debugger follow a run. Any of them
+// can be nil.
+type Hooks struct {
+	// Capture is called with every value a variable is captured with.
+	Capture func(name string, value any, line int)
+
+	// Breakpoint is called when a breakpoint or -step pauses, with its
+	// label ("step" for steps), its line (0 when unknown) and a copy of the
+	// variables captured so far. It replaces the interactive prompt; the
+	// script stops when it returns false.
+	Breakpoint func(label string, line int, variables map[string]any) bool
+
+	// Check, LoopIteration and FunctionCall are for assertions of one's
+	// own: a label one returns pauses the script where it was called, as a
+	// breakpoint with that label does; "" lets it carry on. Check is called
+	// with every captured value, after Capture; LoopIteration as a loop
+	// starts its nth iteration; FunctionCall as an instrumented function is
+	// entered, with the arguments it was called with.
+	Check         func(name string, value any, line int) string
+	LoopIteration func(loop LoopInfo, n int) string
+	FunctionCall  func(frame Frame, args map[string]any) string
+}
+
+// Session debugs scripts with one Config. The CLI builds its Config from
+// the command line; NewSession starts from the defaults.
+type Session struct {
+	Config *Config
+}
+
+// Result is what a run collected.
+type Result struct {
+	// Variables holds the last value each variable was captured with.
+	Variables map[string]any
+	Loops     []LoopInfo
+	Warnings  []Warning
+
+	// Scopes holds the latest value of each variable per scope, where two
+	// variables of the same name don't overwrite each other.
+	Scopes []Scope
+	State  *RunState
+}
+
+// withConsole is a copy of o where the console streams left nil are the
+// process's own.
+func (o *Config) withConsole() *Config {
+	c := *o
+	if c.Stdin == nil {
+		c.Stdin = os.Stdin
+	}
+	if c.Stdout == nil {
+		c.Stdout = os.Stdout
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	return &c
+}
+
+// NewSession returns a session with the CLI defaults, except that it
+// writes no report files.
+func NewSession() *Session {
+	return &Session{Config: &Config{
+		Script:        "script.js",
+		Out:           ".",
+		Indent:        "  ",
+		Format:        "text",
+		NoFiles:       true,
+		MaxIterations: 1000,
+		InspectDepth:  2,
+		Timeout:       30 * time.Second,
+	}}
+}
+
+// Run instruments and runs script, reporting on it as the CLI does. The
+// error is the one the script failed with, if any, positioned in script
+// rather than the instrumented code; the result then holds what was
+// captured up to the failure.
+//
+// The report is written once the event loop is done with the timers and
+// promises the script left, or -timeout stopped it.
+func (s *Session) Run(script string) (*Result, error) {
+	loop := eventloop.NewEventLoop()
+	var finish func() (*Result, error)
+	loop.Run(func(vm *goja.Runtime) {
+		finish = s.run(vm, loop, script)
+	})
+	return finish()
+}
+
+// run starts script on the loop; the func it returns reports on it after
+// the loop stopped.
+func (s *Session) run(vm *goja.Runtime, loop *eventloop.EventLoop, script string) func() (*Result, error) {
+	opts := s.Config.withConsole()
+	debugInfo := make(map[string]any)
+	state := &RunState{Started: time.Now()}
+	if opts.Profile || opts.Flamegraph != "" {
+		state.Profile = newProfiler(state.clock)
+	}
+	if opts.DetectMutation {
+		state.Mutations = newMutationTracker(vm)
+	}
+	if opts.Record != "" {
+		state.Recording = &Recording{Version: recordingVersion, Script: opts.Script, Started: state.Started}
+	}
+
+	source := script
+	if opts.typeScript() {
+		js, columns, err := transpile(script, opts.Script, false)
+		if err != nil {
+			fmt.Fprintln(opts.Stderr, err)
+			return func() (*Result, error) { return nil, err }
+		}
+		script, state.Transpiled = js, columns
+	}
+
+	if opts.Inspect != "" {
+		ins, err := startInspector(vm, loop, source, state, opts)
+		if err != nil {
+			fmt.Fprintf(opts.Stderr, "Could not start the inspector on %s: %v\n", opts.Inspect, err)
+			return func() (*Result, error) { return nil, err }
+		}
+		state.inspector = ins
+	}
+
+	setupJsRuntime(vm, state, opts)
+	configDebugFunctions(vm, debugInfo, state, opts)
+	configAsyncFunctions(vm, loop, state, opts)
+	for _, path := range opts.Plugins {
+		p, err := loadPlugin(vm, path)
+		if err != nil {
+			err = fmt.Errorf("-plugin %s: %w", path, err)
+			fmt.Fprintln(opts.Stderr, err)
+			state.inspector.close()
+			return func() (*Result, error) { return nil, err }
+		}
+		state.plugins = append(state.plugins, p)
+	}
+
+	started := time.Now()
+	instrumented, detectedLoops, warnings, scopes, columns, coverage := instrumentCode(script, opts)
+	state.Timings.Instrument = time.Since(started)
+	state.Loops = detectedLoops
+	state.Warnings = warnings
+	state.Scopes = scopes
+	state.Source = strings.Split(source, "\n")
+	state.Columns = columns
+	state.Coverage = coverage
+
+	state.inspector.wait()
+	wait := execute(vm, loop, instrumented, state, opts)
+	return func() (*Result, error) {
+		state.inspector.close()
+		err := executeAndAnalyze(vm, wait(), debugInfo, state.Loops, state, opts)
+		return &Result{Variables: debugInfo, Loops: state.Loops, Warnings: state.Warnings, Scopes: state.Scopes, State: state}, err
+	}
+}
@@ -0,0 +1,48 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// runScript debugs script with the NewSession defaults, changed by
+// configure when it isn't nil, and no console.
+func runScript(t *testing.T, script string, configure func(*Config)) *Result {
+	t.Helper()
+	s := NewSession()
+	s.Config.Stdin, s.Config.Stdout, s.Config.Stderr = strings.NewReader(""), io.Discard, io.Discard
+	if configure != nil {
+		configure(s.Config)
+	}
+	result, err := s.Run(script)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return result
+}
+
+// captured renders every value name was captured with in the scope it
+// was last captured in, `[0 1 2]`.
+func captured(r *Result, name string) string {
+	h := r.State.History[r.State.latestKey(name)]
+	if h == nil {
+		return "[]"
+	}
+	return fmt.Sprint(h.Values)
+}
+
+// renderCaptured is captured with the values rendered as the report
+// shows them, `[Map(0) {} 1]`.
+func renderCaptured(r *Result, name string) string {
+	h := r.State.History[r.State.latestKey(name)]
+	if h == nil {
+		return "[]"
+	}
+	rendered := make([]string, len(h.Values))
+	for i, v := range h.Values {
+		rendered[i] = renderValue(v, 0)
+	}
+	return "[" + strings.Join(rendered, " ") + "]"
+}
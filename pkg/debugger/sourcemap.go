@@ -0,0 +1,68 @@
+package debugger
+
+import (
+	"sort"
+	"strings"
+)
+
+// insertion is code to splice into a line at a byte offset.
+type insertion struct {
+	pos  int
+	text string
+}
+
+func applyInsertions(line string, ins []insertion) string {
+	if len(ins) == 0 {
+		return line
+	}
+	sort.SliceStable(ins, func(i, j int) bool { return ins[i].pos < ins[j].pos })
+
+	var out strings.Builder
+	last := 0
+	for _, in := range ins {
+		out.WriteString(line[last:in.pos])
+		out.WriteString(in.text)
+		last = in.pos
+	}
+	out.WriteString(line[last:])
+	return out.String()
+}
+
+// sourceMap records, per 1-based line, the insertions spliced into it so
+// positions in the instrumented code can be mapped back to the original.
+type sourceMap map[int][]insertion
+
+func (m sourceMap) record(line int, ins []insertion) {
+	if len(ins) > 0 {
+		m[line] = ins
+	}
+}
+
+// sourceMaps maps a column back through one rewrite of the line after
+// another, the last first: the instrumentation, then the transpilation.
+type sourceMaps []sourceMap
+
+func (ms sourceMaps) column(line, column int) int {
+	for _, m := range ms {
+		column = m.column(line, column)
+	}
+	return column
+}
+
+// column maps a 1-based column of the instrumented line back to the
+// original line. A column inside injected code maps to where it was
+// spliced in.
+func (m sourceMap) column(line, column int) int {
+	offset, shift := column-1, 0
+	for _, in := range m[line] {
+		start := in.pos + shift
+		if offset < start {
+			break
+		}
+		if offset < start+len(in.text) {
+			return in.pos + 1
+		}
+		shift += len(in.text)
+	}
+	return offset - shift + 1
+}
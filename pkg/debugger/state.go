@@ -0,0 +1,490 @@
+package debugger
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// RunState accumulates what the injected hooks observe while the
+// instrumented script runs.
+type RunState struct {
+	depth    int
+	MaxDepth int
+
+	// LastArgs holds, per function, the parameters of its latest call.
+	LastArgs map[string][]namedValue
+
+	// History holds every value each variable was captured with, in order,
+	// per scope: a loop's `let i` and a global `i` have one each.
+	History map[VarKey]*VarHistory
+
+	// Loops is the instrumented script's loop table, updated by __loop_tick.
+	Loops []LoopInfo
+
+	// Scopes is the instrumented script's scope tree, with the latest
+	// captures in each scope.
+	Scopes []Scope
+
+	Warnings []Warning
+
+	// Source is the original script, one entry per line.
+	Source []string
+
+	// LineCaptures are the variables captured on each 1-based line.
+	LineCaptures map[int][]*lineCapture
+
+	// Columns maps positions in the instrumented code back to Source,
+	// through Transpiled for TypeScript: the columns of the JS it was
+	// transpiled to.
+	Columns    sourceMap
+	Transpiled sourceMap
+
+	// Crash is set when the script threw an uncaught error.
+	Crash *crashReport
+
+	// Modules lists the files require() loaded, in load order.
+	Modules []ModuleLoad
+
+	Timings PhaseTimings
+
+	// Messages are the payloads passed to capturePayload, in order.
+	Messages []Message
+
+	// ElementWrites are the array element assignments, in order.
+	ElementWrites []ElementWrite
+
+	// JSONCalls are the traced JSON.parse/stringify calls, in order.
+	JSONCalls []JSONCall
+
+	// EnvReads are the process.env variables the script looked up, in
+	// the order it first did.
+	EnvReads []EnvRead
+
+	// Expectations are the expect() checks, in the order they ran.
+	Expectations []Expectation
+
+	// Mutations tracks what each function changes when -detect-mutation is set.
+	Mutations *mutationTracker
+
+	// Profile collects per-stack and per-function time under -profile
+	// and -flamegraph.
+	Profile *profiler
+
+	// Recording collects the events of the run under -record.
+	Recording *Recording
+
+	// Checkpoints are the snapshots taken by breakpoints under
+	// -quiet-breakpoints, in hit order.
+	Checkpoints []Checkpoint
+
+	// Watches are the watched expressions, evaluated at every pause.
+	Watches []Watch
+
+	// Hits are the breakpoint hits whose condition held, in order.
+	Hits []BreakpointHit
+
+	// Thrown is the latest exception -break-on-exception paused at.
+	Thrown *Thrown
+
+	// Rejections are the reasons of the promises rejected without a
+	// handler, under -break-on-exception.
+	Rejections []string
+	unhandled  func() []string
+
+	// Calls is the -trace-calls call tree, entries and exits in order.
+	Calls  []CallEvent
+	tracer callTracer
+
+	// Events is every capture in run order, the value timeline; Started
+	// is when the run began, which the timeline is shown relative to.
+	Events  []ValueEvent
+	Started time.Time
+
+	// Async are the timers and awaits the script set and reached, in the
+	// order it first did. TimedOut is set when -timeout stopped the run,
+	// with Pending timers left.
+	Async    []*AsyncTask
+	TimedOut bool
+	Pending  int
+
+	// Coverage counts the runs of every statement under -coverage.
+	Coverage []CoveredStatement
+
+	// Limit tells which -max-loop-iterations or -max-memory limit stopped
+	// the run, if one did.
+	Limit string
+	heap  *heapWatcher
+
+	// asyncErr is the error a timer callback failed with; once halted,
+	// callbacks the loop still runs are skipped.
+	asyncErr error
+	halted   bool
+	guard    *loopGuard
+
+	// lastPause is the variables as the previous breakpoint or step left
+	// them, what -diff-only and `diff` compare with.
+	lastPause map[string]any
+
+	// paused is how long the script was paused at prompts, which the
+	// clock leaves out
+	paused time.Duration
+
+	// inspector is the -inspect server, which pauses in place of the
+	// prompt.
+	inspector *inspector
+
+	// plugins are the -plugin files loaded into the run; inPlugin is set
+	// while one of their hooks runs, so the instrumented code it calls
+	// doesn't call them again.
+	plugins  []*plugin
+	inPlugin bool
+
+	// Stack is the call stack of the breakpoint a snapshot is written
+	// for, or where a limit stopped the run; nil for the final snapshot.
+	Stack []Frame
+
+	// Declarations tell, per variable, where it was first captured and
+	// what type its latest capture had.
+	Declarations map[string]*Declaration
+
+	// Quit is set when the user stopped the script with `q` at a prompt.
+	Quit bool
+
+	// SkippedBreakpoints counts hits ignored once -max-breakpoints was reached.
+	SkippedBreakpoints int
+
+	// finishFinals reads the script-level -final-only getters.
+	finishFinals func()
+
+	// live keeps the latest JS value of each variable so collections can be
+	// measured as they're mutated in place.
+	live map[string]goja.Value
+
+	// latest is the scope of each variable's latest capture, whose
+	// history goes with the value the reports show for it.
+	latest map[string]int
+}
+
+// Declaration is where a variable was declared: the line and scope of its
+// first capture, which follows the declaration. Type is the JS type of its
+// latest capture.
+type Declaration struct {
+	Line  int
+	Scope int
+	Type  string
+}
+
+func (s *RunState) declare(name string, line, scope int, raw goja.Value, value any) {
+	if s.Declarations == nil {
+		s.Declarations = make(map[string]*Declaration)
+	}
+	d := s.Declarations[name]
+	if d == nil {
+		d = &Declaration{Line: line, Scope: scope}
+		s.Declarations[name] = d
+	}
+	d.Type = jsType(raw)
+	if t, ok := value.(typedArray); ok {
+		// typeof says object; the reports name the array's type
+		d.Type = t.Type
+	}
+}
+
+// jsType is typeof v, with "null" and "array" told apart from "object".
+func jsType(v goja.Value) string {
+	switch {
+	case v == nil || goja.IsUndefined(v):
+		return "undefined"
+	case goja.IsNull(v):
+		return "null"
+	}
+	if obj, ok := v.(*goja.Object); ok {
+		if _, callable := goja.AssertFunction(obj); callable {
+			return "function"
+		}
+		if obj.ClassName() == "Array" {
+			return "array"
+		}
+		return "object"
+	}
+	switch v.Export().(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case *big.Int:
+		return "bigint"
+	}
+	return "symbol"
+}
+
+// VarHistory is the sequence of values a variable was captured with.
+type VarHistory struct {
+	Values []any
+}
+
+// VarKey is a variable by name and the scope it was captured in, -1 when
+// its capture template passed none.
+type VarKey struct {
+	Name  string
+	Scope int
+}
+
+func (s *RunState) capture(name string, scope int, raw goja.Value, value any) {
+	if s.History == nil {
+		s.History = make(map[VarKey]*VarHistory)
+		s.live = make(map[string]goja.Value)
+		s.latest = make(map[string]int)
+	}
+	s.live[name] = raw
+	s.latest[name] = scope
+	key := VarKey{name, scope}
+	if s.History[key] == nil {
+		s.History[key] = &VarHistory{}
+	}
+	s.History[key].Values = append(s.History[key].Values, value)
+}
+
+// latestKey is name in the scope it was last captured in.
+func (s *RunState) latestKey(name string) VarKey {
+	return VarKey{name, s.latest[name]}
+}
+
+// describe renders value as `first → last` when the variable changed
+// since it was first captured, and as just the value otherwise. A value
+// too wide for that is shown alone, over several lines.
+func (s *RunState) describe(key VarKey, value any, opts *Config) string {
+	text := inspectLine(value, opts)
+	if h := s.History[key]; h != nil {
+		if initial := inspectLine(h.Values[0], opts); initial != text {
+			text = initial + " → " + text
+		}
+	}
+	return fitValue(text, value, opts.Indent, opts)
+}
+
+// history renders every value key was captured with, `0 → 1 → 2`, or
+// value alone when it was never captured.
+func (s *RunState) history(key VarKey, value any, opts *Config) string {
+	h := s.History[key]
+	if h == nil {
+		return inspectLine(value, opts)
+	}
+	rendered := make([]string, len(h.Values))
+	for i, v := range h.Values {
+		rendered[i] = inspectLine(v, opts)
+	}
+	return strings.Join(rendered, " → ")
+}
+
+// namedValue is a captured value with the name it was bound to.
+type namedValue struct {
+	Name  string
+	Value any
+}
+
+func (s *RunState) recordArgs(fn string, args []namedValue) {
+	if s.LastArgs == nil {
+		s.LastArgs = make(map[string][]namedValue)
+	}
+	s.LastArgs[fn] = args
+}
+
+// describeCall renders a call as `fn(a = 1, rest = [2 3])`.
+func describeCall(fn string, args []namedValue, limit int) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.Name + " = " + renderValue(a.Value, limit)
+	}
+	return fn + "(" + strings.Join(parts, ", ") + ")"
+}
+
+func (s *RunState) enter() {
+	s.depth++
+	if s.depth > s.MaxDepth {
+		s.MaxDepth = s.depth
+	}
+}
+
+func (s *RunState) exit() {
+	if s.depth > 0 {
+		s.depth--
+	}
+}
+
+// collectionSize reports the length of an array or the size of a Map/Set.
+func collectionSize(vm *goja.Runtime, v goja.Value) (label string, size int, ok bool) {
+	obj, isObj := v.(*goja.Object)
+	if !isObj {
+		return "", 0, false
+	}
+
+	if obj.ClassName() == "Array" {
+		return "length", int(obj.Get("length").ToInteger()), true
+	}
+	for _, ctor := range []string{"Map", "Set"} {
+		if c, isCtor := vm.Get(ctor).(*goja.Object); isCtor && vm.InstanceOf(obj, c) {
+			return "size", int(obj.Get("size").ToInteger()), true
+		}
+	}
+	return "", 0, false
+}
+
+// Checkpoint is the captured variables at one hit of a labelled
+// breakpoint. Hit counts from 1 per label.
+type Checkpoint struct {
+	Label  string
+	Hit    int
+	Values map[string]any
+}
+
+// Key names the checkpoint, adding the hit number when a label was hit
+// more than once.
+func (c Checkpoint) Key() string {
+	if c.Hit > 1 {
+		return fmt.Sprintf("%s#%d", c.Label, c.Hit)
+	}
+	return c.Label
+}
+
+func (s *RunState) recordCheckpoint(label string, debugInfo map[string]any) {
+	hit := 1
+	for _, c := range s.Checkpoints {
+		if c.Label == label {
+			hit++
+		}
+	}
+	values := make(map[string]any, len(debugInfo))
+	for k, v := range debugInfo {
+		values[k] = v
+	}
+	s.Checkpoints = append(s.Checkpoints, Checkpoint{Label: label, Hit: hit, Values: values})
+}
+
+// Message is one payload seen on a named channel.
+type Message struct {
+	Seq     int
+	Channel string
+	Value   any
+}
+
+// PhaseTimings splits a run into the debugger's own work and the script's.
+type PhaseTimings struct {
+	Instrument time.Duration
+	Execute    time.Duration
+	Write      time.Duration
+}
+
+func (t PhaseTimings) String() string {
+	round := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	return fmt.Sprintf("instrument: %v, execute: %v, write: %v", round(t.Instrument), round(t.Execute), round(t.Write))
+}
+
+// seriesStats summarises a numeric series.
+type seriesStats struct {
+	Count              int
+	Sum, Avg, Min, Max float64
+}
+
+func (s seriesStats) String() string {
+	num := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	return fmt.Sprintf("sum=%s avg=%s min=%s max=%s (n=%d)", num(s.Sum), num(s.Avg), num(s.Min), num(s.Max), s.Count)
+}
+
+// numericStats computes stats over values, or reports false when the
+// series is empty or holds anything but numbers.
+func numericStats(values []any) (seriesStats, bool) {
+	stats := seriesStats{Count: len(values), Min: math.Inf(1), Max: math.Inf(-1)}
+	if len(values) == 0 {
+		return stats, false
+	}
+	for _, v := range values {
+		var f float64
+		switch n := v.(type) {
+		case int64:
+			f = float64(n)
+		case float64:
+			f = n
+		default:
+			return stats, false
+		}
+		stats.Sum += f
+		stats.Min = math.Min(stats.Min, f)
+		stats.Max = math.Max(stats.Max, f)
+	}
+	stats.Avg = stats.Sum / float64(stats.Count)
+	return stats, true
+}
+
+// iterationDiff describes how the values captured in loop changed between
+// two 1-based iterations.
+func iterationDiff(loop LoopInfo, from, to, limit int) []string {
+	if from > len(loop.PerIteration) || to > len(loop.PerIteration) {
+		return []string{fmt.Sprintf("only %d iteration(s) captured values", len(loop.PerIteration))}
+	}
+	before, after := loop.PerIteration[from-1], loop.PerIteration[to-1]
+
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	var lines []string
+	unchanged := 0
+	for _, name := range sortedKeys(names) {
+		old, hadOld := before[name]
+		value, hasNew := after[name]
+		switch {
+		case !hadOld:
+			lines = append(lines, fmt.Sprintf("%s: (not captured) → %s", name, renderValue(value, limit)))
+		case !hasNew:
+			lines = append(lines, fmt.Sprintf("%s: %s → (not captured)", name, renderValue(old, limit)))
+		case renderValue(old, 0) != renderValue(value, 0):
+			lines = append(lines, fmt.Sprintf("%s: %s → %s", name, renderValue(old, limit), renderValue(value, limit)))
+		default:
+			unchanged++
+		}
+	}
+	if unchanged > 0 {
+		lines = append(lines, fmt.Sprintf("(%d unchanged)", unchanged))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no values captured in either iteration")
+	}
+	return lines
+}
+
+// finalGetter reads a variable's current value for -final-only.
+type finalGetter struct {
+	read  goja.Callable
+	line  int
+	scope int
+}
+
+// finalScope holds the getters registered while one function ran, in
+// declaration order; a redeclaration (e.g. in a loop) replaces the getter.
+type finalScope struct {
+	names   []string
+	getters map[string]finalGetter
+}
+
+func (s *finalScope) add(name string, g finalGetter) {
+	if s.getters == nil {
+		s.getters = make(map[string]finalGetter)
+	}
+	if _, seen := s.getters[name]; !seen {
+		s.names = append(s.names, name)
+	}
+	s.getters[name] = g
+}
@@ -0,0 +1,175 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/token"
+)
+
+// LoopStep is one entry of a loop's timeline: the loop variables as an
+// iteration starts, or as the loop exits, with the condition that decided it.
+type LoopStep struct {
+	Iteration int // 0 for the exit step
+	Values    map[string]any
+
+	Condition any
+	Tested    bool // whether the loop has a condition (for-of and for-in don't)
+}
+
+func (s LoopStep) render(names []string, limit int) string {
+	label := fmt.Sprintf("#%d", s.Iteration)
+	if s.Iteration == 0 {
+		label = "exit"
+	}
+	var parts []string
+	for _, name := range names {
+		if value, ok := s.Values[name]; ok {
+			parts = append(parts, fmt.Sprintf("%s = %s", name, renderValue(value, limit)))
+		}
+	}
+	text := label + ": " + strings.Join(parts, ", ")
+	if s.Tested {
+		text += fmt.Sprintf(" (condition: %s)", renderValue(s.Condition, limit))
+	}
+	return text
+}
+
+// writeTimeline lists the recorded steps of loop, and how many more the
+// -max-iterations cap left out.
+func writeTimeline(writer io.Writer, loop LoopInfo, indent string, opts *Config) {
+	if len(loop.Timeline) == 0 {
+		return
+	}
+	fmt.Fprintf(writer, "Timeline:\n")
+	for _, step := range loop.Timeline {
+		fmt.Fprintf(writer, "%s%s\n", indent, step.render(loop.Tracked, opts.MaxValueLen))
+	}
+	if loop.Dropped > 0 {
+		fmt.Fprintf(writer, "%s(%d more steps not recorded, raise -max-iterations to see them)\n", indent, loop.Dropped)
+	}
+}
+
+// recordStep adds a step to the timeline of loop, reading the loop
+// variables with getters, one per name in loop.Tracked. A getter that
+// throws (a variable in its temporal dead zone) leaves its value out.
+func recordStep(vm *goja.Runtime, loop *LoopInfo, step LoopStep, getters goja.Value, opts *Config) {
+	if opts.MaxIterations > 0 && len(loop.Timeline) >= opts.MaxIterations {
+		loop.Dropped++
+		return
+	}
+	step.Values = make(map[string]any)
+	if obj, ok := getters.(*goja.Object); ok {
+		for i, name := range loop.Tracked {
+			if read, ok := goja.AssertFunction(obj.Get(fmt.Sprint(i))); ok {
+				if value, err := read(goja.Undefined()); err == nil {
+					step.Values[name] = captureValue(vm, value)
+				}
+			}
+		}
+	}
+	loop.Timeline = append(loop.Timeline, step)
+}
+
+// hookRef points at an inserted hook whose text is only known once the
+// whole script has been walked.
+type hookRef struct {
+	list *[]insertion
+	i    int
+}
+
+// loopSnapshot is a hook of a loop whose text is format, with a %s for
+// the getters of the loop variables if the hook records the timeline.
+type loopSnapshot struct {
+	loop    int
+	ref     hookRef
+	format  string
+	records bool
+}
+
+// snapshot registers the timeline hook of loop id, given its tick. A loop
+// with a condition has it wrapped in __loop_test, checked before every
+// iteration and once more on exit; for-in, for-of and for (;;) report from
+// their tick, as does a do-while for the iteration it starts with.
+func (in *instrumenter) snapshot(id int, stmt ast.Statement, tick hookRef) {
+	var open, close int
+	switch s := stmt.(type) {
+	case *ast.ForStatement:
+		if s.Test == nil {
+			break
+		}
+		// bounded by the semicolons, so brackets around it are the test's
+		open, close = in.start(s.Test), in.end(s.Test)
+	case *ast.WhileStatement:
+		open = strings.IndexByte(in.src[in.offset(s.While):], '(') + in.offset(s.While) + 1
+		close = strings.LastIndexByte(in.src[:in.start(s.Body)], ')')
+	case *ast.DoWhileStatement:
+		end := in.statementEnd(s.Body)
+		open = strings.IndexByte(in.src[end:], '(') + end + 1
+		close = in.offset(s.RightParenthesis)
+	}
+
+	tested := close > 0
+	_, first := stmt.(*ast.DoWhileStatement)
+	in.snapshots = append(in.snapshots, loopSnapshot{id, tick, (*tick.list)[tick.i].text, !tested || first})
+	if tested {
+		in.open(open, fmt.Sprintf("__loop_test(%d, (", id))
+		in.close(close, "")
+		in.snapshots = append(in.snapshots, loopSnapshot{id, hookRef{&in.closes, len(in.closes) - 1}, ")%s)", true})
+	}
+}
+
+// assign notes that e, the target of an assignment or ++/--, is written
+// inside loop, so the variables it names are part of the loop's timeline.
+func (in *instrumenter) assign(loop int, e ast.Expression) {
+	if loop < 0 {
+		return
+	}
+	if u, ok := e.(*ast.UnaryExpression); ok {
+		if u.Operator != token.INCREMENT && u.Operator != token.DECREMENT {
+			return
+		}
+		e = u.Operand
+	}
+	in.assigned[loop] = append(in.assigned[loop], patternNames(e)...)
+}
+
+// finishLoops fills in the timeline hooks now that every loop's
+// assignments are known. A loop tracks its header variables and the outer
+// variables assigned in its body, such as accumulators; ones declared in
+// the body aren't in scope where the hook runs.
+func (in *instrumenter) finishLoops() {
+	getters := make([]string, len(in.loops))
+	for id := in.firstLoop; id < len(in.loops); id++ {
+		loop := &in.loops[id]
+		declared := make(map[string]bool)
+		for _, name := range loop.Variables[len(in.header[id]):] {
+			declared[name] = true
+		}
+
+		seen := make(map[string]bool)
+		var reads []string
+		for _, name := range slices.Concat(in.header[id], in.assigned[id]) {
+			if !seen[name] && !declared[name] {
+				seen[name] = true
+				loop.Tracked = append(loop.Tracked, name)
+				reads = append(reads, "() => "+name)
+			}
+		}
+		if len(reads) > 0 {
+			getters[id] = ", [" + strings.Join(reads, ", ") + "]"
+		}
+	}
+
+	for _, snap := range in.snapshots {
+		text := ""
+		if snap.records {
+			text = getters[snap.loop]
+		}
+		(*snap.ref.list)[snap.ref.i].text = fmt.Sprintf(snap.format, text)
+	}
+}
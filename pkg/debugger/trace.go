@@ -0,0 +1,116 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+)
+
+// CallEvent is a function call entered or left under -trace-calls. Depth
+// is 0 for calls from the top level.
+type CallEvent struct {
+	Depth int
+	Name  string
+	Exit  bool
+
+	// Args are the parameters of a call; Return the value a return
+	// statement left with, when Returned, unless the call threw.
+	Args     []namedValue
+	Return   any
+	Returned bool
+	Threw    bool
+}
+
+// render is the event as a line of the call tree, indented by depth.
+func (e CallEvent) render(indent string, limit int) string {
+	pad := strings.Repeat(indent, e.Depth)
+	switch {
+	case !e.Exit:
+		return pad + "→ " + describeCall(e.Name, e.Args, limit)
+	case e.Threw:
+		return fmt.Sprintf("%s← %s threw", pad, e.Name)
+	case !e.Returned:
+		return fmt.Sprintf("%s← %s", pad, e.Name)
+	}
+	return fmt.Sprintf("%s← %s = %s", pad, e.Name, renderValue(e.Return, limit))
+}
+
+// callTracer pairs the exits of -trace-calls with their calls.
+type callTracer struct {
+	open []*callFrame
+}
+
+type callFrame struct {
+	name     string
+	value    any
+	returned bool
+	threw    bool
+}
+
+// traceEnter records and prints the call of name with args.
+func (s *RunState) traceEnter(name string, args []namedValue, opts *Config) {
+	e := CallEvent{Depth: len(s.tracer.open), Name: name, Args: args}
+	s.tracer.open = append(s.tracer.open, &callFrame{name: name})
+	s.Calls = append(s.Calls, e)
+	fmt.Fprintf(opts.progress(), "|~| %s\n", e.render(opts.Indent, opts.MaxValueLen))
+}
+
+// traceExit records and prints the end of the innermost call.
+func (s *RunState) traceExit(opts *Config) {
+	n := len(s.tracer.open)
+	if n == 0 {
+		return
+	}
+	frame := s.tracer.open[n-1]
+	s.tracer.open = s.tracer.open[:n-1]
+	e := CallEvent{Depth: n - 1, Name: frame.name, Exit: true, Return: frame.value, Returned: frame.returned, Threw: frame.threw}
+	s.Calls = append(s.Calls, e)
+	fmt.Fprintf(opts.progress(), "|~| %s\n", e.render(opts.Indent, opts.MaxValueLen))
+}
+
+// traceReturn notes the value the innermost call returns.
+func (s *RunState) traceReturn(value any) {
+	if n := len(s.tracer.open); n > 0 {
+		s.tracer.open[n-1].value = value
+		s.tracer.open[n-1].returned = true
+	}
+}
+
+// traceThrow notes that the innermost call is left by an exception.
+func (s *RunState) traceThrow() {
+	if n := len(s.tracer.open); n > 0 {
+		s.tracer.open[n-1].threw = true
+	}
+}
+
+// traceReturnStatement makes `return x` hand x to __return under
+// -trace-calls, which passes it through.
+func (in *instrumenter) traceReturnStatement(s *ast.ReturnStatement) {
+	if !in.opts.TraceCalls || s.Argument == nil {
+		return
+	}
+	in.open(in.start(s.Argument), "__return(")
+	in.close(in.end(s.Argument), ")")
+}
+
+// writeCallTraceFile writes the -trace-calls call tree to trace.txt in
+// -out, or to the embedder's Report. With -format=json or html it is
+// part of output.json or output.html instead.
+func writeCallTraceFile(state *RunState, opts *Config) {
+	if !opts.TraceCalls || opts.singleReport() {
+		return
+	}
+	path := filepath.Join(opts.Out, "trace.txt")
+	err := writeReport(path, opts, func(w io.Writer) {
+		fmt.Fprintf(w, "=== CALL TRACE ===\n")
+		for _, e := range state.Calls {
+			fmt.Fprintf(w, "%s\n", e.render(opts.Indent, opts.MaxValueLen))
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(opts.Stderr, "Could not create %s: %v\n", path, err)
+	}
+}
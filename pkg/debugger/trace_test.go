@@ -0,0 +1,42 @@
+package debugger
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTraceCallsReturns(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string // the call tree, as -trace-calls prints it
+	}{
+		{
+			name:   "value",
+			script: "function add(a, b) { return a + b; }\nadd(1, 2);",
+			want:   []string{"→ add(a = 1, b = 2)", "← add = 3"},
+		},
+		{
+			name:   "private field",
+			script: "class C {\n  #p = 7;\n  read() { return this.#p; }\n}\nnew C().read();",
+			want:   []string{"→ read()", "← read = 7"},
+		},
+		{
+			name:   "new without arguments",
+			script: "function make() { return new Map(); }\nmake();",
+			want:   []string{"→ make()", "← make = Map(0) {}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runScript(t, tt.script, func(c *Config) { c.TraceCalls = true })
+			var got []string
+			for _, e := range r.State.Calls {
+				got = append(got, e.render("  ", 0))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,1599 @@
+package debugger
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// typeScriptExts are the extensions a script is transpiled for without
+// -lang, and a module it requires always.
+var typeScriptExts = map[string]bool{".ts": true, ".mts": true, ".cts": true}
+
+// typeScript reports whether the script is TypeScript: -lang ts, or a .ts
+// file without -lang.
+func (o *Config) typeScript() bool {
+	if o.Lang != "" {
+		return o.Lang == "ts"
+	}
+	return typeScriptExts[filepath.Ext(o.Script)]
+}
+
+// transpile turns TypeScript into JS the runtime runs. The types,
+// interfaces and other declarations only the compiler reads are blanked
+// out rather than removed, and what has to be rewritten (enums, parameter
+// properties, import and export) is spliced in on its own line, so every
+// line keeps its number and columns maps the rest back. module is set for
+// a required file, whose exports are assigned to exports; the script has
+// nowhere to export to and only drops them.
+func transpile(src, file string, module bool) (string, sourceMap, error) {
+	p := &tsStripper{src: src, file: file, module: module, toks: scanTS(src)}
+	p.statements("")
+	if p.err != nil {
+		return "", nil, p.err
+	}
+	if module && (p.esModule || len(p.exports) > 0) && len(p.toks) > 0 {
+		exports := append([]string{`Object.defineProperty(exports, "__esModule", { value: true });`}, p.exports...)
+		p.insert(p.toks[len(p.toks)-1].end, "; "+strings.Join(exports, " "))
+	}
+
+	out := []byte(src)
+	for _, b := range p.blanks {
+		for k := b[0]; k < b[1]; k++ {
+			if out[k] != '\n' && out[k] != '\r' {
+				out[k] = ' '
+			}
+		}
+	}
+	starts := []int{0}
+	for i, ch := range out {
+		if ch == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	sort.SliceStable(p.inserts, func(i, j int) bool { return p.inserts[i].pos < p.inserts[j].pos })
+	perLine := make(map[int][]insertion)
+	for _, in := range p.inserts {
+		line := sort.Search(len(starts), func(i int) bool { return starts[i] > in.pos })
+		perLine[line] = append(perLine[line], insertion{pos: in.pos - starts[line-1], text: in.text})
+	}
+
+	columns := make(sourceMap)
+	var js strings.Builder
+	for i, line := range strings.Split(string(out), "\n") {
+		if i > 0 {
+			js.WriteString("\n")
+		}
+		js.WriteString(applyInsertions(line, perLine[i+1]))
+		columns.record(i+1, perLine[i+1])
+	}
+	return js.String(), columns, nil
+}
+
+type tsKind int
+
+const (
+	tsPunct tsKind = iota
+	tsIdent
+	tsNumber
+	tsString
+	tsRegex
+	tsTemplate
+)
+
+// tsToken is a token of a TypeScript source. A template literal with
+// substitutions is a token per part: resumes is set for the parts after a
+// substitution, opens for the parts before one.
+type tsToken struct {
+	kind       tsKind
+	text       string
+	start, end int
+
+	// newline is set when a line break comes before it
+	newline bool
+
+	resumes, opens bool
+
+	// property is set for a name after a `.` or `?.`, `x.default`, which
+	// is no keyword even when it is spelled like one
+	property bool
+}
+
+// tsOperatorWords are the keywords after which an expression starts, so
+// that a `/` is a regular expression and a `<` no type argument list.
+var tsOperatorWords = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "export": true, "extends": true,
+	"finally": true, "for": true, "function": true, "if": true, "import": true, "in": true, "instanceof": true,
+	"let": true, "new": true, "of": true, "return": true, "switch": true, "throw": true, "try": true,
+	"typeof": true, "var": true, "void": true, "while": true, "with": true, "yield": true,
+}
+
+// endsExpression reports whether an expression can end with t.
+func (t *tsToken) endsExpression() bool {
+	switch t.kind {
+	case tsIdent:
+		return t.property || !tsOperatorWords[t.text]
+	case tsPunct:
+		switch t.text {
+		case ")", "]", "}", "++", "--":
+			return true
+		}
+		return false
+	case tsTemplate:
+		return !t.opens
+	}
+	return true
+}
+
+// tsPunctuators are the punctuators of more than one character, longest
+// first. `>` is always a token of its own so `Array<Array<T>>` closes
+// twice; nothing here tells a shift from two of them apart.
+var tsPunctuators = []string{
+	"...", "===", "!==", "**=", "<<=", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", "<<", "&&", "||", "??", "?.", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "**",
+}
+
+// scanTS splits src into tokens, leaving out comments. What it can't make
+// sense of, an unterminated string say, runs to the end of the source and
+// is left for the runtime to report.
+func scanTS(src string) []tsToken {
+	var toks []tsToken
+	var substitutions []int // brace depth at each open template substitution
+	depth, newline := 0, false
+	i := 0
+	if strings.HasPrefix(src, "#!") {
+		i = len(src)
+		if end := strings.IndexByte(src, '\n'); end >= 0 {
+			i = end
+		}
+	}
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			newline = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(src)
+			}
+			continue
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 4
+			}
+			if strings.Contains(src[i:i+2+end], "\n") {
+				newline = true
+			}
+			i += end + 4
+			continue
+		}
+
+		t := tsToken{start: i, newline: newline}
+		newline = false
+		switch {
+		case c == '"' || c == '\'':
+			t.kind, i = tsString, scanQuoted(src, i+1, c)
+		case c == '`':
+			t.kind = tsTemplate
+			i, t.opens = scanTemplate(src, i+1)
+		case c == '}' && len(substitutions) > 0 && substitutions[len(substitutions)-1] == depth:
+			substitutions = substitutions[:len(substitutions)-1]
+			t.kind, t.resumes = tsTemplate, true
+			i, t.opens = scanTemplate(src, i+1)
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			t.kind = tsNumber
+			for i++; i < len(src) && (isIdentPart(src[i]) || src[i] == '.' || (src[i] == '+' || src[i] == '-') && (src[i-1] == 'e' || src[i-1] == 'E') && !strings.HasPrefix(src[t.start:], "0x")); i++ {
+			}
+		case isIdentPart(c) || c == '#' || c == '\\':
+			t.kind = tsIdent
+			t.property = len(toks) > 0 && (toks[len(toks)-1].text == "." || toks[len(toks)-1].text == "?.")
+			for i++; i < len(src) && (isIdentPart(src[i]) || src[i] == '\\'); i++ {
+			}
+		case c == '/' && (len(toks) == 0 || !toks[len(toks)-1].endsExpression()):
+			t.kind, i = tsRegex, scanRegex(src, i+1)
+		default:
+			t.kind, i = tsPunct, i+1
+			if c != '>' {
+				for _, punct := range tsPunctuators {
+					// `a?.5:1` is a conditional, not optional chaining
+					if strings.HasPrefix(src[t.start:], punct) && !(punct == "?." && i+1 < len(src) && isDigit(src[i+1])) {
+						i = t.start + len(punct)
+						break
+					}
+				}
+			}
+			switch src[t.start:i] {
+			case "{":
+				depth++
+			case "}":
+				depth--
+			}
+		}
+		t.end = i
+		t.text = src[t.start:t.end]
+		if t.kind == tsTemplate && t.opens {
+			substitutions = append(substitutions, depth)
+		}
+		toks = append(toks, t)
+	}
+	return toks
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentPart(ch byte) bool {
+	return ch == '_' || ch == '$' || isDigit(ch) || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
+
+// scanQuoted returns the end of the string literal whose body starts at i.
+func scanQuoted(src string, i int, quote byte) int {
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return i
+}
+
+// scanTemplate returns the end of the template part starting at i, and
+// whether a substitution follows it.
+func scanTemplate(src string, i int) (int, bool) {
+	for ; i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case src[i] == '`':
+			return i + 1, false
+		case strings.HasPrefix(src[i:], "${"):
+			return i + 2, true
+		}
+	}
+	return i, false
+}
+
+// scanRegex returns the end of the regular expression literal whose body
+// starts at i, flags included.
+func scanRegex(src string, i int) int {
+	class := false
+	for ; i < len(src) && src[i] != '\n'; i++ {
+		switch c := src[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			for i++; i < len(src) && isIdentPart(src[i]); i++ {
+			}
+			return i
+		}
+	}
+	return i
+}
+
+// tsStripper walks the tokens of a TypeScript source, recording what to
+// blank out and what to splice in. It only follows the structure it needs
+// to tell a type annotation from an object key or a conditional.
+type tsStripper struct {
+	src    string
+	file   string
+	module bool
+	toks   []tsToken
+	i      int
+
+	// arrowReturn is set while skipping the return type of an arrow
+	// function, which ends at the first `=>`
+	arrowReturn bool
+
+	// stmt is the token the statement being walked starts at, which no
+	// expression ends before: `if (a) !b` isn't `a!`
+	stmt int
+
+	blanks  [][2]int
+	inserts []insertion
+
+	// exports are what a module assigns to exports once it ran; esModule is
+	// set when it exported anything in another way
+	exports  []string
+	esModule bool
+
+	err error
+}
+
+var tsEOF = &tsToken{}
+
+func (p *tsStripper) peek(n int) *tsToken {
+	if p.i+n >= len(p.toks) {
+		return tsEOF
+	}
+	return &p.toks[p.i+n]
+}
+
+func (p *tsStripper) tok() *tsToken {
+	return p.peek(0)
+}
+
+func (p *tsStripper) eof() bool {
+	return p.i >= len(p.toks)
+}
+
+func (p *tsStripper) at(text string) bool {
+	return !p.eof() && p.toks[p.i].text == text
+}
+
+func (p *tsStripper) skip(text string) {
+	if p.at(text) {
+		p.i++
+	}
+}
+
+// prevEnd reports whether the token before the current one ends an
+// expression.
+func (p *tsStripper) prevEnd() bool {
+	return p.i > 0 && p.i != p.stmt && p.toks[p.i-1].endsExpression()
+}
+
+// blank blanks out the tokens from from up to to, with what is between
+// them.
+func (p *tsStripper) blank(from, to int) {
+	if to > from {
+		p.blanks = append(p.blanks, [2]int{p.toks[from].start, p.toks[to-1].end})
+	}
+}
+
+// annotation blanks the `:` at the current token and the type after it.
+func (p *tsStripper) annotation() {
+	if p.at(":") {
+		from := p.i
+		p.i++
+		p.skipType()
+		p.blank(from, p.i)
+	}
+}
+
+func (p *tsStripper) insert(pos int, text string) {
+	p.inserts = append(p.inserts, insertion{pos: pos, text: text})
+}
+
+func (p *tsStripper) fail(t *tsToken, message string) {
+	if p.err != nil {
+		return
+	}
+	line := strings.Count(p.src[:t.start], "\n") + 1
+	column := t.start - strings.LastIndexByte(p.src[:t.start], '\n')
+	p.err = fmt.Errorf("%s: Line %d:%d %s", p.file, line, column, message)
+}
+
+// matching returns the index of the bracket closing the one at i, or the
+// end of the tokens when it isn't closed.
+func (p *tsStripper) matching(i int) int {
+	depth := 0
+	for j := i; j < len(p.toks); j++ {
+		switch p.toks[j].text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(p.toks)
+}
+
+// skipBracket moves past the bracket at the current token and what it
+// encloses.
+func (p *tsStripper) skipBracket() {
+	p.i = p.matching(p.i) + 1
+}
+
+// statements walks statements up to close, which it leaves to the caller;
+// "" walks to the end.
+func (p *tsStripper) statements(close string) {
+	for !p.eof() && (close == "" || !p.at(close)) {
+		start := p.i
+		p.statement()
+		if p.i == start {
+			p.i++ // a stray closing bracket
+		}
+	}
+}
+
+func (p *tsStripper) statement() {
+	start := p.i
+	p.stmt = start
+	t, next := p.tok(), p.peek(1)
+	switch {
+	case t.text == ";":
+		p.i++
+	case t.text == "{":
+		p.i++
+		p.statements("}")
+		p.skip("}")
+	case t.text == "@":
+		p.fail(t, "decorators are not supported")
+		p.i++
+	case p.declaration():
+	case t.text == "var" || t.text == "const" || t.text == "let" && (next.kind == tsIdent || next.text == "[" || next.text == "{"):
+		p.i++
+		p.declarators()
+	case t.text == "function" || t.text == "async" && next.text == "function" && !next.newline:
+		p.function(start)
+	case t.text == "class":
+		p.class()
+	case t.text == "if" || t.text == "while" || t.text == "for" || t.text == "with" || t.text == "switch":
+		p.i++
+		p.skip("await")
+		if p.at("(") {
+			p.i++
+			p.heads()
+			p.skip(")")
+		}
+	case t.text == "catch":
+		p.i++
+		if p.at("(") {
+			p.i++
+			p.params(nil)
+		}
+	case t.text == "case":
+		p.i++
+		p.expression(":")
+		p.skip(":")
+	case t.text == "default" && next.text == ":":
+		p.i += 2
+	case t.text == "else" || t.text == "do" || t.text == "try" || t.text == "finally":
+		p.i++
+	case t.text == "return" || t.text == "throw":
+		p.i++
+		if !p.tok().newline {
+			p.expression()
+		}
+	case t.text == "break" || t.text == "continue":
+		p.i++
+		if p.tok().kind == tsIdent && !p.tok().newline {
+			p.i++
+		}
+	case t.kind == tsIdent && next.text == ":" && !tsOperatorWords[t.text]:
+		p.i += 2 // a label
+	default:
+		p.expression()
+	}
+}
+
+// heads walks what is in the parentheses after if, for, while, with and
+// switch: expressions, and for a for loop a declaration, until the `)`.
+func (p *tsStripper) heads() {
+	for !p.eof() && !p.at(")") {
+		before := p.i
+		if t := p.tok(); t.text == "var" || t.text == "const" || t.text == "let" {
+			p.i++
+			p.declarators()
+		} else {
+			p.expression(",")
+		}
+		if p.at(";") || p.at(",") {
+			p.i++
+		}
+		if p.i == before {
+			p.i++
+		}
+	}
+}
+
+// declarators walks the declarators after let, const or var and returns
+// the names they bind without a pattern.
+func (p *tsStripper) declarators() []string {
+	var names []string
+	for !p.eof() {
+		if name := p.binding(); name != "" {
+			names = append(names, name)
+		}
+		if p.at("!") {
+			p.blank(p.i, p.i+1) // `let x!: number`, definitely assigned
+			p.i++
+		}
+		p.annotation()
+		if p.at("=") {
+			p.i++
+			p.expression(",")
+		}
+		if !p.at(",") {
+			return names
+		}
+		p.i++
+	}
+	return names
+}
+
+// binding walks a name being bound, which it returns, or a destructuring
+// pattern.
+func (p *tsStripper) binding() string {
+	switch t := p.tok(); {
+	case t.text == "{":
+		p.i++
+		p.object()
+		p.skip("}")
+	case t.text == "[":
+		p.i++
+		p.list("]")
+		p.skip("]")
+	case t.kind == tsIdent:
+		p.i++
+		return t.text
+	}
+	return ""
+}
+
+// asi reports whether the statement an expression is in ends before the
+// current token, on the line before, the way automatic semicolon insertion
+// would end it.
+func (p *tsStripper) asi() bool {
+	t := p.tok()
+	if !t.newline || !p.prevEnd() {
+		return false
+	}
+	switch t.kind {
+	case tsIdent:
+		return t.text != "in" && t.text != "instanceof"
+	case tsPunct:
+		return t.text == "{" || t.text == "++" || t.text == "--" || t.text == "@"
+	case tsTemplate:
+		return !t.resumes
+	}
+	return true
+}
+
+// expression walks an expression up to the end of its statement, the
+// bracket it is in or one of stops at its own level, which it leaves to
+// the caller.
+func (p *tsStripper) expression(stops ...string) {
+	start, conditionals := p.i, 0
+	for !p.eof() {
+		t := p.tok()
+		switch {
+		case t.kind == tsPunct && (t.text == ")" || t.text == "]" || t.text == "}" || t.text == ";"), t.resumes:
+			return
+		case p.i > start && p.asi():
+			return
+		case t.text == "?":
+			conditionals++
+			p.i++
+			continue
+		case t.text == ":" && conditionals > 0:
+			conditionals--
+			p.i++
+			continue
+		}
+		for _, stop := range stops {
+			if t.text == stop {
+				return
+			}
+		}
+		p.operand()
+	}
+}
+
+// operand walks the next piece of an expression, at least one token.
+func (p *tsStripper) operand() {
+	t, end := p.tok(), p.prevEnd()
+	async := p.i > 0 && p.toks[p.i-1].text == "async"
+	switch {
+	case t.text == "(" && (!end || async) && p.arrowAhead():
+		p.i++
+		p.params(nil)
+		close := p.i - 1
+		p.arrowReturn = true
+		p.annotation()
+		p.arrowReturn = false
+		if p.at("=>") && strings.Contains(p.src[p.toks[close].end:p.tok().start], "\n") {
+			// no line break can come before the =>, as one in the return
+			// type does once it is blanked, so the ) moves down to it
+			p.blank(close, close+1)
+			p.insert(p.tok().start, ")")
+		}
+	case t.text == "(":
+		p.i++
+		p.list(")")
+		p.skip(")")
+	case t.text == "[":
+		p.i++
+		p.list("]")
+		p.skip("]")
+	case t.text == "{":
+		p.i++
+		p.object()
+		p.skip("}")
+	case t.text == "=>":
+		p.i++
+		if p.at("{") {
+			p.i++
+			p.statements("}")
+			p.skip("}")
+		}
+	case t.kind == tsTemplate && t.opens:
+		p.i++
+		for !p.eof() {
+			p.expression()
+			if !p.tok().resumes {
+				break
+			}
+			if p.i++; !p.toks[p.i-1].opens {
+				break
+			}
+		}
+	case t.text == "function" || t.text == "async" && p.peek(1).text == "function" && !p.peek(1).newline:
+		p.function(-1)
+	case t.text == "class":
+		p.class()
+	case (t.text == "as" || t.text == "satisfies") && end && !t.newline:
+		// `x as T`, `x as const`, `x satisfies T`
+		from := p.i
+		if p.i++; p.at("const") {
+			p.i++
+		} else {
+			p.skipType()
+		}
+		p.blank(from, p.i)
+	case t.text == "!" && end && !t.newline:
+		// `x!`, not null
+		p.blank(p.i, p.i+1)
+		p.i++
+	case t.text == "<" && end:
+		// `f<T>(x)`, `new Map<K, V>()`; otherwise a comparison
+		from := p.i
+		if p.typeArgsAhead() && p.skipAngles() {
+			p.blank(from, p.i)
+		} else {
+			p.i++
+		}
+	case t.text == "<":
+		// the type parameters of a generic arrow function, `<T>(x: T) => x`,
+		// or a type assertion, `<T>x`
+		from := p.i
+		if p.skipAngles() {
+			p.blank(from, p.i)
+		} else {
+			p.i++
+		}
+	default:
+		p.i++
+	}
+}
+
+// list walks the comma-separated expressions in a bracket up to close.
+func (p *tsStripper) list(close string) {
+	for !p.eof() && !p.at(close) {
+		before := p.i
+		p.expression(",")
+		p.skip(",")
+		if p.i == before {
+			p.i++
+		}
+	}
+}
+
+// arrowAhead reports whether the `(` at the current token starts the
+// parameters of an arrow function, from the `=>` after the `)`, with a
+// return type maybe in between.
+func (p *tsStripper) arrowAhead() bool {
+	save := p.i
+	defer func() { p.i = save }()
+	p.i = p.matching(p.i) + 1
+	if p.at(":") {
+		p.i++
+		p.arrowReturn = true
+		p.skipType()
+		p.arrowReturn = false
+	}
+	return p.at("=>")
+}
+
+// typeArgsAhead reports whether the `<` at the current token, after an
+// expression, starts the type arguments of a call.
+func (p *tsStripper) typeArgsAhead() bool {
+	save := p.i
+	defer func() { p.i = save }()
+	if !p.skipAngles() {
+		return false
+	}
+	t := p.tok()
+	return t.text == "(" || t.kind == tsTemplate && !t.resumes
+}
+
+// skipAngles moves past the type parameters or arguments at the current
+// `<` and reports whether they were; it doesn't move when the `<` turns
+// out to be a comparison.
+func (p *tsStripper) skipAngles() bool {
+	save, depth := p.i, 0
+	for !p.eof() {
+		t := p.tok()
+		switch {
+		case t.text == "<":
+			depth++
+		case t.text == ">":
+			if depth--; depth == 0 {
+				p.i++
+				return true
+			}
+		case t.text == "(" || t.text == "[" || t.text == "{":
+			p.skipBracket()
+			continue
+		case t.kind == tsPunct:
+			switch t.text {
+			case ",", ".", "|", "&", ":", "?", "=>", "=", "...", "-":
+			default:
+				p.i = save // an operator: a comparison after all
+				return false
+			}
+		}
+		p.i++
+	}
+	p.i = save
+	return false
+}
+
+// skipType moves past a type, as far as it goes.
+func (p *tsStripper) skipType() {
+	p.skipUnion()
+	if p.at("extends") && !p.tok().newline {
+		// a conditional type, `T extends U ? X : Y`
+		p.i++
+		p.skipUnion()
+		if p.at("?") {
+			p.i++
+			p.skipType()
+			if p.at(":") {
+				p.i++
+				p.skipType()
+			}
+		}
+	}
+}
+
+// skipUnion moves past a union or intersection of types, or just one.
+func (p *tsStripper) skipUnion() {
+	if p.at("|") || p.at("&") {
+		p.i++
+	}
+	p.typeOperand()
+	for p.at("|") || p.at("&") {
+		p.i++
+		p.typeOperand()
+	}
+}
+
+func (p *tsStripper) typeOperand() {
+	for {
+		t, next := p.tok(), p.peek(1)
+		switch {
+		case (t.text == "keyof" || t.text == "unique" || t.text == "readonly") && !next.newline && (next.kind == tsIdent || next.text == "(" || next.text == "[" || next.text == "{"):
+			p.i++
+			continue
+		case t.text == "infer" && next.kind == tsIdent:
+			p.i += 2
+			return
+		case t.text == "asserts" && next.kind == tsIdent && !next.newline:
+			p.i++
+			continue
+		}
+		break
+	}
+
+	switch t := p.tok(); {
+	case t.text == "typeof":
+		p.i++
+		for p.tok().kind == tsIdent {
+			if p.i++; !p.at(".") {
+				break
+			}
+			p.i++
+		}
+	case t.text == "(":
+		// a function type or a type in parentheses; an arrow function's
+		// return type can't be a function type without them
+		p.skipBracket()
+		if p.at("=>") && !p.arrowReturn {
+			p.i++
+			p.skipType()
+			return
+		}
+	case t.text == "new" || t.text == "abstract" && p.peek(1).text == "new" || t.text == "<":
+		// a constructor type, or a generic function type
+		for p.at("abstract") || p.at("new") {
+			p.i++
+		}
+		if p.at("<") {
+			p.skipAngles()
+		}
+		if p.at("(") {
+			p.skipBracket()
+		}
+		if p.at("=>") {
+			p.i++
+			p.skipType()
+		}
+		return
+	case t.text == "{" || t.text == "[":
+		p.skipBracket()
+	case t.kind == tsTemplate && t.opens:
+		// a template literal type, `prefix-${string}`
+		for p.i++; !p.eof(); {
+			part := p.tok()
+			p.i++
+			if part.resumes && !part.opens {
+				break
+			}
+		}
+	case t.kind == tsString || t.kind == tsNumber || t.kind == tsTemplate:
+		p.i++
+	case t.text == "-" && p.peek(1).kind == tsNumber:
+		p.i += 2
+	case t.kind == tsIdent:
+		p.i++
+		for p.at(".") && p.peek(1).kind == tsIdent {
+			p.i += 2
+		}
+		if p.at("<") && !p.tok().newline {
+			p.skipAngles()
+		}
+		if p.at("is") && !p.tok().newline {
+			// a type predicate, `x is string`
+			p.i++
+			p.skipType()
+			return
+		}
+	default:
+		return
+	}
+	for p.at("[") && !p.tok().newline {
+		p.skipBracket()
+	}
+}
+
+// params walks a parameter list from after its `(` to past its `)`. For a
+// constructor, properties collects the parameters declared as properties,
+// with public, private, protected or readonly.
+func (p *tsStripper) params(properties *[]string) {
+	first := true
+	for !p.eof() && !p.at(")") {
+		before := p.i
+		if first && p.at("this") && p.peek(1).text == ":" {
+			// the type of this, not a parameter
+			from := p.i
+			p.i++
+			p.annotation()
+			p.skip(",")
+			p.blank(from, p.i)
+			continue
+		}
+		first = false
+
+		from := p.i
+		for tsParamModifiers[p.tok().text] && (p.peek(1).kind == tsIdent || p.peek(1).text == "{" || p.peek(1).text == "[") {
+			p.i++
+		}
+		modified := p.i > from
+		p.blank(from, p.i)
+		p.skip("...")
+		if name := p.binding(); modified && name != "" && properties != nil {
+			*properties = append(*properties, name)
+		}
+		if p.at("?") {
+			p.blank(p.i, p.i+1)
+			p.i++
+		}
+		p.annotation()
+		if p.at("=") {
+			p.i++
+			p.expression(",")
+		}
+		p.skip(",")
+		if p.i == before {
+			p.i++
+		}
+	}
+	p.skip(")")
+}
+
+var tsParamModifiers = map[string]bool{"public": true, "private": true, "protected": true, "readonly": true, "override": true}
+
+// object walks an object literal or pattern from after its `{` up to its
+// `}`.
+func (p *tsStripper) object() {
+	for !p.eof() && !p.at("}") {
+		before := p.i
+		if p.at("...") {
+			p.i++
+			p.expression(",")
+		} else {
+			for (p.at("get") || p.at("set") || p.at("async") || p.at("*")) && p.startsKey(p.peek(1)) {
+				p.i++
+			}
+			p.key()
+			switch {
+			case p.at("(") || p.at("<"):
+				p.signature(nil)
+			case p.at(":") || p.at("="):
+				p.i++
+				p.expression(",")
+			}
+		}
+		p.skip(",")
+		if p.i == before {
+			p.i++
+		}
+	}
+}
+
+// startsKey reports whether t can start the name of a property or method.
+func (p *tsStripper) startsKey(t *tsToken) bool {
+	return t.kind == tsIdent || t.kind == tsString || t.kind == tsNumber || t.text == "[" || t.text == "*" || t.text == "{"
+}
+
+// key walks the name of a property or method.
+func (p *tsStripper) key() {
+	if p.at("[") {
+		p.i++
+		p.expression()
+		p.skip("]")
+		return
+	}
+	if t := p.tok(); t.kind != tsPunct {
+		p.i++
+	}
+}
+
+// signature walks a function from its type parameters, if any: the
+// parameters, the return type and the body. It reports whether there was
+// a body; an overload's signature or an abstract method has none. ctor is
+// set for a class's constructor, whose parameter properties it assigns at
+// the start of the body, after super() in a derived class.
+func (p *tsStripper) signature(ctor *tsConstructor) bool {
+	if p.at("<") {
+		from := p.i
+		if p.skipAngles() {
+			p.blank(from, p.i)
+		}
+	}
+	if !p.at("(") {
+		return false
+	}
+	p.i++
+	var properties []string
+	p.params(&properties)
+	p.annotation()
+	if !p.at("{") {
+		return false
+	}
+
+	if ctor != nil && len(properties) > 0 {
+		assignments := make([]string, len(properties))
+		for i, name := range properties {
+			assignments[i] = fmt.Sprintf("this.%s = %s;", name, name)
+		}
+		pos, text := p.tok().end, " "+strings.Join(assignments, " ")
+		if ctor.derived {
+			for j, body := p.i+1, p.matching(p.i); j < body; j++ {
+				if p.toks[j].text == "super" && j+1 < body && p.toks[j+1].text == "(" {
+					pos, text = p.toks[p.matching(j+1)].end, "; "+strings.Join(assignments, " ")
+					break
+				}
+			}
+		}
+		p.insert(pos, text)
+	}
+	p.i++
+	p.statements("}")
+	p.skip("}")
+	return true
+}
+
+// tsConstructor is what signature needs to know of a constructor's class.
+type tsConstructor struct {
+	derived bool
+}
+
+// function walks a function from its `function` or `async` keyword and
+// returns its name. A declaration without a body, an overload's signature,
+// is blanked from start, where an export or declare in front of it is;
+// start is -1 for a function expression.
+func (p *tsStripper) function(start int) string {
+	p.skip("async")
+	p.i++
+	p.skip("*")
+	name := ""
+	if t := p.tok(); t.kind == tsIdent {
+		name = t.text
+		p.i++
+	}
+	if !p.signature(nil) && start >= 0 {
+		p.skip(";")
+		p.blank(start, p.i)
+		return ""
+	}
+	return name
+}
+
+// class walks a class from its `class` keyword and returns its name.
+func (p *tsStripper) class() string {
+	p.i++
+	name := ""
+	if t := p.tok(); t.kind == tsIdent && t.text != "extends" && t.text != "implements" {
+		name = t.text
+		p.i++
+	}
+	if p.at("<") {
+		from := p.i
+		if p.skipAngles() {
+			p.blank(from, p.i)
+		}
+	}
+	derived := p.at("extends")
+	if derived {
+		// the base is an expression, with type arguments maybe: `Base<T>`
+		for p.i++; !p.eof() && !p.at("{") && !p.at("implements"); {
+			if from := p.i; p.at("<") && p.skipAngles() {
+				p.blank(from, p.i)
+				continue
+			}
+			p.operand()
+		}
+	}
+	if p.at("implements") {
+		from := p.i
+		for p.i++; !p.eof() && !p.at("{"); {
+			p.skip(",")
+			p.skipType()
+			if !p.at(",") {
+				break
+			}
+		}
+		p.blank(from, p.i)
+	}
+	if p.at("{") {
+		p.i++
+		for !p.eof() && !p.at("}") {
+			before := p.i
+			p.member(derived)
+			if p.i == before {
+				p.i++
+			}
+		}
+		p.skip("}")
+	}
+	return name
+}
+
+// tsMemberModifiers are the modifiers in front of a class member, true for
+// those TypeScript adds, which are blanked. declare and abstract members
+// are only types and go entirely.
+var tsMemberModifiers = map[string]bool{
+	"public": true, "private": true, "protected": true, "readonly": true, "override": true,
+	"declare": true, "abstract": true, "static": false, "async": false, "get": false, "set": false, "accessor": false,
+}
+
+// member walks one member of a class body.
+func (p *tsStripper) member(derived bool) {
+	start := p.i
+	if p.at(";") {
+		p.i++
+		return
+	}
+	if p.at("@") {
+		p.fail(p.tok(), "decorators are not supported")
+		p.i++
+		return
+	}
+	typeOnly := false
+	for {
+		t := p.tok()
+		blanked, ok := tsMemberModifiers[t.text]
+		if !ok || !p.startsKey(p.peek(1)) {
+			break
+		}
+		if blanked {
+			typeOnly = typeOnly || t.text == "declare" || t.text == "abstract"
+			p.blank(p.i, p.i+1)
+		}
+		p.i++
+	}
+	if p.at("{") {
+		// a static block
+		p.i++
+		p.statements("}")
+		p.skip("}")
+		return
+	}
+	if p.at("[") && p.peek(1).kind == tsIdent && p.peek(2).text == ":" {
+		// an index signature, `[key: string]: T`
+		p.skipBracket()
+		p.annotation()
+		p.skip(";")
+		p.blank(start, p.i)
+		return
+	}
+
+	p.skip("*")
+	name := p.tok().text
+	p.key()
+	if p.at("?") || p.at("!") {
+		p.blank(p.i, p.i+1)
+		p.i++
+	}
+	if p.at("(") || p.at("<") {
+		var ctor *tsConstructor
+		if name == "constructor" || name == `"constructor"` || name == "'constructor'" {
+			ctor = &tsConstructor{derived: derived}
+		}
+		if !p.signature(ctor) || typeOnly {
+			p.skip(";")
+			p.blank(start, p.i)
+		}
+		return
+	}
+	p.annotation()
+	if p.at("=") {
+		p.i++
+		p.expression()
+	}
+	p.skip(";")
+	if typeOnly {
+		p.blank(start, p.i)
+	}
+}
+
+// declaration handles the statements TypeScript adds or changes: it blanks
+// the declarations only the compiler reads and rewrites enums, import and
+// export. It reports whether the statement was one of them.
+func (p *tsStripper) declaration() bool {
+	start := p.i
+	t, next := p.tok(), p.peek(1)
+	switch {
+	case p.typeOnly(start):
+	case t.text == "abstract" && next.text == "class" && !next.newline:
+		p.blank(p.i, p.i+1)
+		p.i++
+		p.class()
+	case t.text == "enum" && next.kind == tsIdent && !next.newline, t.text == "const" && next.text == "enum":
+		p.enum(start)
+	case (t.text == "namespace" || t.text == "module") && (next.kind == tsIdent || next.kind == tsString) && !next.newline && (p.peek(2).text == "{" || p.peek(2).text == "."):
+		p.fail(t, "namespaces are not supported; use modules")
+		p.i += 2
+	case t.text == "import" && next.text != "(" && next.text != ".":
+		p.importDeclaration()
+	case t.text == "export":
+		p.exportDeclaration()
+	default:
+		return false
+	}
+	return true
+}
+
+// typeOnly blanks, from start, a declaration at the current token that
+// only the compiler reads: an interface, a type alias or anything
+// declared. It reports whether there was one.
+func (p *tsStripper) typeOnly(start int) bool {
+	t, next := p.tok(), p.peek(1)
+	if next.newline || next.kind != tsIdent && next.kind != tsString {
+		return false
+	}
+	switch t.text {
+	case "interface":
+		p.i += 2
+		p.skipToBody()
+	case "type":
+		if after := p.peek(2).text; after != "=" && after != "<" {
+			return false
+		}
+		p.i += 2
+		if p.at("<") {
+			p.skipAngles()
+		}
+		p.skip("=")
+		p.skipType()
+	case "declare":
+		p.i++
+		switch p.tok().text {
+		case "const", "let", "var":
+			p.i++
+			for !p.eof() {
+				p.binding()
+				p.annotation()
+				if !p.at(",") {
+					break
+				}
+				p.i++
+			}
+		case "function":
+			p.i++
+			p.skip("*")
+			if p.tok().kind == tsIdent {
+				p.i++
+			}
+			if p.at("<") {
+				p.skipAngles()
+			}
+			if p.at("(") {
+				p.skipBracket()
+			}
+			p.annotation()
+		case "type":
+			return p.typeOnly(start)
+		default:
+			// class, enum, namespace, module, global, interface
+			p.skipToBody()
+		}
+	default:
+		return false
+	}
+	p.skip(";")
+	p.blank(start, p.i)
+	return true
+}
+
+// skipToBody moves past a declaration's heading and the block after it,
+// when it has one: `declare module "x";` doesn't.
+func (p *tsStripper) skipToBody() {
+	for !p.eof() && !p.at("{") && !p.at(";") {
+		if p.at("<") && p.skipAngles() {
+			continue
+		}
+		p.i++
+	}
+	if p.at("{") {
+		p.skipBracket()
+	}
+}
+
+// enum rewrites an enum the way tsc does, as an object the members are
+// added to in turn, numbers mapped both ways, keeping each member on its
+// line:
+//
+//	var Color = (Color => (Color[Color["Red"] = 0] = "Red", ..., Color))({});
+//
+// An initializer can use the members before it as Color.Red, not by their
+// bare names. It returns the enum's name.
+func (p *tsStripper) enum(start int) string {
+	p.skip("const")
+	p.i++
+	name := p.tok().text
+	p.i++
+	if !p.at("{") {
+		return ""
+	}
+	p.insert(p.toks[start].start, fmt.Sprintf("var %s = (%s => (", name, name))
+	p.i++
+	previous := ""
+	for !p.eof() && !p.at("}") {
+		before := p.i
+		key := p.tok()
+		member := key.text
+		if key.kind == tsString {
+			if unquoted, err := strconv.Unquote(strings.ReplaceAll(member, "'", `"`)); err == nil {
+				member = unquoted
+			}
+		}
+		p.i++
+		ref := fmt.Sprintf("%s[%s]", name, strconv.Quote(member))
+
+		value := "0"
+		switch {
+		case p.at("="):
+			p.i++
+			from := p.i
+			p.expression(",")
+			if p.i == from {
+				break
+			}
+			// on one line, so the lines after keep their numbers
+			value = strings.NewReplacer("\r", " ", "\n", " ").Replace(p.src[p.toks[from].start:p.toks[p.i-1].end])
+			if p.i == from+1 && (p.toks[from].kind == tsString || p.toks[from].kind == tsTemplate) {
+				// a string member isn't mapped back from its value
+				p.insert(key.start, fmt.Sprintf("%s = %s, ", ref, value))
+				previous = ref
+				p.skip(",")
+				continue
+			}
+		case previous != "":
+			value = previous + " + 1"
+		}
+		p.insert(key.start, fmt.Sprintf("%s[%s = %s] = %s, ", name, ref, value, strconv.Quote(member)))
+		previous = ref
+		p.skip(",")
+		if p.i == before {
+			p.i++
+		}
+	}
+	if !p.eof() {
+		p.insert(p.tok().start, name+"))({});")
+	}
+	p.skip("}")
+	p.blank(start, p.i)
+	return name
+}
+
+// importDeclaration rewrites an import as the require() it stands for,
+// `import x, { a, b as c } from "m"` as
+//
+//	const x = (m => m && m.__esModule ? m.default : m)(require("m")), { a, b: c } = require("m");
+//
+// A default import is the module itself unless it was transpiled from a
+// module with exports of its own. Imports of types only are dropped.
+func (p *tsStripper) importDeclaration() {
+	start := p.i
+	p.i++
+	if t := p.tok(); t.kind == tsIdent && p.peek(1).text == "=" {
+		// `import x = require("m")`, or an alias, `import x = ns.y`
+		p.blank(start, start+1)
+		p.insert(p.toks[start].start, "const")
+		p.i += 2
+		p.expression()
+		return
+	}
+	if p.at("type") && p.peek(1).text != "from" && p.peek(1).text != "," {
+		for !p.eof() && !p.at(";") && p.tok().kind != tsString {
+			p.i++
+		}
+		p.i++
+		p.skip(";")
+		p.blank(start, p.i)
+		return
+	}
+
+	var defaultName, namespace string
+	var names []string
+	if t := p.tok(); t.kind == tsIdent && !(t.text == "from" && p.peek(1).kind == tsString) {
+		defaultName = t.text
+		p.i++
+		p.skip(",")
+	}
+	if p.at("*") {
+		p.i++
+		p.skip("as")
+		namespace = p.tok().text
+		p.i++
+	}
+	if p.at("{") {
+		p.i++
+		for !p.eof() && !p.at("}") {
+			typeOnly := p.at("type") && p.peek(1).text != "," && p.peek(1).text != "}" && p.peek(1).text != "as"
+			if typeOnly {
+				p.i++
+			}
+			imported := p.tok().text
+			local := imported
+			p.i++
+			if p.at("as") {
+				p.i++
+				local = p.tok().text
+				p.i++
+			}
+			if !typeOnly {
+				if local != imported {
+					local = imported + ": " + local
+				}
+				names = append(names, local)
+			}
+			p.skip(",")
+		}
+		p.skip("}")
+	}
+	p.skip("from")
+	specifier := p.tok().text
+	p.i++
+	if p.at("with") || p.at("assert") {
+		p.i++
+		if p.at("{") {
+			p.skipBracket()
+		}
+	}
+	p.skip(";")
+	p.blank(start, p.i)
+
+	require := "require(" + specifier + ")"
+	var bindings []string
+	if defaultName != "" {
+		bindings = append(bindings, defaultName+" = (m => m && m.__esModule ? m.default : m)("+require+")")
+	}
+	if namespace != "" {
+		bindings = append(bindings, namespace+" = "+require)
+	}
+	if len(names) > 0 {
+		bindings = append(bindings, "{ "+strings.Join(names, ", ")+" } = "+require)
+	}
+	switch {
+	case len(bindings) > 0:
+		p.insert(p.toks[start].start, "const "+strings.Join(bindings, ", ")+";")
+	case defaultName == "" && namespace == "" && !strings.Contains(p.src[p.toks[start].start:p.toks[p.i-1].end], "{"):
+		// `import "m"`, for what it does
+		p.insert(p.toks[start].start, require+";")
+	}
+}
+
+// exportDeclaration handles `export` in front of a declaration and the
+// export lists. A module assigns what it exports to exports once it ran;
+// the script only drops them.
+func (p *tsStripper) exportDeclaration() {
+	start := p.i
+	p.i++
+	t, next := p.tok(), p.peek(1)
+	switch {
+	case p.typeOnly(start):
+	case t.text == "type" && (next.text == "{" || next.text == "*"):
+		// `export type { A } from "m"`, `export type * from "m"`
+		if p.i++; p.at("{") {
+			p.skipBracket()
+		} else {
+			p.i++
+		}
+		if p.at("as") {
+			p.i += 2
+		}
+		if p.at("from") {
+			p.i += 2
+		}
+		p.skip(";")
+		p.blank(start, p.i)
+	case t.text == "default":
+		p.i++
+		next := p.tok()
+		switch {
+		case p.typeOnly(start):
+		case next.text == "function" || next.text == "async" && p.peek(1).text == "function" || next.text == "class" || next.text == "abstract" && p.peek(1).text == "class":
+			p.blank(start, p.i)
+			if p.at("abstract") {
+				p.blank(p.i, p.i+1)
+				p.i++
+			}
+			var name string
+			if p.at("class") {
+				name = p.class()
+			} else {
+				name = p.function(-1)
+			}
+			if name != "" {
+				p.export("default", name)
+				return
+			}
+			// an anonymous default: there is nothing to assign it from
+			// afterwards, so it is assigned where it is
+			if p.module {
+				p.esModule = true
+				p.insert(next.start, "exports.default = ")
+			}
+		default:
+			p.blank(start, p.i)
+			if p.module {
+				p.esModule = true
+				p.insert(p.toks[start].start, "exports.default =")
+			}
+			p.expression()
+		}
+	case t.text == "=":
+		p.blank(start, p.i)
+		if p.module {
+			p.insert(p.toks[start].start, "module.exports")
+		}
+		p.i++
+		p.expression()
+	case t.text == "*":
+		namespace := ""
+		if p.i++; p.at("as") {
+			p.i++
+			namespace = p.tok().text
+			p.i++
+		}
+		p.skip("from")
+		require := "require(" + p.tok().text + ")"
+		p.i++
+		p.skip(";")
+		p.blank(start, p.i)
+		switch {
+		case !p.module:
+			p.insert(p.toks[start].start, require+";")
+		case namespace != "":
+			p.esModule = true
+			p.insert(p.toks[start].start, fmt.Sprintf("exports.%s = %s;", namespace, require))
+		default:
+			p.esModule = true
+			p.insert(p.toks[start].start, fmt.Sprintf("Object.assign(exports, %s);", require))
+		}
+	case t.text == "{":
+		p.i++
+		var local, exported []string
+		for !p.eof() && !p.at("}") {
+			typeOnly := p.at("type") && p.peek(1).text != "," && p.peek(1).text != "}" && p.peek(1).text != "as"
+			if typeOnly {
+				p.i++
+			}
+			name, as := p.tok().text, p.tok().text
+			p.i++
+			if p.at("as") {
+				p.i++
+				as = p.tok().text
+				p.i++
+			}
+			if !typeOnly {
+				local, exported = append(local, name), append(exported, as)
+			}
+			p.skip(",")
+		}
+		p.skip("}")
+		require := ""
+		if p.at("from") {
+			p.i++
+			require = "require(" + p.tok().text + ")"
+			p.i++
+		}
+		p.skip(";")
+		p.blank(start, p.i)
+		switch {
+		case require == "":
+			for i := range local {
+				p.export(exported[i], local[i])
+			}
+		case !p.module:
+			p.insert(p.toks[start].start, require+";")
+		default:
+			p.esModule = true
+			assignments := make([]string, len(local))
+			for i := range local {
+				assignments[i] = fmt.Sprintf("exports.%s = %s.%s;", exported[i], require, local[i])
+			}
+			p.insert(p.toks[start].start, strings.Join(assignments, " "))
+		}
+	case t.text == "var" || t.text == "let" || t.text == "const" && next.text != "enum":
+		p.blank(start, p.i)
+		p.i++
+		for _, name := range p.declarators() {
+			p.export(name, name)
+		}
+	case t.text == "enum" || t.text == "const":
+		if name := p.enum(start); name != "" {
+			p.export(name, name)
+		}
+	case t.text == "function" || t.text == "async":
+		p.blank(start, p.i)
+		if name := p.function(start); name != "" {
+			p.export(name, name)
+		}
+	case t.text == "class" || t.text == "abstract":
+		p.blank(start, p.i)
+		if p.at("abstract") {
+			p.blank(p.i, p.i+1)
+			p.i++
+		}
+		if name := p.class(); name != "" {
+			p.export(name, name)
+		}
+	default:
+		p.blank(start, p.i)
+	}
+}
+
+// export notes that a module exports local as name.
+func (p *tsStripper) export(name, local string) {
+	if p.module {
+		p.exports = append(p.exports, fmt.Sprintf("exports.%s = %s;", name, local))
+	}
+}
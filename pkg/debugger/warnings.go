@@ -0,0 +1,108 @@
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+)
+
+// Warning is a diagnostic about the script, found either statically or
+// from the values it produced.
+type Warning struct {
+	Type    string `json:"type"`
+	File    string `json:"file,omitempty"` // the module it is in, "" for the script
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	if w.File != "" {
+		return fmt.Sprintf("%s line %d [%s]: %s", w.File, w.Line, w.Type, w.Message)
+	}
+	return fmt.Sprintf("line %d [%s]: %s", w.Line, w.Type, w.Message)
+}
+
+// writeWarningsJSON writes warnings as a JSON array for editor tooling.
+func writeWarningsJSON(path string, warnings []Warning, indent string) error {
+	if warnings == nil {
+		warnings = []Warning{}
+	}
+	data, err := json.MarshalIndent(warnings, "", indent)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// unreachable flags the first statement following an unconditional
+// return/break/continue/throw in list. Function declarations are hoisted,
+// so one there is still reachable and is skipped.
+func (in *instrumenter) unreachable(list []ast.Statement) {
+	for i, stmt := range list {
+		var keyword string
+		switch s := stmt.(type) {
+		case *ast.ReturnStatement:
+			keyword = "return"
+		case *ast.ThrowStatement:
+			keyword = "throw"
+		case *ast.BranchStatement:
+			keyword = s.Token.String()
+		default:
+			continue
+		}
+
+		for _, next := range list[i+1:] {
+			if _, ok := next.(*ast.FunctionDeclaration); ok {
+				continue
+			}
+			in.warnings = append(in.warnings, Warning{
+				Type:    "unreachable",
+				Line:    in.line(in.start(next)),
+				Message: fmt.Sprintf("unreachable code after %s on line %d", keyword, in.line(in.start(stmt))),
+			})
+			break
+		}
+		return
+	}
+}
+
+// precisionWarning flags a captured number that is a hair away from a short
+// decimal, the classic float artefact (0.1 + 0.2 == 0.30000000000000004).
+// Genuinely long values such as 1/3 are left alone. Only numbers are
+// looked at: exporting an object would call its getters.
+func precisionWarning(name string, line int, v goja.Value) (Warning, bool) {
+	if _, isObject := v.(*goja.Object); isObject || !goja.IsNumber(v) {
+		return Warning{}, false
+	}
+	f := v.ToFloat()
+	if math.IsNaN(f) || math.IsInf(f, 0) || f == math.Trunc(f) {
+		return Warning{}, false
+	}
+
+	exact := strconv.FormatFloat(f, 'g', -1, 64)
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'g', 12, 64), 64)
+	short := strconv.FormatFloat(rounded, 'g', -1, 64)
+	if rounded == f || significantDigits(exact) < 15 || significantDigits(short) > 8 {
+		return Warning{}, false
+	}
+
+	return Warning{
+		Type:    "precision",
+		Line:    line,
+		Message: fmt.Sprintf("%s is %s, probably meant %s (floating-point rounding error)", name, exact, short),
+	}, true
+}
+
+func significantDigits(s string) int {
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimLeft(strings.NewReplacer("-", "", ".", "").Replace(s), "0")
+	return len(s)
+}
@@ -0,0 +1,156 @@
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often -watch-files looks at the files of the last
+// run; a change is only acted on once a look finds them unchanged again,
+// so a save written in pieces runs once.
+const watchInterval = 250 * time.Millisecond
+
+// fileStamp is what a file is compared by between looks.
+type fileStamp struct {
+	modified time.Time
+	size     int64
+	missing  bool
+}
+
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{missing: true}
+	}
+	return fileStamp{modified: info.ModTime(), size: info.Size()}
+}
+
+// Watch runs the script file named by the session's Config, then again
+// each time it or a module it required changes, printing how the values
+// captured differ from the previous run's. It only returns once stop is
+// closed; a nil stop watches until the process ends.
+func (s *Session) Watch(stop <-chan struct{}) {
+	opts := s.Config.withConsole()
+	var previous map[string]any
+	changed := ""
+	for run := 1; ; run++ {
+		files, names := []string{opts.Script}, []string{opts.Script}
+		script, err := os.ReadFile(opts.Script)
+		if err != nil {
+			fmt.Fprintf(opts.Stderr, "Failed to read %s: %v\n", opts.Script, err)
+		} else {
+			if run > 1 {
+				fmt.Fprintf(opts.Stdout, "\n |> Run %d, %s changed\n", run, changed)
+			}
+			result, _ := s.Run(string(script))
+			if result != nil {
+				current := result.watchedValues()
+				if previous != nil {
+					printRunDiff(previous, current, opts)
+				}
+				previous = current
+				for _, m := range result.State.Modules {
+					if m.Name != "" {
+						files, names = append(files, m.Path), append(names, m.Name)
+					}
+				}
+			}
+		}
+
+		fmt.Fprintf(opts.Stdout, "\n |> Watching %s for changes (Ctrl-C to stop)\n", strings.Join(names, ", "))
+		i, ok := waitForChange(files, stop)
+		if !ok {
+			return
+		}
+		changed = names[i]
+	}
+}
+
+// watchedValues is what a run captured that is compared to the next run:
+// the script's variables and, qualified with the module's name, its
+// modules'.
+func (r *Result) watchedValues() map[string]any {
+	values := make(map[string]any, len(r.Variables))
+	for k, v := range r.Variables {
+		values[k] = v
+	}
+	for _, m := range r.State.Modules {
+		for k, v := range m.Variables {
+			values[m.Name+":"+k] = v
+		}
+	}
+	return values
+}
+
+// waitForChange blocks until one of files changed and then held still for
+// a look, and returns the index of the first that changed, or until stop
+// is closed, which it reports with false.
+func waitForChange(files []string, stop <-chan struct{}) (int, bool) {
+	stamps := make([]fileStamp, len(files))
+	for i, file := range files {
+		stamps[i] = stampOf(file)
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	changed := -1
+	for {
+		select {
+		case <-stop:
+			return 0, false
+		case <-ticker.C:
+		}
+		moved := false
+		for i, file := range files {
+			if stamp := stampOf(file); stamp != stamps[i] {
+				stamps[i], moved = stamp, true
+				if changed < 0 {
+					changed = i
+				}
+			}
+		}
+		// an editor saving by rename leaves the script missing for a moment
+		if !moved && changed >= 0 && !stamps[0].missing {
+			return changed, true
+		}
+	}
+}
+
+// printRunDiff lists the variables whose values differ between two runs
+// of -watch-files, and those only one of them captured.
+func printRunDiff(previous, current map[string]any, opts *Config) {
+	keys := sortedKeys(current)
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var lines []string
+	for _, k := range keys {
+		before, was := previous[k]
+		after, is := current[k]
+		switch {
+		case !was:
+			lines = append(lines, fmt.Sprintf("%s+ %s: %s", opts.Indent, k, inspectLine(after, opts)))
+		case !is:
+			lines = append(lines, fmt.Sprintf("%s- %s: %s", opts.Indent, k, inspectLine(before, opts)))
+		default:
+			if old, now := inspectLine(before, opts), inspectLine(after, opts); old != now {
+				lines = append(lines, fmt.Sprintf("%s~ %s: %s → %s", opts.Indent, k, old, now))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		fmt.Fprintln(opts.Stdout, "\n |> No captured value changed since the previous run")
+		return
+	}
+	fmt.Fprintln(opts.Stdout, "\n |> Changes since the previous run: ")
+	for _, line := range lines {
+		fmt.Fprintln(opts.Stdout, line)
+	}
+}